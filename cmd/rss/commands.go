@@ -0,0 +1,348 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/user/rss/src/config"
+	"github.com/user/rss/src/lockfile"
+	"github.com/user/rss/src/parser"
+	"github.com/user/rss/src/server"
+	"github.com/user/rss/src/tui"
+)
+
+// openStorage applies the fetch settings, locks the data directory and opens the
+// storage in it. The lock keeps the server and commands from overwriting each
+// other's changes; release it after closing the storage.
+func openStorage(cfg config.Config) (*parser.Storage, *lockfile.Lock, error) {
+	parser.ConfigureFetch(parser.FetchConfig{
+		Timeout:   time.Duration(cfg.Fetch.Timeout),
+		UserAgent: cfg.Fetch.UserAgent,
+	})
+
+	// Ensure data directory exists
+	dataDir := cfg.Storage.DataDir
+	if err := os.MkdirAll(dataDir, 0755); err != nil {
+		return nil, nil, err
+	}
+	lock, err := lockfile.Acquire(filepath.Join(dataDir, "rss.lock"))
+	if errors.Is(err, lockfile.ErrLocked) {
+		return nil, nil, fmt.Errorf("data directory %s is in use by another rss process, stop it first: %v", dataDir, err)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Create a new storage with JSON persistence
+	storageConfig := parser.DefaultStorageConfig()
+	storageConfig.FilePath = filepath.Join(dataDir, "feeds.json")
+	storageConfig.ItemsFilePath = filepath.Join(dataDir, "items.json")
+	storageConfig.UsersFilePath = filepath.Join(dataDir, "users.json")
+	storageConfig.SaveDelay = time.Duration(cfg.Storage.SaveDelay)
+	return parser.NewStorage(storageConfig), lock, nil
+}
+
+// command holds what the subscription commands share: parsed arguments, the
+// storage and the account to work on
+type command struct {
+	args    []string
+	storage *parser.Storage
+	user    string
+}
+
+// runCommand parses flags, opens the storage and runs fn, saving any changes
+// afterwards. want is the number of positional arguments fn expects.
+func runCommand(fs *flag.FlagSet, args []string, want int, argsUsage string, fn func(*command) error) int {
+	user := fs.String("user", "", "Account to work on (default: the only account)")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), strings.TrimSpace("usage: "+fs.Name()+" [flags] "+argsUsage))
+		fs.PrintDefaults()
+	}
+
+	cfg, positional, err := loadConfig(fs, args)
+	if errors.Is(err, flag.ErrHelp) {
+		return 0
+	}
+	if err == nil && len(positional) != want {
+		fs.Usage()
+		return 2
+	}
+	if err == nil {
+		err = cfg.Validate()
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	storage, lock, err := openStorage(cfg)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer lock.Release()
+	// Never overwrite data we could not read
+	if !storage.Loaded() {
+		fmt.Fprintf(os.Stderr, "Could not load the data in %s, not making any changes\n", cfg.Storage.DataDir)
+		return 1
+	}
+
+	cmd := &command{args: positional, storage: storage}
+	cmd.user, err = commandUser(storage, *user)
+	if err == nil {
+		err = fn(cmd)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(cfg.Server.ShutdownTimeout))
+	defer cancel()
+	if closeErr := storage.Close(ctx); closeErr != nil && err == nil {
+		err = fmt.Errorf("saving changes: %v", closeErr)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+// commandUser picks the account a command works on: the named one, or the only
+// account if there is just one. Without any accounts commands work on the shared
+// feeds, which the first account adopts.
+func commandUser(storage *parser.Storage, name string) (string, error) {
+	if name != "" {
+		if !storage.HasUser(name) {
+			return "", parser.ErrUserNotFound
+		}
+		return name, nil
+	}
+
+	users := storage.Users()
+	switch len(users) {
+	case 0:
+		return "", nil
+	case 1:
+		return users[0].Username, nil
+	}
+	return "", errors.New("there are several accounts, choose one with -user")
+}
+
+// feeds returns the feeds the command works on
+func (c *command) feeds() []*parser.Feed {
+	if c.user == "" {
+		return c.storage.GetAllFeeds()
+	}
+	return c.storage.Subscriptions(c.user)
+}
+
+// isStored returns true if the feed is one of the feeds the command works on
+func (c *command) isStored(url string) bool {
+	if c.user == "" {
+		_, err := c.storage.CachedFeed(url)
+		return err == nil
+	}
+	return c.storage.IsSubscribed(c.user, url)
+}
+
+// addCommand implements "rss add <url>"
+func addCommand(args []string) int {
+	fs := flag.NewFlagSet("rss add", flag.ContinueOnError)
+	folder := fs.String("folder", "", "Folder to put the feed in")
+	var scrape parser.ScrapeConfig
+	fs.StringVar(&scrape.Item, "item", "", "Scrape a page without a feed: CSS selector of each item")
+	fs.StringVar(&scrape.Title, "title", "", "Selector of a scraped item's title")
+	fs.StringVar(&scrape.Link, "link", "", "Selector of a scraped item's link (default: its first link)")
+	fs.StringVar(&scrape.Date, "date", "", "Selector of a scraped item's date, e.g. time@datetime")
+	fs.StringVar(&scrape.Summary, "summary", "", "Selector of a scraped item's summary")
+	jsonSource := fs.Bool("json", false, "Read a JSON API, mapping fields with the -json-* flags")
+	var source parser.JSONSource
+	fs.StringVar(&source.Name, "json-name", "", "Title of a JSON API feed (default: the URL)")
+	fs.StringVar(&source.Items, "json-items", "", "Path of the JSON API's item array, e.g. data.releases (default: the response)")
+	fs.StringVar(&source.Title, "json-title", "", "Path of a JSON item's title (default: title or name)")
+	fs.StringVar(&source.Link, "json-link", "", "Path of a JSON item's link (default: url, link or html_url)")
+	fs.StringVar(&source.Date, "json-date", "", "Path of a JSON item's date (default: published_at, date or created_at)")
+	fs.StringVar(&source.ID, "json-id", "", "Path of a JSON item's id (default: id or guid)")
+	fs.StringVar(&source.Content, "json-content", "", "Path of a JSON item's content (default: content, body or description)")
+
+	return runCommand(fs, args, 1, "<url>", func(c *command) error {
+		var feed *parser.Feed
+		var err error
+		if scrape.Item != "" {
+			feed, err = parser.ScrapeFeed(c.args[0], scrape)
+		} else if *jsonSource {
+			feed, err = parser.FetchJSONFeed(c.args[0], source)
+		} else {
+			feed, err = parser.FetchFeed(c.args[0])
+		}
+		if err != nil {
+			return err
+		}
+		feed.Folder = *folder
+
+		if c.user == "" {
+			err = c.storage.AddFeed(feed)
+		} else {
+			err = c.storage.Subscribe(c.user, feed)
+		}
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Added %s (%s)\n", feed.Title, feed.URL)
+		return nil
+	})
+}
+
+// removeCommand implements "rss remove <url>"
+func removeCommand(args []string) int {
+	fs := flag.NewFlagSet("rss remove", flag.ContinueOnError)
+
+	return runCommand(fs, args, 1, "<url>", func(c *command) error {
+		url := c.args[0]
+		var err error
+		if c.user == "" {
+			err = c.storage.RemoveFeed(url)
+		} else {
+			_, err = c.storage.Unsubscribe(c.user, url)
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %v", url, err)
+		}
+		fmt.Printf("Removed %s\n", url)
+		return nil
+	})
+}
+
+// feedJSON is a subscription as printed by "rss list -json"
+type feedJSON struct {
+	ID        int64     `json:"id"`
+	URL       string    `json:"url"`
+	Title     string    `json:"title"`
+	Folder    string    `json:"folder,omitempty"`
+	Items     int       `json:"items"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// listCommand implements "rss list"
+func listCommand(args []string) int {
+	fs := flag.NewFlagSet("rss list", flag.ContinueOnError)
+	asJSON := fs.Bool("json", false, "Print the subscriptions as JSON")
+
+	return runCommand(fs, args, 0, "", func(c *command) error {
+		feeds := c.feeds()
+
+		if *asJSON {
+			list := make([]feedJSON, 0, len(feeds))
+			for _, feed := range feeds {
+				// Subscription lists leave out the items, the cache has them
+				items := 0
+				if cached, err := c.storage.CachedFeed(feed.URL); err == nil {
+					items = len(cached.Items)
+				}
+				list = append(list, feedJSON{
+					ID:        feed.ID,
+					URL:       feed.URL,
+					Title:     feed.Title,
+					Folder:    feed.Folder,
+					Items:     items,
+					UpdatedAt: feed.UpdatedAt,
+				})
+			}
+			return printJSON(list)
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tTITLE\tFOLDER\tURL")
+		for _, feed := range feeds {
+			fmt.Fprintf(w, "%d\t%s\t%s\t%s\n", feed.ID, feed.Title, feed.Folder, feed.URL)
+		}
+		return w.Flush()
+	})
+}
+
+// fetchCommand implements "rss fetch <url>". Stored feeds are refreshed and
+// their new items kept, other feeds are only printed.
+func fetchCommand(args []string) int {
+	fs := flag.NewFlagSet("rss fetch", flag.ContinueOnError)
+	asJSON := fs.Bool("json", false, "Print the feed as JSON")
+
+	return runCommand(fs, args, 1, "<url>", func(c *command) error {
+		url := c.args[0]
+		var feed *parser.Feed
+		var err error
+		if c.isStored(url) {
+			feed, err = c.storage.GetFeed(url)
+		} else {
+			feed, err = parser.FetchFeed(url)
+		}
+		if err != nil {
+			return err
+		}
+
+		if *asJSON {
+			return printJSON(feed)
+		}
+
+		fmt.Printf("%s\n%s\n\n", feed.Title, feed.URL)
+		for _, item := range feed.Items {
+			published := "-"
+			if !item.PublishedAt.IsZero() {
+				published = item.PublishedAt.Format("2006-01-02 15:04")
+			}
+			fmt.Printf("%s  %s\n    %s\n", published, item.Title, item.Link)
+		}
+		return nil
+	})
+}
+
+// exportCommand implements "rss export <url>"
+func exportCommand(args []string) int {
+	fs := flag.NewFlagSet("rss export", flag.ContinueOnError)
+	format := fs.String("format", "rss", "Output format: rss, atom or json")
+
+	return runCommand(fs, args, 1, "<url>", func(c *command) error {
+		url := c.args[0]
+		if _, ok := server.ExportContentType(*format); !ok {
+			return fmt.Errorf("unsupported format %q, use rss, atom or json", *format)
+		}
+		if !c.isStored(url) {
+			return fmt.Errorf("%s: feed not found", url)
+		}
+
+		feed, err := c.storage.GetFeed(url)
+		if err != nil {
+			return err
+		}
+		rendered, err := server.RenderFeed(feed, *format)
+		if err != nil {
+			return err
+		}
+		fmt.Println(rendered)
+		return nil
+	})
+}
+
+// tuiCommand implements "rss tui"
+func tuiCommand(args []string) int {
+	fs := flag.NewFlagSet("rss tui", flag.ContinueOnError)
+
+	return runCommand(fs, args, 0, "", func(c *command) error {
+		if c.user == "" {
+			return errors.New("create an account first, the reader keeps track of what you read per account")
+		}
+		return tui.NewApp(c.storage, c.user).Run(os.Stdin, os.Stdout)
+	})
+}
+
+// printJSON prints a value as indented JSON
+func printJSON(v interface{}) error {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
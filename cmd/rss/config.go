@@ -0,0 +1,173 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/user/rss/src/config"
+	"github.com/user/rss/src/parser"
+)
+
+// loadConfig builds the effective configuration from defaults, the config file,
+// RSS_* environment variables and finally the command line flags in args.
+// It returns the remaining positional arguments. The result still needs to be validated.
+func loadConfig(fs *flag.FlagSet, args []string) (config.Config, []string, error) {
+	return loadConfigFrom(fs, args, os.LookupEnv)
+}
+
+// loadConfigFrom is loadConfig with environment variables read through lookup
+func loadConfigFrom(fs *flag.FlagSet, args []string, lookup func(string) (string, bool)) (config.Config, []string, error) {
+	path := configPath(args, lookup)
+	cfg, err := config.LoadFrom(path, lookup)
+	if err != nil {
+		return cfg, nil, err
+	}
+
+	fs.String("config", path, "Path to a YAML or TOML config file (default from RSS_CONFIG)")
+	registerFlags(fs, &cfg)
+	positional, err := parseFlags(fs, args)
+	return cfg, positional, err
+}
+
+// parseFlags parses flags that may appear before, between or after positional
+// arguments, so "rss export <url> -format atom" works
+func parseFlags(fs *flag.FlagSet, args []string) ([]string, error) {
+	positional := make([]string, 0)
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		rest := fs.Args()
+		if len(rest) == 0 {
+			return positional, nil
+		}
+		// Everything after a "--" terminator is positional
+		if consumed := len(args) - len(rest); consumed > 0 && args[consumed-1] == "--" {
+			return append(positional, rest...), nil
+		}
+		positional = append(positional, rest[0])
+		args = rest[1:]
+	}
+}
+
+// configPath finds the -config flag before the other flags are parsed, since
+// the file provides their defaults
+func configPath(args []string, lookup func(string) (string, bool)) string {
+	path, _ := lookup("RSS_CONFIG")
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			break
+		}
+		name := strings.TrimLeft(arg, "-")
+		if name == arg {
+			continue
+		}
+		if name == "config" && i+1 < len(args) {
+			path = args[i+1]
+			i++
+		} else if strings.HasPrefix(name, "config=") {
+			path = strings.TrimPrefix(name, "config=")
+		}
+	}
+	return path
+}
+
+// registerFlags binds command line flags to the configuration, using its
+// current values as defaults
+func registerFlags(fs *flag.FlagSet, cfg *config.Config) {
+	fs.IntVar(&cfg.Server.Port, "port", cfg.Server.Port, "HTTP server port")
+	fs.Var((*listFlag)(&cfg.Storage.DefaultFeeds), "feeds", "Comma-separated list of default RSS feed URLs")
+	fs.StringVar(&cfg.Storage.DataDir, "data", cfg.Storage.DataDir, "Directory to store data files")
+	fs.StringVar(&cfg.Server.BaseURL, "base-url", cfg.Server.BaseURL, "Public base URL of this server, required for WebSub push updates and the hub (e.g. https://rss.example.com)")
+	fs.Var(&cfg.Digest.Interval, "digest-interval", "How often to send the email digest (e.g. 24h, 168h)")
+	fs.StringVar(&cfg.Digest.User, "digest-user", cfg.Digest.User, "Account whose subscriptions and folders the digest covers (default: all feeds)")
+	fs.Var((*listFlag)(&cfg.Digest.Feeds), "digest-feeds", "Comma-separated list of feed URLs to include in the digest (default: all)")
+	fs.Var((*listFlag)(&cfg.Digest.Folders), "digest-folders", "Comma-separated list of folders to include in the digest")
+	fs.Var((*listFlag)(&cfg.Digest.To), "digest-to", "Comma-separated list of digest recipients")
+	fs.StringVar(&cfg.SMTP.Host, "smtp-host", cfg.SMTP.Host, "SMTP server host for the email digest")
+	fs.IntVar(&cfg.SMTP.Port, "smtp-port", cfg.SMTP.Port, "SMTP server port")
+	fs.StringVar(&cfg.SMTP.Username, "smtp-user", cfg.SMTP.Username, "SMTP username (password is read from RSS_SMTP_PASSWORD or the config file)")
+	fs.StringVar(&cfg.SMTP.From, "smtp-from", cfg.SMTP.From, "Sender address for the email digest")
+	fs.Var(&cfg.Server.ShutdownTimeout, "shutdown-timeout", "How long to wait for requests and background work to finish when shutting down")
+	fs.Var(&cfg.Scheduler.RefreshInterval, "refresh-interval", "How often to refresh all feeds in the background")
+	fs.Var(&cfg.Fetch.Timeout, "fetch-timeout", "Timeout for fetching a single feed")
+	fs.StringVar(&cfg.Fetch.UserAgent, "user-agent", cfg.Fetch.UserAgent, "User-Agent header sent when fetching feeds")
+	fs.BoolVar(&cfg.Server.PublicMetrics, "public-metrics", cfg.Server.PublicMetrics, "Serve /metrics without authentication (it otherwise requires an admin account)")
+	fs.BoolVar(&cfg.Server.PublicExport, "public-export", cfg.Server.PublicExport, "Serve /export without authentication (read-only)")
+	fs.Var(&cfg.Archive.Interval, "archive-interval", "How often to download new episodes of archived feeds")
+	fs.IntVar(&cfg.Archive.MaxEpisodes, "archive-max-episodes", cfg.Archive.MaxEpisodes, "Number of newest episodes kept per archived feed")
+	fs.IntVar(&cfg.Archive.QuotaMB, "archive-quota", cfg.Archive.QuotaMB, "Disk space for archived episodes in megabytes (0 for no limit)")
+	fs.BoolVar(&cfg.Images.Proxy, "image-proxy", cfg.Images.Proxy, "Load images in item content through the server instead of from their hosts")
+	fs.IntVar(&cfg.Images.CacheMB, "image-cache", cfg.Images.CacheMB, "Disk space for cached images in megabytes")
+	fs.Var(&cfg.Icons.Interval, "icon-interval", "How often to download the icon of each feed's site again")
+	fs.BoolVar(&cfg.Auth.AllowSignup, "allow-signup", cfg.Auth.AllowSignup, "Allow anyone to create an account (the first account can always be created)")
+}
+
+// configCommand implements "rss config validate" and "rss config print"
+func configCommand(args []string) int {
+	usage := "usage: rss config validate|print [-format yaml|toml|json] [flags]"
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, usage)
+		return 2
+	}
+
+	fs := flag.NewFlagSet("config "+args[0], flag.ContinueOnError)
+	format := fs.String("format", "yaml", "Output format of print: yaml, toml or json")
+	cfg, _, err := loadConfig(fs, args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return 0
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	err = cfg.Validate()
+
+	switch args[0] {
+	case "validate":
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid configuration:\n%v\n", err)
+			return 1
+		}
+		fmt.Println("Configuration is valid")
+		return 0
+	case "print":
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: invalid configuration:\n%v\n\n", err)
+		}
+		out, encodeErr := config.Encode(cfg.Redacted(), *format)
+		if encodeErr != nil {
+			fmt.Fprintln(os.Stderr, encodeErr)
+			return 1
+		}
+		os.Stdout.Write(out)
+		if err != nil {
+			return 1
+		}
+		return 0
+	}
+
+	fmt.Fprintln(os.Stderr, usage)
+	return 2
+}
+
+// listFlag is a comma-separated list flag
+type listFlag []string
+
+// String returns the list joined with commas
+func (l *listFlag) String() string {
+	if l == nil {
+		return ""
+	}
+	return strings.Join(*l, ",")
+}
+
+// Set replaces the list with the comma-separated values
+func (l *listFlag) Set(value string) error {
+	*l = parser.SplitURLs(value)
+	return nil
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// env returns a lookup function reading from a map
+func env(vars map[string]string) func(string) (string, bool) {
+	return func(name string) (string, bool) {
+		value, ok := vars[name]
+		return value, ok
+	}
+}
+
+// newFlagSet returns a flag set that reports errors instead of exiting
+func newFlagSet() *flag.FlagSet {
+	fs := flag.NewFlagSet("rss", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	return fs
+}
+
+func TestLoadConfigPrecedence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rss.yaml")
+	content := "server:\n  port: 8080\nfetch:\n  user_agent: from-file\n  timeout: 10s\nstorage:\n  data_dir: file-data\n"
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	lookup := env(map[string]string{
+		"RSS_CONFIG":     path,
+		"RSS_PORT":       "9090",
+		"RSS_USER_AGENT": "from-env",
+	})
+
+	cfg, positional, err := loadConfigFrom(newFlagSet(), []string{"export", "-port", "7070", "http://example.com/feed", "--", "-data"}, lookup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Server.Port != 7070 {
+		t.Errorf("port = %d, want 7070 from the flag", cfg.Server.Port)
+	}
+	if cfg.Fetch.UserAgent != "from-env" {
+		t.Errorf("user agent = %q, want the environment's", cfg.Fetch.UserAgent)
+	}
+	if time.Duration(cfg.Fetch.Timeout) != 10*time.Second || cfg.Storage.DataDir != "file-data" {
+		t.Errorf("file settings were not applied: %+v", cfg)
+	}
+	if time.Duration(cfg.Scheduler.RefreshInterval) != 30*time.Minute {
+		t.Errorf("refresh interval = %v, want the default", cfg.Scheduler.RefreshInterval)
+	}
+	if want := []string{"export", "http://example.com/feed", "-data"}; !reflect.DeepEqual(positional, want) {
+		t.Errorf("positional arguments = %q, want %q", positional, want)
+	}
+}
+
+func TestLoadConfigFlagOverridesEnvFile(t *testing.T) {
+	dir := t.TempDir()
+	fromEnv := filepath.Join(dir, "env.toml")
+	fromFlag := filepath.Join(dir, "flag.toml")
+	for path, port := range map[string]string{fromEnv: "1111", fromFlag: "2222"} {
+		if err := os.WriteFile(path, []byte("[server]\nport = "+port+"\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cfg, _, err := loadConfigFrom(newFlagSet(), []string{"-config=" + fromFlag, "-feeds", "http://a.example.com/feed,http://b.example.com/feed"},
+		env(map[string]string{"RSS_CONFIG": fromEnv}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Server.Port != 2222 {
+		t.Errorf("port = %d, want 2222 from the file given by -config", cfg.Server.Port)
+	}
+	if want := []string{"http://a.example.com/feed", "http://b.example.com/feed"}; !reflect.DeepEqual(cfg.Storage.DefaultFeeds, want) {
+		t.Errorf("default feeds = %v, want %v", cfg.Storage.DefaultFeeds, want)
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	if _, _, err := loadConfigFrom(newFlagSet(), nil, env(map[string]string{"RSS_REFRESH_INTERVAL": "often"})); err == nil {
+		t.Error("an invalid duration in the environment was accepted")
+	}
+	if _, _, err := loadConfigFrom(newFlagSet(), []string{"-refresh-interval", "often"}, env(nil)); err == nil {
+		t.Error("an invalid duration flag was accepted")
+	}
+	if _, _, err := loadConfigFrom(newFlagSet(), []string{"-config", filepath.Join(t.TempDir(), "missing.yaml")}, env(nil)); err == nil {
+		t.Error("a missing config file was accepted")
+	}
+}
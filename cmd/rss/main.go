@@ -1,43 +1,111 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"log"
 	"os"
 	"os/signal"
 	"path/filepath"
+	"strings"
 	"syscall"
+	"time"
 
+	"github.com/user/rss/src/archive"
+	"github.com/user/rss/src/auth"
+	"github.com/user/rss/src/config"
+	"github.com/user/rss/src/digest"
+	"github.com/user/rss/src/favicon"
+	"github.com/user/rss/src/imageproxy"
+	"github.com/user/rss/src/metrics"
 	"github.com/user/rss/src/parser"
+	"github.com/user/rss/src/scheduler"
 	"github.com/user/rss/src/server"
+	"github.com/user/rss/src/websub"
 )
 
+// commands are the subcommands of the binary. Without one the server is started.
+var commands = map[string]func(args []string) int{
+	"serve":  serveCommand,
+	"add":    addCommand,
+	"remove": removeCommand,
+	"list":   listCommand,
+	"fetch":  fetchCommand,
+	"export": exportCommand,
+	"tui":    tuiCommand,
+	"config": configCommand,
+}
+
+const usage = `usage: rss [command] [flags]
+
+Commands:
+  serve               Run the web server (default)
+  add <url>           Subscribe to a feed
+  remove <url>        Unsubscribe from a feed
+  list                List subscriptions
+  fetch <url>         Fetch a feed and print its items
+  export <url>        Print a stored feed as RSS, Atom or JSON Feed
+  tui                 Read feeds in the terminal
+  config validate     Check the configuration
+  config print        Print the effective configuration
+
+Run "rss <command> -h" for the flags of a command.`
+
 func main() {
-	// Parse command line flags
-	port := flag.Int("port", 3030, "HTTP server port")
-	defaultFeeds := flag.String("feeds", "", "Comma-separated list of default RSS feed URLs")
-	dataDir := flag.String("data", "data", "Directory to store data files")
-	flag.Parse()
+	name, args := "serve", os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	}
 
-	// Ensure data directory exists
-	if err := os.MkdirAll(*dataDir, 0755); err != nil {
-		log.Fatalf("Failed to create data directory: %v", err)
+	command, ok := commands[name]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Unknown command %q\n\n%s\n", name, usage)
+		os.Exit(2)
 	}
+	os.Exit(command(args))
+}
 
-	// Create a new storage with JSON persistence
-	feedsFile := filepath.Join(*dataDir, "feeds.json")
-	storageConfig := parser.StorageConfig{
-		FilePath: feedsFile,
-		AutoSave: true,
+// serveCommand implements "rss serve"
+func serveCommand(args []string) int {
+	fs := flag.NewFlagSet("rss serve", flag.ExitOnError)
+	cfg, rest, err := loadConfig(fs, args)
+	if err == nil && len(rest) > 0 {
+		err = fmt.Errorf("unexpected arguments %v", rest)
+	}
+	if err == nil {
+		err = cfg.Validate()
+	}
+	if err != nil {
+		log.Printf("Invalid configuration: %v", err)
+		return 2
 	}
-	storage := parser.NewStorage(storageConfig)
 
-	// Add default feeds if specified and if storage is empty
-	if *defaultFeeds != "" && len(storage.GetAllFeeds()) == 0 {
+	serve(cfg)
+	return 0
+}
+
+// serve runs the web server and background jobs until interrupted
+func serve(cfg config.Config) {
+	dataDir := cfg.Storage.DataDir
+	feedsFile := filepath.Join(dataDir, "feeds.json")
+	storage, lock, err := openStorage(cfg)
+	if err != nil {
+		log.Fatalf("Failed to open data directory: %v", err)
+	}
+	defer lock.Release()
+	// Running would save over the data that couldn't be read
+	if !storage.Loaded() {
+		log.Fatalf("Could not load the data in %s, not starting. Restore or move the damaged files and start again.", dataDir)
+	}
+	if err := storage.RegisterMetrics(metrics.Default); err != nil {
+		log.Printf("Failed to register storage metrics: %v", err)
+	}
+
+	// Add default feeds if specified and if storage is empty. They are adopted by the first account.
+	if len(cfg.Storage.DefaultFeeds) > 0 && len(storage.GetAllFeeds()) == 0 && !storage.HasUsers() {
 		log.Println("Adding default feeds")
-		urls := parser.SplitURLs(*defaultFeeds)
-		for _, url := range urls {
+		for _, url := range cfg.Storage.DefaultFeeds {
 			log.Printf("Fetching default feed: %s", url)
 			feed, err := parser.FetchFeed(url)
 			if err != nil {
@@ -51,9 +119,133 @@ func main() {
 		}
 	}
 
+	// Set up the email digest
+	digestConfig := digest.DefaultConfig()
+	digestConfig.Interval = time.Duration(cfg.Digest.Interval)
+	digestConfig.User = cfg.Digest.User
+	digestConfig.Feeds = cfg.Digest.Feeds
+	digestConfig.Folders = cfg.Digest.Folders
+	digestConfig.MaxItems = cfg.Digest.MaxItems
+	digestConfig.Subject = cfg.Digest.Subject
+	digestConfig.StateFile = filepath.Join(dataDir, "digest.json")
+	digestConfig.SMTP.Host = cfg.SMTP.Host
+	digestConfig.SMTP.Port = cfg.SMTP.Port
+	digestConfig.SMTP.Username = cfg.SMTP.Username
+	digestConfig.SMTP.Password = cfg.SMTP.Password
+	digestConfig.SMTP.From = cfg.SMTP.From
+	digestConfig.SMTP.To = cfg.Digest.To
+	digester := digest.NewDigest(storage, digestConfig)
+
+	// Start background jobs
+	sched := scheduler.NewScheduler()
+	sched.Add("refresh", time.Duration(cfg.Scheduler.RefreshInterval), storage.RefreshAll)
+	sched.Add("save", time.Duration(cfg.Scheduler.SaveInterval), storage.SaveIfNeeded)
+
+	// Browser sessions of logged in users
+	sessionConfig := auth.DefaultSessionConfig()
+	sessionConfig.StateFile = filepath.Join(dataDir, "sessions.json")
+	sessionConfig.MaxAge = time.Duration(cfg.Auth.SessionMaxAge)
+	sessions := auth.NewSessionStore(sessionConfig)
+	sched.Add("session-expire", time.Hour, sessions.ExpireSessions)
+
+	// API tokens for scripts
+	tokenConfig := auth.DefaultTokenConfig()
+	tokenConfig.StateFile = filepath.Join(dataDir, "tokens.json")
+	tokens := auth.NewTokenStore(tokenConfig)
+	if digester.Enabled() {
+		sched.Add("digest", time.Hour, digester.SendIfDue)
+		log.Printf("Email digest enabled every %s for %v", cfg.Digest.Interval, digestConfig.SMTP.To)
+	}
+
+	// Subscribe to WebSub hubs for push updates when we are reachable from outside
+	var subscriber *websub.Subscriber
+	if cfg.Server.BaseURL != "" {
+		subscriberConfig := websub.DefaultSubscriberConfig()
+		subscriberConfig.CallbackBase = cfg.Server.BaseURL
+		subscriberConfig.StateFile = filepath.Join(dataDir, "websub.json")
+		subscriber = websub.NewSubscriber(storage, subscriberConfig)
+		sched.Add("websub-renew", 10*time.Minute, subscriber.RenewExpiring)
+	}
+
+	// Run a WebSub hub for our exported feeds when anyone may fetch them
+	var hub *websub.Hub
+	if cfg.Server.BaseURL != "" && cfg.Server.PublicExport {
+		hubConfig := websub.DefaultHubConfig()
+		hubConfig.HubURL = strings.TrimSuffix(cfg.Server.BaseURL, "/") + "/websub/hub"
+		hubConfig.StateFile = filepath.Join(dataDir, "websub_hub.json")
+		hub = websub.NewHub(hubConfig)
+		sched.Add("websub-hub-poll", 15*time.Minute, func() error {
+			return hub.PollFeeds(storage)
+		})
+		sched.Add("websub-hub-expire", time.Hour, hub.ExpireSubscriptions)
+	}
+
+	// Download episodes of feeds that opted in to archiving
+	archiveConfig := archive.DefaultConfig()
+	archiveConfig.Dir = cfg.Archive.Dir
+	if archiveConfig.Dir == "" {
+		archiveConfig.Dir = filepath.Join(dataDir, "archive")
+	}
+	archiveConfig.StateFile = filepath.Join(dataDir, "archive.json")
+	archiveConfig.MaxEpisodes = cfg.Archive.MaxEpisodes
+	archiveConfig.Quota = int64(cfg.Archive.QuotaMB) << 20
+	archiveConfig.UserAgent = cfg.Fetch.UserAgent
+	archiver := archive.NewArchive(storage, archiveConfig)
+	sched.Add("archive", time.Duration(cfg.Archive.Interval), archiver.Run)
+
+	// Fetch the icon of each feed's site for the sidebar, refreshing those that are due every hour
+	iconConfig := favicon.DefaultConfig()
+	iconConfig.Dir = cfg.Icons.Dir
+	if iconConfig.Dir == "" {
+		iconConfig.Dir = filepath.Join(dataDir, "icons")
+	}
+	iconConfig.StateFile = filepath.Join(dataDir, "icons.json")
+	iconConfig.MaxAge = time.Duration(cfg.Icons.Interval)
+	iconConfig.Timeout = time.Duration(cfg.Fetch.Timeout)
+	iconConfig.UserAgent = cfg.Fetch.UserAgent
+	favicons := favicon.NewFavicons(storage, iconConfig)
+	sched.Add("icons", time.Hour, favicons.Run)
+	sched.Start()
+
+	// Serve images in item content from a local cache
+	var images *imageproxy.Proxy
+	if cfg.Images.Proxy {
+		imageConfig := imageproxy.DefaultConfig()
+		imageConfig.Dir = cfg.Images.Dir
+		if imageConfig.Dir == "" {
+			imageConfig.Dir = filepath.Join(dataDir, "images")
+		}
+		imageConfig.KeyFile = filepath.Join(dataDir, "image_proxy.key")
+		imageConfig.MaxSize = int64(cfg.Images.MaxSizeMB) << 20
+		imageConfig.CacheSize = int64(cfg.Images.CacheMB) << 20
+		imageConfig.Timeout = time.Duration(cfg.Fetch.Timeout)
+		imageConfig.UserAgent = cfg.Fetch.UserAgent
+		if images, err = imageproxy.NewProxy(imageConfig); err != nil {
+			log.Printf("Warning: Image proxy disabled: %v", err)
+		}
+	}
+
 	// Create and start the HTTP server
-	srv := server.NewServer(storage)
-	addr := fmt.Sprintf(":%d", *port)
+	serverConfig := server.Config{
+		BaseURL:       cfg.Server.BaseURL,
+		Sessions:      sessions,
+		Tokens:        tokens,
+		AllowSignup:   cfg.Auth.AllowSignup,
+		PublicExport:  cfg.Server.PublicExport,
+		PublicMetrics: cfg.Server.PublicMetrics,
+		Scheduler:     sched,
+		Digest:        digester,
+		Subscriber:    subscriber,
+		Hub:           hub,
+		Archive:       archiver,
+		ImageProxy:    images,
+		Favicons:      favicons,
+	}
+	if !storage.HasUsers() {
+		log.Printf("No accounts yet, create the first one at http://localhost:%d/register", cfg.Server.Port)
+	}
+	srv := server.NewServer(storage, serverConfig)
+	addr := fmt.Sprintf(":%d", cfg.Server.Port)
 	log.Printf("Starting RSS server on http://localhost%s", addr)
 	log.Printf("Feeds will be saved to %s", feedsFile)
 
@@ -64,14 +256,44 @@ func main() {
 		}
 	}()
 
+	// Fetch icons that are missing or due without waiting for the first scheduled run
+	go func() {
+		if err := favicons.Run(); err != nil {
+			log.Printf("Error fetching feed icons: %v", err)
+		}
+	}()
+
+	// Hubs verify subscriptions through the server, so subscribe once it is running
+	if subscriber != nil {
+		go subscriber.SubscribeAll()
+	}
+
 	// Set up graceful shutdown
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 	<-quit
-	log.Println("Server shutting down...")
+	log.Printf("Server shutting down, waiting up to %s...", cfg.Server.ShutdownTimeout)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(cfg.Server.ShutdownTimeout))
+	defer cancel()
+
+	// Stop taking requests first so nothing new is queued behind the final save
+	if err := srv.Shutdown(ctx); err != nil {
+		log.Printf("Error shutting down HTTP server: %v", err)
+	}
+	if err := archiver.Close(); err != nil {
+		log.Printf("Error stopping episode downloads: %v", err)
+	}
+	if err := sched.Shutdown(ctx); err != nil {
+		log.Printf("Error stopping background jobs: %v", err)
+	}
 
 	// Save any pending changes
-	if err := storage.SaveIfNeeded(); err != nil {
+	if err := storage.Close(ctx); err != nil {
 		log.Printf("Error saving feeds: %v", err)
 	}
+	if err := tokens.Save(); err != nil {
+		log.Printf("Error saving API tokens: %v", err)
+	}
+	log.Println("Server stopped")
 }
@@ -0,0 +1,523 @@
+package archive
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/user/rss/src/netguard"
+	"github.com/user/rss/src/parser"
+)
+
+// errNoSpace is returned when an episode doesn't fit into the disk quota
+var errNoSpace = errors.New("not enough space left in the archive quota")
+
+// File is an episode downloaded, or being downloaded, to the archive
+type File struct {
+	ItemID      int64     `json:"item_id"`
+	FeedURL     string    `json:"feed_url"`
+	Title       string    `json:"title"`
+	URL         string    `json:"url"`
+	Type        string    `json:"type"`
+	PublishedAt time.Time `json:"published_at"`
+	// Path is relative to the archive directory
+	Path string `json:"path"`
+	// Size is the number of bytes on disk, Length the expected size if known
+	Size         int64     `json:"size"`
+	Length       int64     `json:"length,omitempty"`
+	Complete     bool      `json:"complete"`
+	DownloadedAt time.Time `json:"downloaded_at,omitempty"`
+}
+
+// Config holds configuration for the archive
+type Config struct {
+	Dir       string
+	StateFile string
+	// MaxEpisodes is how many of the newest episodes of a feed are kept, unless it sets its own limit
+	MaxEpisodes int
+	// Quota is the disk space in bytes all episodes may use; 0 means no limit
+	Quota     int64
+	Timeout   time.Duration
+	UserAgent string
+}
+
+// DefaultConfig returns a default configuration
+func DefaultConfig() Config {
+	return Config{
+		Dir:         "archive",
+		StateFile:   "archive.json",
+		MaxEpisodes: 5,
+		Quota:       10 << 30,
+		Timeout:     2 * time.Hour,
+		UserAgent:   parser.DefaultFetchConfig().UserAgent,
+	}
+}
+
+// Archive downloads the episodes of feeds that opted in, so they can still be
+// played after they disappear upstream
+type Archive struct {
+	config    Config
+	storage   *parser.Storage
+	files     map[int64]*File
+	mutex     sync.RWMutex
+	saveMutex sync.Mutex
+	// running keeps scheduled runs from overlapping
+	running sync.Mutex
+	client  *http.Client
+	// ctx is cancelled by Close to interrupt downloads
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// NewArchive creates a new archive instance
+func NewArchive(storage *parser.Storage, config Config) *Archive {
+	a := &Archive{
+		config:  config,
+		storage: storage,
+		files:   make(map[int64]*File),
+		client:  netguard.Client(config.Timeout),
+	}
+	a.ctx, a.cancel = context.WithCancel(context.Background())
+
+	if err := a.loadState(); err != nil {
+		log.Printf("Warning: Failed to load archived episodes: %v", err)
+	}
+	return a
+}
+
+// Close interrupts running downloads and waits for the current run to stop.
+// Partial downloads are resumed by the next run.
+func (a *Archive) Close() error {
+	a.cancel()
+	a.running.Lock()
+	defer a.running.Unlock()
+	return a.saveState()
+}
+
+// File returns a completely downloaded episode by its item ID
+func (a *Archive) File(id int64) (File, bool) {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+
+	file, ok := a.files[id]
+	if !ok || !file.Complete {
+		return File{}, false
+	}
+	return *file, true
+}
+
+// Files returns the archived episodes of a feed, newest first
+func (a *Archive) Files(feedURL string) []File {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+
+	files := make([]File, 0)
+	for _, file := range a.files {
+		if file.FeedURL == feedURL && file.Complete {
+			files = append(files, *file)
+		}
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].PublishedAt.After(files[j].PublishedAt)
+	})
+	return files
+}
+
+// Path returns where an archived episode is stored on disk
+func (a *Archive) Path(file File) string {
+	return filepath.Join(a.config.Dir, filepath.FromSlash(file.Path))
+}
+
+// Usage returns the disk space used by archived and partially downloaded episodes
+func (a *Archive) Usage() int64 {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+	return a.usageLocked()
+}
+
+// Run downloads new episodes of archived feeds, resuming partial downloads, and
+// removes episodes that fell out of a feed's limit or no longer fit the quota.
+// Newer episodes take precedence over older ones when space runs out.
+func (a *Archive) Run() error {
+	if !a.running.TryLock() {
+		return nil
+	}
+	defer a.running.Unlock()
+
+	wanted := a.update()
+
+	var lastErr error
+	for _, file := range wanted {
+		if a.ctx.Err() != nil {
+			break
+		}
+		if file.Complete {
+			continue
+		}
+		err := a.download(file)
+		if errors.Is(err, errNoSpace) {
+			log.Printf("Skipping %s: %v", file.URL, err)
+		} else if err != nil {
+			log.Printf("Error archiving %s: %v", file.URL, err)
+			lastErr = err
+		}
+	}
+
+	a.enforceQuota()
+	if err := a.saveState(); err != nil {
+		return err
+	}
+	return lastErr
+}
+
+// update works out which episodes should be archived, newest first, and
+// removes the ones that shouldn't be anymore
+func (a *Archive) update() []File {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	wanted := make(map[int64]bool)
+	for _, feed := range a.storage.GetAllFeeds() {
+		if !feed.Archive {
+			continue
+		}
+		cached, err := a.storage.CachedFeed(feed.URL)
+		if err != nil {
+			continue
+		}
+
+		// Until the feed has been fetched, keep what was archived before
+		if len(cached.Items) == 0 {
+			for id, file := range a.files {
+				if file.FeedURL == feed.URL {
+					wanted[id] = true
+				}
+			}
+			continue
+		}
+
+		keep := feed.ArchiveKeep
+		if keep <= 0 {
+			keep = a.config.MaxEpisodes
+		}
+		for _, item := range cached.Items {
+			if keep == 0 {
+				break
+			}
+			media, ok := item.MediaEnclosure()
+			if !ok {
+				continue
+			}
+			keep--
+			wanted[item.ID] = true
+			if _, ok := a.files[item.ID]; !ok {
+				a.files[item.ID] = &File{
+					ItemID:      item.ID,
+					FeedURL:     feed.URL,
+					Title:       item.Title,
+					URL:         media.URL,
+					Type:        media.Type,
+					PublishedAt: item.PublishedAt,
+					Path:        fmt.Sprintf("%d/%d%s", feed.ID, item.ID, extension(media)),
+					Length:      media.Length,
+				}
+			}
+		}
+	}
+
+	files := make([]File, 0, len(wanted))
+	for id, file := range a.files {
+		if !wanted[id] {
+			a.removeLocked(file)
+			continue
+		}
+		files = append(files, *file)
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].PublishedAt.After(files[j].PublishedAt)
+	})
+	return files
+}
+
+// download fetches an episode, continuing a partial download where it stopped
+func (a *Archive) download(file File) error {
+	target := a.Path(file)
+	partial := target + ".part"
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+
+	var offset int64
+	if info, err := os.Stat(partial); err == nil {
+		offset = info.Size()
+	}
+
+	req, err := http.NewRequestWithContext(a.ctx, http.MethodGet, file.URL, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("User-Agent", a.config.UserAgent)
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	flags := os.O_CREATE | os.O_WRONLY
+	switch {
+	case resp.StatusCode == http.StatusPartialContent && offset > 0:
+		flags |= os.O_APPEND
+	case resp.StatusCode == http.StatusRequestedRangeNotSatisfiable && offset > 0:
+		// The partial file already holds everything
+		return a.finish(file, partial, target, offset)
+	case resp.StatusCode == http.StatusOK:
+		// The server ignored the range, start over
+		flags |= os.O_TRUNC
+		offset = 0
+	default:
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	if resp.ContentLength > 0 {
+		file.Length = offset + resp.ContentLength
+	}
+	if !a.reserve(file, offset) {
+		return errNoSpace
+	}
+
+	out, err := os.OpenFile(partial, flags, 0644)
+	if err != nil {
+		return err
+	}
+	body := io.Reader(resp.Body)
+	if limit := a.available(file.ItemID); limit >= 0 {
+		// Stop at the quota even if the server didn't say how large the file is
+		body = io.LimitReader(resp.Body, limit-offset+1)
+	}
+	written, err := io.Copy(out, body)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	size := offset + written
+	a.setSize(file.ItemID, size)
+	if err != nil {
+		return err
+	}
+	if size == 0 {
+		return errors.New("empty response")
+	}
+	if limit := a.available(file.ItemID); limit >= 0 && size > limit {
+		os.Remove(partial)
+		a.setSize(file.ItemID, 0)
+		return errNoSpace
+	}
+	return a.finish(file, partial, target, size)
+}
+
+// finish moves a completed download into place
+func (a *Archive) finish(file File, partial, target string, size int64) error {
+	if err := os.Rename(partial, target); err != nil {
+		return err
+	}
+
+	a.mutex.Lock()
+	if stored, ok := a.files[file.ItemID]; ok {
+		stored.Size = size
+		stored.Length = size
+		stored.Complete = true
+		stored.DownloadedAt = time.Now()
+	}
+	a.mutex.Unlock()
+
+	log.Printf("Archived %s (%d bytes)", file.URL, size)
+	return a.saveState()
+}
+
+// reserve makes room for an episode by removing older ones, reporting whether it fits.
+// Episodes of unknown size are let through and checked while downloading.
+func (a *Archive) reserve(file File, offset int64) bool {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	if a.config.Quota <= 0 || file.Length <= 0 {
+		return true
+	}
+
+	stored, ok := a.files[file.ItemID]
+	if !ok {
+		return false
+	}
+	stored.Length = file.Length
+	needed := file.Length - offset
+
+	for a.config.Quota-a.usageLocked() < needed {
+		oldest := a.oldestLocked(file.PublishedAt)
+		if oldest == nil {
+			return false
+		}
+		log.Printf("Removing archived episode %s to stay within the quota", oldest.URL)
+		a.removeLocked(oldest)
+	}
+	return true
+}
+
+// available returns how many bytes an episode may use on disk, or -1 without a quota
+func (a *Archive) available(id int64) int64 {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+
+	if a.config.Quota <= 0 {
+		return -1
+	}
+	used := a.usageLocked()
+	if file, ok := a.files[id]; ok {
+		used -= file.Size
+	}
+	return a.config.Quota - used
+}
+
+// enforceQuota removes the oldest episodes while the archive is larger than its quota,
+// for example after the quota was lowered
+func (a *Archive) enforceQuota() {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	if a.config.Quota <= 0 {
+		return
+	}
+	for a.usageLocked() > a.config.Quota {
+		oldest := a.oldestLocked(time.Time{})
+		if oldest == nil {
+			return
+		}
+		log.Printf("Removing archived episode %s to stay within the quota", oldest.URL)
+		a.removeLocked(oldest)
+	}
+}
+
+// setSize records how much of an episode is on disk
+func (a *Archive) setSize(id int64, size int64) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if file, ok := a.files[id]; ok {
+		file.Size = size
+	}
+}
+
+// usageLocked sums the size of all episodes on disk. The caller must hold the mutex.
+func (a *Archive) usageLocked() int64 {
+	var used int64
+	for _, file := range a.files {
+		used += file.Size
+	}
+	return used
+}
+
+// oldestLocked finds the oldest episode using disk space that was published before
+// the given time, or any episode for a zero time. The caller must hold the mutex.
+func (a *Archive) oldestLocked(before time.Time) *File {
+	var oldest *File
+	for _, file := range a.files {
+		if file.Size == 0 || (!before.IsZero() && !file.PublishedAt.Before(before)) {
+			continue
+		}
+		if oldest == nil || file.PublishedAt.Before(oldest.PublishedAt) {
+			oldest = file
+		}
+	}
+	return oldest
+}
+
+// removeLocked deletes an episode and its partial download. The caller must hold the mutex.
+func (a *Archive) removeLocked(file *File) {
+	target := filepath.Join(a.config.Dir, filepath.FromSlash(file.Path))
+	for _, name := range []string{target, target + ".part"} {
+		if err := os.Remove(name); err != nil && !os.IsNotExist(err) {
+			log.Printf("Error removing archived episode %s: %v", name, err)
+		}
+	}
+	delete(a.files, file.ItemID)
+}
+
+// loadState reads archived episodes from the state file
+func (a *Archive) loadState() error {
+	data, err := ioutil.ReadFile(a.config.StateFile)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var files []*File
+	if err := json.Unmarshal(data, &files); err != nil {
+		return err
+	}
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	for _, file := range files {
+		a.files[file.ItemID] = file
+	}
+	return nil
+}
+
+// saveState writes archived episodes to the state file
+func (a *Archive) saveState() error {
+	a.saveMutex.Lock()
+	defer a.saveMutex.Unlock()
+
+	a.mutex.RLock()
+	files := make([]File, 0, len(a.files))
+	for _, file := range a.files {
+		files = append(files, *file)
+	}
+	a.mutex.RUnlock()
+
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].ItemID < files[j].ItemID
+	})
+	data, err := json.MarshalIndent(files, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tempFile := a.config.StateFile + ".tmp"
+	if err := ioutil.WriteFile(tempFile, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tempFile, a.config.StateFile)
+}
+
+// extension picks a file extension for an episode from its URL or media type
+func extension(media parser.Enclosure) string {
+	rawPath := media.URL
+	if i := strings.IndexAny(rawPath, "?#"); i >= 0 {
+		rawPath = rawPath[:i]
+	}
+	ext := strings.ToLower(path.Ext(rawPath))
+	if len(ext) > 1 && len(ext) <= 6 && strings.Trim(ext[1:], "abcdefghijklmnopqrstuvwxyz0123456789") == "" {
+		return ext
+	}
+	if extensions, err := mime.ExtensionsByType(media.Type); err == nil && len(extensions) > 0 {
+		return extensions[0]
+	}
+	return ".bin"
+}
@@ -0,0 +1,213 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// Session represents a logged in browser
+type Session struct {
+	Username string `json:"username"`
+	// CSRFToken must accompany state-changing requests made with this session
+	CSRFToken string    `json:"csrf_token"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// SessionConfig holds configuration for browser sessions
+type SessionConfig struct {
+	StateFile string
+	MaxAge    time.Duration
+}
+
+// DefaultSessionConfig returns a default configuration
+func DefaultSessionConfig() SessionConfig {
+	return SessionConfig{
+		StateFile: "sessions.json",
+		MaxAge:    30 * 24 * time.Hour,
+	}
+}
+
+// SessionStore keeps track of browser sessions. Only hashes of session IDs are
+// stored, so a leaked state file can't be used to log in.
+type SessionStore struct {
+	config    SessionConfig
+	sessions  map[string]*Session
+	mutex     sync.RWMutex
+	saveMutex sync.Mutex
+}
+
+// NewSessionStore creates a new session store
+func NewSessionStore(config SessionConfig) *SessionStore {
+	store := &SessionStore{
+		config:   config,
+		sessions: make(map[string]*Session),
+	}
+
+	if err := store.loadState(); err != nil {
+		log.Printf("Warning: Failed to load sessions: %v", err)
+	}
+	return store
+}
+
+// MaxAge returns how long sessions last
+func (s *SessionStore) MaxAge() time.Duration {
+	return s.config.MaxAge
+}
+
+// Create starts a session for a user and returns its ID
+func (s *SessionStore) Create(username string) (string, error) {
+	id, err := randomToken(32)
+	if err != nil {
+		return "", err
+	}
+	csrfToken, err := randomToken(32)
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	s.mutex.Lock()
+	s.sessions[hashToken(id)] = &Session{
+		Username:  username,
+		CSRFToken: csrfToken,
+		CreatedAt: now,
+		ExpiresAt: now.Add(s.config.MaxAge),
+	}
+	s.mutex.Unlock()
+
+	return id, s.saveState()
+}
+
+// Get returns the session with the given ID if it hasn't expired
+func (s *SessionStore) Get(id string) (*Session, bool) {
+	if id == "" {
+		return nil, false
+	}
+
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	session, ok := s.sessions[hashToken(id)]
+	if !ok || !session.ExpiresAt.After(time.Now()) {
+		return nil, false
+	}
+	sessionCopy := *session
+	return &sessionCopy, true
+}
+
+// Delete ends a session
+func (s *SessionStore) Delete(id string) error {
+	s.mutex.Lock()
+	delete(s.sessions, hashToken(id))
+	s.mutex.Unlock()
+	return s.saveState()
+}
+
+// DeleteUser ends all sessions of a user
+func (s *SessionStore) DeleteUser(username string) error {
+	s.mutex.Lock()
+	for key, session := range s.sessions {
+		if session.Username == username {
+			delete(s.sessions, key)
+		}
+	}
+	s.mutex.Unlock()
+	return s.saveState()
+}
+
+// ExpireSessions removes sessions that have run out
+func (s *SessionStore) ExpireSessions() error {
+	now := time.Now()
+
+	s.mutex.Lock()
+	removed := 0
+	for key, session := range s.sessions {
+		if !session.ExpiresAt.After(now) {
+			delete(s.sessions, key)
+			removed++
+		}
+	}
+	s.mutex.Unlock()
+
+	if removed == 0 {
+		return nil
+	}
+	return s.saveState()
+}
+
+// loadState loads sessions from the state file
+func (s *SessionStore) loadState() error {
+	if s.config.StateFile == "" {
+		return nil
+	}
+
+	data, err := ioutil.ReadFile(s.config.StateFile)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if err := json.Unmarshal(data, &s.sessions); err != nil {
+		return err
+	}
+
+	// Sessions from before CSRF protection get a token of their own
+	for _, session := range s.sessions {
+		if session.CSRFToken == "" {
+			if session.CSRFToken, err = randomToken(32); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// saveState writes sessions to the state file
+func (s *SessionStore) saveState() error {
+	if s.config.StateFile == "" {
+		return nil
+	}
+
+	s.saveMutex.Lock()
+	defer s.saveMutex.Unlock()
+
+	s.mutex.RLock()
+	data, err := json.MarshalIndent(s.sessions, "", "  ")
+	s.mutex.RUnlock()
+	if err != nil {
+		return err
+	}
+
+	tempFile := s.config.StateFile + ".tmp"
+	if err := ioutil.WriteFile(tempFile, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tempFile, s.config.StateFile)
+}
+
+// hashToken returns the hex SHA-256 hash of a token
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// randomToken returns a random hex string of n bytes
+func randomToken(n int) (string, error) {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
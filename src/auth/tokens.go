@@ -0,0 +1,248 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Scopes an API token can be granted. Each scope includes the ones before it.
+const (
+	ScopeRead  = "read"
+	ScopeWrite = "write"
+	ScopeAdmin = "admin"
+)
+
+// tokenPrefix makes API tokens easy to recognize, e.g. in leaked logs
+const tokenPrefix = "rss_"
+
+// ErrTokenNotFound is returned when a token doesn't exist
+var ErrTokenNotFound = errors.New("token not found")
+
+// scopeLevels orders scopes so higher ones include lower ones
+var scopeLevels = map[string]int{
+	ScopeRead:  1,
+	ScopeWrite: 2,
+	ScopeAdmin: 3,
+}
+
+// Token represents an API token used by scripts
+type Token struct {
+	ID         string    `json:"id"`
+	Name       string    `json:"name"`
+	Username   string    `json:"username"`
+	Scope      string    `json:"scope"`
+	Hash       string    `json:"hash"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at,omitempty"`
+}
+
+// Allows returns true if the token's scope includes the given scope
+func (t *Token) Allows(scope string) bool {
+	return Allows(t.Scope, scope)
+}
+
+// Allows returns true if a granted scope includes the required scope
+func Allows(granted, required string) bool {
+	level, ok := scopeLevels[granted]
+	return ok && level >= scopeLevels[required]
+}
+
+// ValidScope returns true if scope is one of the known scopes
+func ValidScope(scope string) bool {
+	_, ok := scopeLevels[scope]
+	return ok
+}
+
+// TokenConfig holds configuration for API tokens
+type TokenConfig struct {
+	StateFile string
+}
+
+// DefaultTokenConfig returns a default configuration
+func DefaultTokenConfig() TokenConfig {
+	return TokenConfig{
+		StateFile: "tokens.json",
+	}
+}
+
+// TokenStore keeps track of API tokens. Like sessions, only hashes are stored.
+type TokenStore struct {
+	config    TokenConfig
+	tokens    map[string]*Token
+	mutex     sync.RWMutex
+	saveMutex sync.Mutex
+}
+
+// NewTokenStore creates a new token store
+func NewTokenStore(config TokenConfig) *TokenStore {
+	store := &TokenStore{
+		config: config,
+		tokens: make(map[string]*Token),
+	}
+
+	if err := store.loadState(); err != nil {
+		log.Printf("Warning: Failed to load API tokens: %v", err)
+	}
+	return store
+}
+
+// Create issues a new token for a user. The secret is only returned here.
+func (s *TokenStore) Create(username, name, scope string) (string, *Token, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", nil, errors.New("token name cannot be empty")
+	}
+	if !ValidScope(scope) {
+		return "", nil, fmt.Errorf("unknown scope %q, use read, write or admin", scope)
+	}
+
+	id, err := randomToken(8)
+	if err != nil {
+		return "", nil, err
+	}
+	secret, err := randomToken(32)
+	if err != nil {
+		return "", nil, err
+	}
+	secret = tokenPrefix + secret
+
+	token := &Token{
+		ID:        id,
+		Name:      name,
+		Username:  username,
+		Scope:     scope,
+		Hash:      hashToken(secret),
+		CreatedAt: time.Now(),
+	}
+
+	s.mutex.Lock()
+	s.tokens[token.Hash] = token
+	s.mutex.Unlock()
+
+	tokenCopy := *token
+	return secret, &tokenCopy, s.saveState()
+}
+
+// Authenticate finds the token for a secret and records its use
+func (s *TokenStore) Authenticate(secret string) (*Token, bool) {
+	if !strings.HasPrefix(secret, tokenPrefix) {
+		return nil, false
+	}
+
+	s.mutex.Lock()
+	token, ok := s.tokens[hashToken(secret)]
+	if ok {
+		token.LastUsedAt = time.Now()
+	}
+	s.mutex.Unlock()
+
+	if !ok {
+		return nil, false
+	}
+	tokenCopy := *token
+	return &tokenCopy, true
+}
+
+// List returns a user's tokens, oldest first
+func (s *TokenStore) List(username string) []*Token {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	tokens := make([]*Token, 0)
+	for _, token := range s.tokens {
+		if token.Username == username {
+			tokenCopy := *token
+			tokens = append(tokens, &tokenCopy)
+		}
+	}
+	sort.Slice(tokens, func(i, j int) bool {
+		return tokens[i].CreatedAt.Before(tokens[j].CreatedAt)
+	})
+	return tokens
+}
+
+// Revoke deletes one of a user's tokens by ID
+func (s *TokenStore) Revoke(username, id string) error {
+	s.mutex.Lock()
+	found := false
+	for hash, token := range s.tokens {
+		if token.Username == username && token.ID == id {
+			delete(s.tokens, hash)
+			found = true
+		}
+	}
+	s.mutex.Unlock()
+
+	if !found {
+		return ErrTokenNotFound
+	}
+	return s.saveState()
+}
+
+// Save writes tokens to the state file, persisting when they were last used
+func (s *TokenStore) Save() error {
+	return s.saveState()
+}
+
+// loadState loads tokens from the state file
+func (s *TokenStore) loadState() error {
+	if s.config.StateFile == "" {
+		return nil
+	}
+
+	data, err := ioutil.ReadFile(s.config.StateFile)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var tokens []*Token
+	if err := json.Unmarshal(data, &tokens); err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, token := range tokens {
+		s.tokens[token.Hash] = token
+	}
+	return nil
+}
+
+// saveState writes tokens to the state file
+func (s *TokenStore) saveState() error {
+	if s.config.StateFile == "" {
+		return nil
+	}
+
+	s.saveMutex.Lock()
+	defer s.saveMutex.Unlock()
+
+	s.mutex.RLock()
+	tokens := make([]Token, 0, len(s.tokens))
+	for _, token := range s.tokens {
+		tokens = append(tokens, *token)
+	}
+	s.mutex.RUnlock()
+
+	data, err := json.MarshalIndent(tokens, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tempFile := s.config.StateFile + ".tmp"
+	if err := ioutil.WriteFile(tempFile, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tempFile, s.config.StateFile)
+}
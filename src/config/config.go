@@ -0,0 +1,242 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Config is the complete application configuration.
+//
+// Settings are applied in this order, later sources overriding earlier ones:
+//  1. built-in defaults (Default)
+//  2. the config file (YAML or TOML)
+//  3. RSS_* environment variables
+//  4. command line flags
+type Config struct {
+	Server    ServerConfig    `yaml:"server" toml:"server" json:"server"`
+	Storage   StorageConfig   `yaml:"storage" toml:"storage" json:"storage"`
+	Fetch     FetchConfig     `yaml:"fetch" toml:"fetch" json:"fetch"`
+	Auth      AuthConfig      `yaml:"auth" toml:"auth" json:"auth"`
+	Scheduler SchedulerConfig `yaml:"scheduler" toml:"scheduler" json:"scheduler"`
+	Digest    DigestConfig    `yaml:"digest" toml:"digest" json:"digest"`
+	SMTP      SMTPConfig      `yaml:"smtp" toml:"smtp" json:"smtp"`
+	Archive   ArchiveConfig   `yaml:"archive" toml:"archive" json:"archive"`
+	Images    ImagesConfig    `yaml:"images" toml:"images" json:"images"`
+	Icons     IconsConfig     `yaml:"icons" toml:"icons" json:"icons"`
+}
+
+// ServerConfig holds HTTP server settings
+type ServerConfig struct {
+	Port int `yaml:"port" toml:"port" json:"port" env:"RSS_PORT"`
+	// BaseURL is the public URL, required for WebSub push updates and the hub
+	BaseURL         string   `yaml:"base_url" toml:"base_url" json:"base_url" env:"RSS_BASE_URL"`
+	ShutdownTimeout Duration `yaml:"shutdown_timeout" toml:"shutdown_timeout" json:"shutdown_timeout" env:"RSS_SHUTDOWN_TIMEOUT"`
+	PublicExport    bool     `yaml:"public_export" toml:"public_export" json:"public_export" env:"RSS_PUBLIC_EXPORT"`
+	PublicMetrics   bool     `yaml:"public_metrics" toml:"public_metrics" json:"public_metrics" env:"RSS_PUBLIC_METRICS"`
+}
+
+// StorageConfig holds persistence settings
+type StorageConfig struct {
+	DataDir string `yaml:"data_dir" toml:"data_dir" json:"data_dir" env:"RSS_DATA_DIR"`
+	// SaveDelay is how long autosave collects changes before writing them
+	SaveDelay Duration `yaml:"save_delay" toml:"save_delay" json:"save_delay" env:"RSS_SAVE_DELAY"`
+	// DefaultFeeds are added on first start, before any account exists
+	DefaultFeeds []string `yaml:"default_feeds" toml:"default_feeds" json:"default_feeds" env:"RSS_DEFAULT_FEEDS"`
+}
+
+// FetchConfig holds settings for fetching feeds
+type FetchConfig struct {
+	Timeout   Duration `yaml:"timeout" toml:"timeout" json:"timeout" env:"RSS_FETCH_TIMEOUT"`
+	UserAgent string   `yaml:"user_agent" toml:"user_agent" json:"user_agent" env:"RSS_USER_AGENT"`
+}
+
+// AuthConfig holds account and session settings
+type AuthConfig struct {
+	AllowSignup   bool     `yaml:"allow_signup" toml:"allow_signup" json:"allow_signup" env:"RSS_ALLOW_SIGNUP"`
+	SessionMaxAge Duration `yaml:"session_max_age" toml:"session_max_age" json:"session_max_age" env:"RSS_SESSION_MAX_AGE"`
+}
+
+// SchedulerConfig holds background job settings
+type SchedulerConfig struct {
+	RefreshInterval Duration `yaml:"refresh_interval" toml:"refresh_interval" json:"refresh_interval" env:"RSS_REFRESH_INTERVAL"`
+	SaveInterval    Duration `yaml:"save_interval" toml:"save_interval" json:"save_interval" env:"RSS_SAVE_INTERVAL"`
+}
+
+// DigestConfig holds email digest settings
+type DigestConfig struct {
+	Interval Duration `yaml:"interval" toml:"interval" json:"interval" env:"RSS_DIGEST_INTERVAL"`
+	User     string   `yaml:"user" toml:"user" json:"user" env:"RSS_DIGEST_USER"`
+	Feeds    []string `yaml:"feeds" toml:"feeds" json:"feeds" env:"RSS_DIGEST_FEEDS"`
+	Folders  []string `yaml:"folders" toml:"folders" json:"folders" env:"RSS_DIGEST_FOLDERS"`
+	To       []string `yaml:"to" toml:"to" json:"to" env:"RSS_DIGEST_TO"`
+	MaxItems int      `yaml:"max_items" toml:"max_items" json:"max_items" env:"RSS_DIGEST_MAX_ITEMS"`
+	Subject  string   `yaml:"subject" toml:"subject" json:"subject" env:"RSS_DIGEST_SUBJECT"`
+}
+
+// SMTPConfig holds the mail server used for the digest
+type SMTPConfig struct {
+	Host     string `yaml:"host" toml:"host" json:"host" env:"RSS_SMTP_HOST"`
+	Port     int    `yaml:"port" toml:"port" json:"port" env:"RSS_SMTP_PORT"`
+	Username string `yaml:"username" toml:"username" json:"username" env:"RSS_SMTP_USER"`
+	Password string `yaml:"password" toml:"password" json:"password" env:"RSS_SMTP_PASSWORD"`
+	From     string `yaml:"from" toml:"from" json:"from" env:"RSS_SMTP_FROM"`
+}
+
+// ArchiveConfig holds settings for downloading episodes of feeds that opted in
+type ArchiveConfig struct {
+	// Dir is where episodes are stored, by default the archive directory inside the data directory
+	Dir      string   `yaml:"dir" toml:"dir" json:"dir" env:"RSS_ARCHIVE_DIR"`
+	Interval Duration `yaml:"interval" toml:"interval" json:"interval" env:"RSS_ARCHIVE_INTERVAL"`
+	// MaxEpisodes is how many of the newest episodes of a feed are kept, unless the feed sets its own limit
+	MaxEpisodes int `yaml:"max_episodes" toml:"max_episodes" json:"max_episodes" env:"RSS_ARCHIVE_MAX_EPISODES"`
+	// QuotaMB caps the disk space used by all episodes; 0 means no limit
+	QuotaMB int `yaml:"quota_mb" toml:"quota_mb" json:"quota_mb" env:"RSS_ARCHIVE_QUOTA_MB"`
+}
+
+// ImagesConfig holds settings for the proxy that serves the images in item content
+type ImagesConfig struct {
+	// Proxy rewrites images to load through the server instead of from their hosts
+	Proxy bool `yaml:"proxy" toml:"proxy" json:"proxy" env:"RSS_IMAGE_PROXY"`
+	// Dir is where images are cached, by default the images directory inside the data directory
+	Dir string `yaml:"dir" toml:"dir" json:"dir" env:"RSS_IMAGE_DIR"`
+	// CacheMB caps the disk space used by cached images, removing the least recently used first
+	CacheMB int `yaml:"cache_mb" toml:"cache_mb" json:"cache_mb" env:"RSS_IMAGE_CACHE_MB"`
+	// MaxSizeMB is the largest image that is proxied
+	MaxSizeMB int `yaml:"max_size_mb" toml:"max_size_mb" json:"max_size_mb" env:"RSS_IMAGE_MAX_SIZE_MB"`
+}
+
+// IconsConfig holds settings for fetching the icon of each feed's site
+type IconsConfig struct {
+	// Dir is where icons are stored, by default the icons directory inside the data directory
+	Dir string `yaml:"dir" toml:"dir" json:"dir" env:"RSS_ICON_DIR"`
+	// Interval is how long an icon is kept before it is downloaded again
+	Interval Duration `yaml:"interval" toml:"interval" json:"interval" env:"RSS_ICON_INTERVAL"`
+}
+
+// Default returns the built-in configuration
+func Default() Config {
+	return Config{
+		Server: ServerConfig{
+			Port:            3030,
+			ShutdownTimeout: Duration(30 * time.Second),
+		},
+		Storage: StorageConfig{
+			DataDir:   "data",
+			SaveDelay: Duration(500 * time.Millisecond),
+		},
+		Fetch: FetchConfig{
+			Timeout:   Duration(30 * time.Second),
+			UserAgent: "Gofeed/1.0",
+		},
+		Auth: AuthConfig{
+			SessionMaxAge: Duration(30 * 24 * time.Hour),
+		},
+		Scheduler: SchedulerConfig{
+			RefreshInterval: Duration(30 * time.Minute),
+			SaveInterval:    Duration(time.Minute),
+		},
+		Digest: DigestConfig{
+			Interval: Duration(24 * time.Hour),
+			MaxItems: 20,
+			Subject:  "Your RSS digest",
+		},
+		SMTP: SMTPConfig{
+			Port: 587,
+		},
+		Archive: ArchiveConfig{
+			Interval:    Duration(time.Hour),
+			MaxEpisodes: 5,
+			QuotaMB:     10 * 1024,
+		},
+		Images: ImagesConfig{
+			Proxy:     true,
+			CacheMB:   512,
+			MaxSizeMB: 10,
+		},
+		Icons: IconsConfig{
+			Interval: Duration(7 * 24 * time.Hour),
+		},
+	}
+}
+
+// Validate checks the configuration and reports every problem found
+func (c Config) Validate() error {
+	var problems []error
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			problems = append(problems, fmt.Errorf(format, args...))
+		}
+	}
+
+	check(c.Server.Port > 0 && c.Server.Port < 65536, "server.port must be between 1 and 65535, got %d", c.Server.Port)
+	if c.Server.BaseURL != "" {
+		base, err := url.Parse(c.Server.BaseURL)
+		check(err == nil && (base.Scheme == "http" || base.Scheme == "https") && base.Host != "",
+			"server.base_url must be an absolute http(s) URL, got %q", c.Server.BaseURL)
+	}
+	check(c.Server.ShutdownTimeout > 0, "server.shutdown_timeout must be positive")
+	check(c.Storage.DataDir != "", "storage.data_dir cannot be empty")
+	check(c.Storage.SaveDelay >= 0, "storage.save_delay cannot be negative")
+	check(c.Fetch.Timeout > 0, "fetch.timeout must be positive")
+	check(c.Fetch.UserAgent != "", "fetch.user_agent cannot be empty")
+	check(c.Auth.SessionMaxAge > 0, "auth.session_max_age must be positive")
+	check(c.Scheduler.RefreshInterval > 0, "scheduler.refresh_interval must be positive")
+	check(c.Scheduler.SaveInterval > 0, "scheduler.save_interval must be positive")
+	check(c.Digest.Interval > 0, "digest.interval must be positive")
+	check(c.Digest.MaxItems >= 0, "digest.max_items cannot be negative")
+	check(c.SMTP.Port > 0 && c.SMTP.Port < 65536, "smtp.port must be between 1 and 65535, got %d", c.SMTP.Port)
+	check(c.Archive.Interval > 0, "archive.interval must be positive")
+	check(c.Archive.MaxEpisodes > 0, "archive.max_episodes must be positive")
+	check(c.Archive.QuotaMB >= 0, "archive.quota_mb cannot be negative")
+	check(c.Images.MaxSizeMB > 0, "images.max_size_mb must be positive")
+	check(c.Images.CacheMB >= c.Images.MaxSizeMB, "images.cache_mb must be at least images.max_size_mb")
+	check(c.Icons.Interval > 0, "icons.interval must be positive")
+	if len(c.Digest.To) > 0 {
+		check(c.SMTP.Host != "", "smtp.host is required when digest.to is set")
+		check(c.SMTP.From != "", "smtp.from is required when digest.to is set")
+	}
+	for _, recipient := range c.Digest.To {
+		check(strings.Contains(recipient, "@"), "digest.to contains an invalid address %q", recipient)
+	}
+
+	return errors.Join(problems...)
+}
+
+// Redacted returns a copy with secrets masked, for printing
+func (c Config) Redacted() Config {
+	if c.SMTP.Password != "" {
+		c.SMTP.Password = "********"
+	}
+	return c
+}
+
+// Duration is a time.Duration written as a string like "30m" in config files
+type Duration time.Duration
+
+// MarshalText implements encoding.TextMarshaler
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler
+func (d *Duration) UnmarshalText(text []byte) error {
+	parsed, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// String returns the duration in Go's duration syntax
+func (d Duration) String() string {
+	return time.Duration(d).String()
+}
+
+// Set parses a duration, so Duration can be used as a flag.Value
+func (d *Duration) Set(value string) error {
+	return d.UnmarshalText([]byte(value))
+}
@@ -0,0 +1,165 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// env returns a lookup function reading from a map
+func env(vars map[string]string) func(string) (string, bool) {
+	return func(name string) (string, bool) {
+		value, ok := vars[name]
+		return value, ok
+	}
+}
+
+// writeConfig writes a config file with the given name to a temporary directory
+func writeConfig(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadDefaults(t *testing.T) {
+	config, err := LoadFrom("", env(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(config, Default()) {
+		t.Errorf("config = %+v, want the defaults", config)
+	}
+	if err := config.Validate(); err != nil {
+		t.Errorf("defaults are invalid: %v", err)
+	}
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	path := writeConfig(t, "rss.yaml", `
+server:
+  port: 8080
+  base_url: https://rss.example.com
+fetch:
+  user_agent: from-file
+`)
+	config, err := LoadFrom(path, env(map[string]string{"RSS_PORT": "9090"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Server.Port != 9090 {
+		t.Errorf("port = %d, want 9090 from the environment", config.Server.Port)
+	}
+	if config.Server.BaseURL != "https://rss.example.com" || config.Fetch.UserAgent != "from-file" {
+		t.Errorf("file settings were not applied: %+v", config)
+	}
+	if config.Storage.DataDir != "data" {
+		t.Errorf("data dir = %q, want the default", config.Storage.DataDir)
+	}
+}
+
+func TestLoadFormats(t *testing.T) {
+	for name, content := range map[string]string{
+		"rss.yaml": `
+scheduler:
+  refresh_interval: 15m
+digest:
+  to: [a@example.com, b@example.com]
+`,
+		"rss.yml": `
+scheduler:
+  refresh_interval: 15m
+digest:
+  to:
+    - a@example.com
+    - b@example.com
+`,
+		"rss.toml": `
+[scheduler]
+refresh_interval = "15m"
+
+[digest]
+to = ["a@example.com", "b@example.com"]
+`,
+	} {
+		config, err := LoadFrom(writeConfig(t, name, content), env(nil))
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if time.Duration(config.Scheduler.RefreshInterval) != 15*time.Minute {
+			t.Errorf("%s: refresh interval = %v, want 15m", name, config.Scheduler.RefreshInterval)
+		}
+		if want := []string{"a@example.com", "b@example.com"}; !reflect.DeepEqual(config.Digest.To, want) {
+			t.Errorf("%s: digest recipients = %v, want %v", name, config.Digest.To, want)
+		}
+	}
+}
+
+func TestLoadRejectsUnknownKeys(t *testing.T) {
+	for name, content := range map[string]string{
+		"rss.yaml":  "server:\n  prot: 8080\n",
+		"rss.toml":  "[server]\nprot = 8080\n",
+		"rss.json":  `{"server": {"port": 8080}}`,
+		"empty.yml": "unknown: true\n",
+	} {
+		_, err := LoadFrom(writeConfig(t, name, content), env(nil))
+		if err == nil {
+			t.Errorf("%s was accepted", name)
+		}
+	}
+}
+
+func TestLoadEnvTypes(t *testing.T) {
+	config, err := LoadFrom("", env(map[string]string{
+		"RSS_DIGEST_FEEDS":     " http://a.example.com/feed , ,http://b.example.com/feed",
+		"RSS_FETCH_TIMEOUT":    "45s",
+		"RSS_PUBLIC_METRICS":   "true",
+		"RSS_DIGEST_MAX_ITEMS": "7",
+		"RSS_SMTP_PASSWORD":    "secret",
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"http://a.example.com/feed", "http://b.example.com/feed"}; !reflect.DeepEqual(config.Digest.Feeds, want) {
+		t.Errorf("digest feeds = %v, want %v", config.Digest.Feeds, want)
+	}
+	if time.Duration(config.Fetch.Timeout) != 45*time.Second {
+		t.Errorf("fetch timeout = %v, want 45s", config.Fetch.Timeout)
+	}
+	if !config.Server.PublicMetrics || config.Digest.MaxItems != 7 || config.SMTP.Password != "secret" {
+		t.Errorf("environment was not applied: %+v", config)
+	}
+	if config.Redacted().SMTP.Password == "secret" {
+		t.Error("password is not redacted")
+	}
+
+	for name, value := range map[string]string{
+		"RSS_FETCH_TIMEOUT":  "soon",
+		"RSS_PORT":           "http",
+		"RSS_PUBLIC_METRICS": "maybe",
+	} {
+		_, err := LoadFrom("", env(map[string]string{name: value}))
+		if err == nil || !strings.Contains(err.Error(), name) {
+			t.Errorf("%s=%s: err = %v, want an error naming the variable", name, value, err)
+		}
+	}
+}
+
+func TestEnvVars(t *testing.T) {
+	names := EnvVars()
+	for _, want := range []string{"RSS_PORT", "RSS_DATA_DIR", "RSS_SMTP_PASSWORD", "RSS_ICON_INTERVAL"} {
+		found := false
+		for _, name := range names {
+			found = found || name == want
+		}
+		if !found {
+			t.Errorf("EnvVars lacks %s", want)
+		}
+	}
+}
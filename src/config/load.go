@@ -0,0 +1,161 @@
+package config
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/pelletier/go-toml/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// Load returns the defaults overridden by the config file at path (if not empty)
+// and then by environment variables
+func Load(path string) (Config, error) {
+	return LoadFrom(path, os.LookupEnv)
+}
+
+// LoadFrom is Load with environment variables read through lookup
+func LoadFrom(path string, lookup func(string) (string, bool)) (Config, error) {
+	config := Default()
+
+	if path != "" {
+		if err := loadFile(path, &config); err != nil {
+			return config, err
+		}
+	}
+	if err := applyEnv(&config, lookup); err != nil {
+		return config, err
+	}
+	return config, nil
+}
+
+// loadFile decodes a YAML or TOML file, chosen by extension, rejecting unknown keys
+func loadFile(path string, config *Config) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		if err := decoder.Decode(config); err != nil && !errors.Is(err, io.EOF) {
+			return fmt.Errorf("%s: %v", path, err)
+		}
+	case ".toml":
+		decoder := toml.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(config); err != nil {
+			var strict *toml.StrictMissingError
+			if errors.As(err, &strict) {
+				return fmt.Errorf("%s: unknown settings:\n%s", path, strict.String())
+			}
+			return fmt.Errorf("%s: %v", path, err)
+		}
+	default:
+		return fmt.Errorf("%s: unsupported config format, use .yaml, .yml or .toml", path)
+	}
+	return nil
+}
+
+// Encode writes the configuration as yaml, toml or json
+func Encode(config Config, format string) ([]byte, error) {
+	switch format {
+	case "yaml", "yml", "":
+		return yaml.Marshal(config)
+	case "toml":
+		return toml.Marshal(config)
+	case "json":
+		return json.MarshalIndent(config, "", "  ")
+	}
+	return nil, fmt.Errorf("unsupported format %q, use yaml, toml or json", format)
+}
+
+// EnvVars lists the environment variables that override settings, in field order
+func EnvVars() []string {
+	names := make([]string, 0)
+	walkEnv(reflect.ValueOf(&Config{}).Elem(), func(name string, _ reflect.Value) error {
+		names = append(names, name)
+		return nil
+	})
+	return names
+}
+
+// applyEnv sets every field whose env tag names a variable that is set
+func applyEnv(config *Config, lookup func(string) (string, bool)) error {
+	return walkEnv(reflect.ValueOf(config).Elem(), func(name string, field reflect.Value) error {
+		value, ok := lookup(name)
+		if !ok {
+			return nil
+		}
+		if err := setField(field, value); err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+		return nil
+	})
+}
+
+// walkEnv calls fn for every field with an env tag
+func walkEnv(value reflect.Value, fn func(name string, field reflect.Value) error) error {
+	for i := 0; i < value.NumField(); i++ {
+		field := value.Field(i)
+		if name := value.Type().Field(i).Tag.Get("env"); name != "" {
+			if err := fn(name, field); err != nil {
+				return err
+			}
+			continue
+		}
+		if field.Kind() == reflect.Struct {
+			if err := walkEnv(field, fn); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// setField parses a string into a field of a supported kind. Lists are comma-separated.
+func setField(field reflect.Value, value string) error {
+	if unmarshaler, ok := field.Addr().Interface().(encoding.TextUnmarshaler); ok {
+		return unmarshaler.UnmarshalText([]byte(value))
+	}
+
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+	case reflect.Int:
+		parsed, err := strconv.Atoi(value)
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(parsed))
+	case reflect.Bool:
+		parsed, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		field.SetBool(parsed)
+	case reflect.Slice:
+		list := make([]string, 0)
+		for _, part := range strings.Split(value, ",") {
+			if part = strings.TrimSpace(part); part != "" {
+				list = append(list, part)
+			}
+		}
+		field.Set(reflect.ValueOf(list))
+	default:
+		return fmt.Errorf("unsupported setting type %s", field.Kind())
+	}
+	return nil
+}
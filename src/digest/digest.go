@@ -0,0 +1,287 @@
+package digest
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"log"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/user/rss/src/parser"
+)
+
+// Config holds configuration for the digest
+type Config struct {
+	Interval time.Duration
+	// User limits the digest to a user's subscriptions and folders
+	User      string
+	Feeds     []string
+	Folders   []string
+	MaxItems  int
+	Subject   string
+	StateFile string
+	SMTP      SMTPConfig
+}
+
+// DefaultConfig returns a default configuration
+func DefaultConfig() Config {
+	return Config{
+		Interval:  24 * time.Hour,
+		MaxItems:  20,
+		Subject:   "Your RSS digest",
+		StateFile: "digest.json",
+		SMTP:      DefaultSMTPConfig(),
+	}
+}
+
+// Section holds the new items of a single feed
+type Section struct {
+	Title string
+	URL   string
+	Items []parser.FeedItem
+}
+
+// Summary represents a rendered-ready digest of new items
+type Summary struct {
+	Subject     string
+	Since       time.Time
+	GeneratedAt time.Time
+	Sections    []Section
+	ItemCount   int
+	// LastItemID is the newest item the digest looked at, so the next one starts after it
+	LastItemID int64
+}
+
+// state is the digest information persisted between restarts
+type state struct {
+	LastSent   time.Time `json:"last_sent"`
+	LastItemID int64     `json:"last_item_id,omitempty"`
+}
+
+// Digest collects new items from subscriptions and mails them as a summary
+type Digest struct {
+	storage  *parser.Storage
+	config   Config
+	mutex    sync.Mutex
+	lastSent time.Time
+	// lastItemID is the newest item covered by the last digest sent
+	lastItemID int64
+}
+
+// NewDigest creates a new digest instance
+func NewDigest(storage *parser.Storage, config Config) *Digest {
+	d := &Digest{
+		storage: storage,
+		config:  config,
+	}
+
+	if err := d.loadState(); err != nil {
+		log.Printf("Warning: Failed to load digest state: %v", err)
+	}
+	return d
+}
+
+// Enabled returns true if the digest has enough SMTP configuration to send mail
+func (d *Digest) Enabled() bool {
+	return d.config.SMTP.Host != "" && d.config.SMTP.From != "" && len(d.config.SMTP.To) > 0
+}
+
+// Since returns the time from which items are considered new
+func (d *Digest) Since() time.Time {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if d.lastSent.IsZero() {
+		return time.Now().Add(-d.config.Interval)
+	}
+	return d.lastSent
+}
+
+// Collect gathers the items of the selected feeds for a digest. With a user, those
+// are the items the user hasn't read that no earlier digest included. Without one
+// there is no read state, so the items published after since are taken instead,
+// which leaves out items without a date.
+func (d *Digest) Collect(since time.Time) (*Summary, error) {
+	summary := &Summary{
+		Subject:     d.config.Subject,
+		Since:       since,
+		GeneratedAt: time.Now(),
+	}
+
+	d.mutex.Lock()
+	lastItemID := d.lastItemID
+	d.mutex.Unlock()
+	summary.LastItemID = lastItemID
+
+	var read map[int64]bool
+	if d.config.User != "" {
+		read = d.readState()
+	}
+	for _, stored := range d.selectedFeeds() {
+		feed, err := d.storage.GetFeed(stored.URL)
+		if err != nil {
+			log.Printf("Digest: skipping feed %s: %v", stored.URL, err)
+			continue
+		}
+
+		items := make([]parser.FeedItem, 0)
+		for _, item := range feed.Items {
+			if item.ID > summary.LastItemID {
+				summary.LastItemID = item.ID
+			}
+			if read == nil && item.PublishedAt.After(since) ||
+				read != nil && !read[item.ID] && item.ID > lastItemID {
+				items = append(items, item)
+			}
+		}
+		if len(items) == 0 {
+			continue
+		}
+
+		// Newest first, with items without a date last
+		sort.SliceStable(items, func(i, j int) bool {
+			return items[i].PublishedAt.After(items[j].PublishedAt)
+		})
+		if d.config.MaxItems > 0 && len(items) > d.config.MaxItems {
+			items = items[:d.config.MaxItems]
+		}
+
+		title := feed.Title
+		if title == "" {
+			title = feed.URL
+		}
+		summary.Sections = append(summary.Sections, Section{
+			Title: title,
+			URL:   feed.URL,
+			Items: items,
+		})
+		summary.ItemCount += len(items)
+	}
+
+	return summary, nil
+}
+
+// Preview builds the digest for the current period without sending it
+func (d *Digest) Preview() (*Summary, error) {
+	return d.Collect(d.Since())
+}
+
+// Send builds the digest and mails it to the configured recipients
+func (d *Digest) Send() error {
+	if !d.Enabled() {
+		return errors.New("digest SMTP settings are not configured")
+	}
+
+	summary, err := d.Preview()
+	if err != nil {
+		return err
+	}
+
+	if summary.ItemCount == 0 {
+		log.Println("Digest: no new items, nothing to send")
+	} else {
+		htmlBody, textBody, err := Render(summary)
+		if err != nil {
+			return err
+		}
+		if err := sendMail(d.config.SMTP, summary.Subject, htmlBody, textBody); err != nil {
+			return err
+		}
+		log.Printf("Digest: sent %d items to %d recipients", summary.ItemCount, len(d.config.SMTP.To))
+	}
+
+	d.mutex.Lock()
+	d.lastSent = summary.GeneratedAt
+	if summary.LastItemID > d.lastItemID {
+		d.lastItemID = summary.LastItemID
+	}
+	d.mutex.Unlock()
+	return d.saveState()
+}
+
+// SendIfDue sends the digest when the configured interval has elapsed
+func (d *Digest) SendIfDue() error {
+	d.mutex.Lock()
+	due := d.lastSent.IsZero() || time.Since(d.lastSent) >= d.config.Interval
+	d.mutex.Unlock()
+
+	if !due {
+		return nil
+	}
+	return d.Send()
+}
+
+// selectedFeeds returns the feeds chosen by URL or folder, or all feeds if none are chosen
+func (d *Digest) selectedFeeds() []*parser.Feed {
+	feeds := d.storage.GetAllFeeds()
+	if d.config.User != "" {
+		feeds = d.storage.Subscriptions(d.config.User)
+	}
+	if len(d.config.Feeds) == 0 && len(d.config.Folders) == 0 {
+		return feeds
+	}
+
+	wanted := make(map[string]bool)
+	for _, url := range d.config.Feeds {
+		wanted[url] = true
+	}
+	folders := make(map[string]bool)
+	for _, folder := range d.config.Folders {
+		folders[folder] = true
+	}
+
+	selected := make([]*parser.Feed, 0, len(feeds))
+	for _, feed := range feeds {
+		if wanted[feed.URL] || (feed.Folder != "" && folders[feed.Folder]) {
+			selected = append(selected, feed)
+		}
+	}
+	return selected
+}
+
+// readState returns which cached items of the digest user's subscriptions they have read
+func (d *Digest) readState() map[int64]bool {
+	read := make(map[int64]bool)
+	for _, stored := range d.storage.Items(d.config.User) {
+		read[stored.Item.ID] = stored.Read
+	}
+	return read
+}
+
+// loadState loads the last sent time from the state file
+func (d *Digest) loadState() error {
+	data, err := ioutil.ReadFile(d.config.StateFile)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var st state
+	if err := json.Unmarshal(data, &st); err != nil {
+		return err
+	}
+
+	d.mutex.Lock()
+	d.lastSent = st.LastSent
+	d.lastItemID = st.LastItemID
+	d.mutex.Unlock()
+	return nil
+}
+
+// saveState writes the last sent time to the state file
+func (d *Digest) saveState() error {
+	d.mutex.Lock()
+	st := state{LastSent: d.lastSent, LastItemID: d.lastItemID}
+	d.mutex.Unlock()
+
+	data, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(d.config.StateFile, data, 0644)
+}
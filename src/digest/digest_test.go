@@ -0,0 +1,281 @@
+package digest
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/mail"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/user/rss/src/parser"
+)
+
+// feedServer serves a feed with a recent item, an item without a date and an old item
+func feedServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	recent := time.Now().Add(-time.Hour).Format(time.RFC1123Z)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/rss+xml")
+		fmt.Fprintf(w, `<?xml version="1.0"?>
+<rss version="2.0"><channel><title>Team News</title><link>http://example.com/</link>
+<item><title>Recent</title><link>http://example.com/recent</link><guid>recent</guid><pubDate>%s</pubDate><description>Fresh &amp; new</description></item>
+<item><title>Undated</title><link>http://example.com/undated</link><guid>undated</guid><description>No date</description></item>
+<item><title>Old</title><link>http://example.com/old</link><guid>old</guid><pubDate>Mon, 01 Jan 2001 00:00:00 +0000</pubDate><description>From long ago</description></item>
+</channel></rss>`, recent)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// newStorage returns a storage in a temporary directory where alice subscribes to feedURL
+func newStorage(t *testing.T, feedURL string) *parser.Storage {
+	t.Helper()
+	dir := t.TempDir()
+	storage := parser.NewStorage(parser.StorageConfig{
+		FilePath:      filepath.Join(dir, "feeds.json"),
+		ItemsFilePath: filepath.Join(dir, "items.json"),
+		UsersFilePath: filepath.Join(dir, "users.json"),
+	})
+	t.Cleanup(func() { storage.Close(context.Background()) })
+
+	if _, err := storage.CreateUser("alice", "secret123"); err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.Subscribe("alice", &parser.Feed{URL: feedURL, Title: "Team News"}); err != nil {
+		t.Fatal(err)
+	}
+	return storage
+}
+
+// smtpServer is a minimal SMTP server that accepts every message and passes it on
+func smtpServer(t *testing.T) (string, <-chan string) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	messages := make(chan string, 1)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serveSMTP(conn, messages)
+		}
+	}()
+	return listener.Addr().String(), messages
+}
+
+// serveSMTP answers one SMTP session
+func serveSMTP(conn net.Conn, messages chan<- string) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	reply := func(line string) { fmt.Fprintf(conn, "%s\r\n", line) }
+
+	reply("220 localhost ready")
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		command := strings.ToUpper(strings.TrimSpace(line))
+		switch {
+		case strings.HasPrefix(command, "EHLO"), strings.HasPrefix(command, "HELO"):
+			reply("250 localhost")
+		case strings.HasPrefix(command, "DATA"):
+			reply("354 go ahead")
+			var message strings.Builder
+			for {
+				line, err := reader.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if line == ".\r\n" {
+					break
+				}
+				message.WriteString(line)
+			}
+			messages <- message.String()
+			reply("250 accepted")
+		case strings.HasPrefix(command, "QUIT"):
+			reply("221 bye")
+			return
+		default:
+			reply("250 ok")
+		}
+	}
+}
+
+func TestCollectUnreadForUser(t *testing.T) {
+	feedURL := feedServer(t).URL + "/feed.xml"
+	storage := newStorage(t, feedURL)
+
+	feed, err := storage.GetFeed(feedURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := make(map[string]int64)
+	for _, item := range feed.Items {
+		ids[item.Title] = item.ID
+	}
+	if err := storage.SetRead("alice", []int64{ids["Recent"]}, true); err != nil {
+		t.Fatal(err)
+	}
+
+	config := DefaultConfig()
+	config.User = "alice"
+	config.StateFile = filepath.Join(t.TempDir(), "digest.json")
+	d := NewDigest(storage, config)
+
+	summary, err := d.Preview()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := titles(summary); got != "Old,Undated" {
+		t.Errorf("unread items = %s, want Old,Undated", got)
+	}
+}
+
+func TestCollectByDateWithoutUser(t *testing.T) {
+	feedURL := feedServer(t).URL + "/feed.xml"
+	config := DefaultConfig()
+	config.StateFile = filepath.Join(t.TempDir(), "digest.json")
+	d := NewDigest(newStorage(t, feedURL), config)
+
+	summary, err := d.Collect(time.Now().Add(-24 * time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := titles(summary); got != "Recent" {
+		t.Errorf("new items = %s, want Recent", got)
+	}
+}
+
+func TestSend(t *testing.T) {
+	feedURL := feedServer(t).URL + "/feed.xml"
+	addr, messages := smtpServer(t)
+	host, port, _ := net.SplitHostPort(addr)
+
+	config := DefaultConfig()
+	config.User = "alice"
+	config.Subject = "Weekly news"
+	config.StateFile = filepath.Join(t.TempDir(), "digest.json")
+	config.SMTP.Host = host
+	fmt.Sscan(port, &config.SMTP.Port)
+	config.SMTP.From = "rss@example.com"
+	config.SMTP.To = []string{"team@example.com"}
+	d := NewDigest(newStorage(t, feedURL), config)
+
+	if err := d.Send(); err != nil {
+		t.Fatal(err)
+	}
+	var message string
+	select {
+	case message = <-messages:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no message was delivered")
+	}
+	parsed, err := mail.ReadMessage(strings.NewReader(message))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for header, want := range map[string]string{"From": "rss@example.com", "To": "team@example.com", "Subject": "Weekly news"} {
+		if got := parsed.Header.Get(header); got != want {
+			t.Errorf("%s = %q, want %q", header, got, want)
+		}
+	}
+	mediaType, params, err := mime.ParseMediaType(parsed.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/alternative" {
+		t.Fatalf("Content-Type = %q, want multipart/alternative", parsed.Header.Get("Content-Type"))
+	}
+	parts := make(map[string]string)
+	reader := multipart.NewReader(parsed.Body, params["boundary"])
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(part)
+		parts[part.Header.Get("Content-Type")] = string(body)
+	}
+	for contentType, wants := range map[string][]string{
+		"text/plain; charset=UTF-8": {"== Team News ==", "* Recent", "* Undated", "http://example.com/undated", "Fresh & new"},
+		"text/html; charset=UTF-8":  {">Recent</a>", ">Undated</a>", "Fresh &amp; new"},
+	} {
+		for _, want := range wants {
+			if !strings.Contains(parts[contentType], want) {
+				t.Errorf("%s part lacks %q:\n%s", contentType, want, parts[contentType])
+			}
+		}
+	}
+
+	// Items are only sent once
+	summary, err := d.Preview()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.ItemCount != 0 {
+		t.Errorf("second digest has %d items, want none", summary.ItemCount)
+	}
+
+	// The last item sent survives a restart
+	summary, err = NewDigest(newStorage(t, feedURL), config).Preview()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.LastItemID != 3 {
+		t.Errorf("last item ID after a restart = %d, want 3", summary.LastItemID)
+	}
+}
+
+func TestRenderWithoutDate(t *testing.T) {
+	summary := &Summary{
+		Subject: "Digest",
+		Since:   time.Date(2024, 5, 1, 8, 0, 0, 0, time.UTC),
+		Sections: []Section{{
+			Title: "Feed <One>",
+			URL:   "http://example.com/",
+			Items: []parser.FeedItem{{Title: "No date", Link: "http://example.com/a", Description: "<p>Body</p>"}},
+		}},
+		ItemCount: 1,
+	}
+	htmlBody, textBody, err := Render(summary)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(textBody, "0001") || strings.Contains(htmlBody, "0001") {
+		t.Errorf("a missing date was rendered:\n%s\n%s", textBody, htmlBody)
+	}
+	if !strings.Contains(htmlBody, "Feed &lt;One&gt;") {
+		t.Errorf("feed title is not escaped:\n%s", htmlBody)
+	}
+	if !strings.Contains(textBody, "1 new items since May 1, 2024 at 8:00 AM") || !strings.Contains(textBody, "Body") {
+		t.Errorf("unexpected text body:\n%s", textBody)
+	}
+}
+
+// titles lists the item titles of a digest, separated by commas
+func titles(summary *Summary) string {
+	names := make([]string, 0)
+	for _, section := range summary.Sections {
+		for _, item := range section.Items {
+			names = append(names, item.Title)
+		}
+	}
+	return strings.Join(names, ",")
+}
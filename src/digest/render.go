@@ -0,0 +1,58 @@
+package digest
+
+import (
+	"bytes"
+	"embed"
+	htmltemplate "html/template"
+	texttemplate "text/template"
+	"time"
+
+	"github.com/user/rss/src/parser"
+)
+
+//go:embed templates/*
+var templateFS embed.FS
+
+// templateFuncs are the helpers available to both digest templates
+var templateFuncs = map[string]interface{}{
+	"date": func(t time.Time) string {
+		return t.Format("January 2, 2006 at 3:04 PM")
+	},
+	"text": func(item parser.FeedItem) string {
+		content := item.Description
+		if content == "" {
+			content = item.Content
+		}
+		return truncate(parser.PlainText(content), 400)
+	},
+}
+
+var (
+	htmlTemplate = htmltemplate.Must(htmltemplate.New("digest.html").
+			Funcs(htmltemplate.FuncMap(templateFuncs)).
+			ParseFS(templateFS, "templates/digest.html"))
+	textTemplate = texttemplate.Must(texttemplate.New("digest.txt").
+			Funcs(texttemplate.FuncMap(templateFuncs)).
+			ParseFS(templateFS, "templates/digest.txt"))
+)
+
+// Render renders a digest summary into HTML and plain text bodies
+func Render(summary *Summary) (string, string, error) {
+	var htmlBody, textBody bytes.Buffer
+	if err := htmlTemplate.Execute(&htmlBody, summary); err != nil {
+		return "", "", err
+	}
+	if err := textTemplate.Execute(&textBody, summary); err != nil {
+		return "", "", err
+	}
+	return htmlBody.String(), textBody.String(), nil
+}
+
+// truncate shortens text to at most max runes, adding an ellipsis
+func truncate(text string, max int) string {
+	runes := []rune(text)
+	if len(runes) <= max {
+		return text
+	}
+	return string(runes[:max]) + "…"
+}
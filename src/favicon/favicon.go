@@ -0,0 +1,438 @@
+package favicon
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/user/rss/src/netguard"
+	"github.com/user/rss/src/parser"
+)
+
+// errNoIcon is returned when none of the places an icon is looked for has one
+var errNoIcon = errors.New("no icon found")
+
+// iconTypes are the image types accepted as icons, with the extension they are
+// stored under. SVG is left out, as it can run scripts when opened from our origin.
+var iconTypes = map[string]string{
+	"image/x-icon":             ".ico",
+	"image/vnd.microsoft.icon": ".ico",
+	"image/png":                ".png",
+	"image/gif":                ".gif",
+	"image/jpeg":               ".jpg",
+	"image/webp":               ".webp",
+	"image/bmp":                ".bmp",
+}
+
+// maxPageSize limits how much of a site's home page is read to find its icon
+const maxPageSize = 1 << 20
+
+// Icon is the stored icon of a feed, or a record that none was found
+type Icon struct {
+	FeedURL string `json:"feed_url"`
+	// Source is where the icon was downloaded from
+	Source string `json:"source,omitempty"`
+	// Path is relative to the icon directory, empty if no icon was found
+	Path      string    `json:"path,omitempty"`
+	Type      string    `json:"type,omitempty"`
+	FetchedAt time.Time `json:"fetched_at,omitempty"`
+	CheckedAt time.Time `json:"checked_at"`
+}
+
+// Config holds configuration for fetching icons
+type Config struct {
+	Dir       string
+	StateFile string
+	// MaxAge is how long an icon is kept before it is downloaded again
+	MaxAge time.Duration
+	// RetryAfter is how long to wait before looking again for the icon of a feed that had none
+	RetryAfter time.Duration
+	// MaxSize is the largest icon in bytes that is stored
+	MaxSize   int64
+	Timeout   time.Duration
+	UserAgent string
+}
+
+// DefaultConfig returns a default configuration
+func DefaultConfig() Config {
+	return Config{
+		Dir:        "icons",
+		StateFile:  "icons.json",
+		MaxAge:     7 * 24 * time.Hour,
+		RetryAfter: 24 * time.Hour,
+		MaxSize:    1 << 20,
+		Timeout:    30 * time.Second,
+		UserAgent:  parser.DefaultFetchConfig().UserAgent,
+	}
+}
+
+// Favicons downloads the icon of each subscribed feed's site, so the sidebar can
+// tell feeds apart
+type Favicons struct {
+	config    Config
+	storage   *parser.Storage
+	icons     map[string]*Icon
+	mutex     sync.RWMutex
+	saveMutex sync.Mutex
+	// running keeps scheduled runs from overlapping
+	running sync.Mutex
+	client  *http.Client
+}
+
+// NewFavicons creates a new favicon store
+func NewFavicons(storage *parser.Storage, config Config) *Favicons {
+	f := &Favicons{
+		config:  config,
+		storage: storage,
+		icons:   make(map[string]*Icon),
+		client:  netguard.Client(config.Timeout),
+	}
+	if err := f.loadState(); err != nil {
+		log.Printf("Warning: Failed to load feed icons: %v", err)
+	}
+	return f
+}
+
+// Icon returns the stored icon of a feed
+func (f *Favicons) Icon(feedURL string) (Icon, bool) {
+	f.mutex.RLock()
+	defer f.mutex.RUnlock()
+
+	icon, ok := f.icons[feedURL]
+	if !ok || icon.Path == "" {
+		return Icon{}, false
+	}
+	return *icon, true
+}
+
+// Path returns where an icon is stored on disk
+func (f *Favicons) Path(icon Icon) string {
+	return filepath.Join(f.config.Dir, filepath.FromSlash(icon.Path))
+}
+
+// Run downloads the icons of feeds that have none yet or whose icon is due for a
+// refresh, and removes the icons of feeds nobody subscribes to anymore
+func (f *Favicons) Run() error {
+	if !f.running.TryLock() {
+		return nil
+	}
+	defer f.running.Unlock()
+
+	feeds := f.storage.GetAllFeeds()
+	f.removeUnused(feeds)
+
+	for _, feed := range feeds {
+		if f.due(feed.URL) {
+			f.update(feed)
+		}
+	}
+	return f.saveState()
+}
+
+// Update looks for the icon of a single feed right away, as when it was just added
+func (f *Favicons) Update(feed *parser.Feed) error {
+	f.running.Lock()
+	defer f.running.Unlock()
+
+	f.update(feed)
+	return f.saveState()
+}
+
+// due reports whether the icon of a feed should be looked for again
+func (f *Favicons) due(feedURL string) bool {
+	f.mutex.RLock()
+	defer f.mutex.RUnlock()
+
+	icon, ok := f.icons[feedURL]
+	if !ok {
+		return true
+	}
+	if icon.Path == "" {
+		return time.Since(icon.CheckedAt) > f.config.RetryAfter
+	}
+	return time.Since(icon.CheckedAt) > f.config.MaxAge
+}
+
+// update finds and stores the icon of a feed. A feed that had an icon keeps it
+// when the new one can't be downloaded.
+func (f *Favicons) update(feed *parser.Feed) {
+	data, contentType, source, err := f.find(feed)
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	icon, ok := f.icons[feed.URL]
+	if !ok {
+		icon = &Icon{FeedURL: feed.URL}
+		f.icons[feed.URL] = icon
+	}
+	icon.CheckedAt = time.Now()
+	if err != nil {
+		if icon.Path == "" {
+			log.Printf("No icon for %s: %v", feed.URL, err)
+		}
+		return
+	}
+
+	name := fmt.Sprintf("%d%s", feed.ID, iconTypes[contentType])
+	if err := f.write(name, data); err != nil {
+		log.Printf("Error storing icon of %s: %v", feed.URL, err)
+		return
+	}
+	if icon.Path != "" && icon.Path != name {
+		os.Remove(filepath.Join(f.config.Dir, icon.Path))
+	}
+	icon.Source = source
+	icon.Path = name
+	icon.Type = contentType
+	icon.FetchedAt = icon.CheckedAt
+}
+
+// find looks for a feed's icon in the feed itself, then in the icon links of its
+// site's home page and finally at /favicon.ico on the site
+func (f *Favicons) find(feed *parser.Feed) ([]byte, string, string, error) {
+	tried := make(map[string]bool)
+	try := func(src string) ([]byte, string, bool) {
+		if src == "" || tried[src] {
+			return nil, "", false
+		}
+		tried[src] = true
+		data, contentType, err := f.download(src)
+		return data, contentType, err == nil
+	}
+
+	for _, src := range []string{feed.IconURL, feed.ImageURL} {
+		if data, contentType, ok := try(src); ok {
+			return data, contentType, src, nil
+		}
+	}
+
+	site := siteURL(feed)
+	if site == nil {
+		return nil, "", "", errNoIcon
+	}
+	links, err := f.pageIcons(site.String())
+	if err != nil {
+		log.Printf("Error reading %s for its icon: %v", site, err)
+	}
+	for _, src := range links {
+		if data, contentType, ok := try(src); ok {
+			return data, contentType, src, nil
+		}
+	}
+
+	src := site.ResolveReference(&url.URL{Path: "/favicon.ico"}).String()
+	if data, contentType, ok := try(src); ok {
+		return data, contentType, src, nil
+	}
+	return nil, "", "", errNoIcon
+}
+
+// pageIcons returns the icons a page links to, favicons before touch icons
+func (f *Favicons) pageIcons(page string) ([]string, error) {
+	resp, err := f.get(page, "text/html,application/xhtml+xml")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	doc, err := goquery.NewDocumentFromReader(io.LimitReader(resp.Body, maxPageSize))
+	if err != nil {
+		return nil, err
+	}
+	base := resp.Request.URL
+	if href, ok := doc.Find("base[href]").First().Attr("href"); ok {
+		if parsed, err := base.Parse(strings.TrimSpace(href)); err == nil {
+			base = parsed
+		}
+	}
+
+	var icons, touchIcons []string
+	doc.Find("link[rel][href]").Each(func(_ int, link *goquery.Selection) {
+		href, _ := link.Attr("href")
+		linkType, _ := link.Attr("type")
+		resolved, err := base.Parse(strings.TrimSpace(href))
+		if err != nil || linkType == "image/svg+xml" || strings.HasSuffix(strings.ToLower(resolved.Path), ".svg") {
+			return
+		}
+		rel, _ := link.Attr("rel")
+		for _, token := range strings.Fields(strings.ToLower(rel)) {
+			switch token {
+			case "icon":
+				icons = append(icons, resolved.String())
+				return
+			case "apple-touch-icon", "apple-touch-icon-precomposed":
+				touchIcons = append(touchIcons, resolved.String())
+				return
+			}
+		}
+	})
+	return append(icons, touchIcons...), nil
+}
+
+// download fetches an icon, checking its size and type
+func (f *Favicons) download(src string) ([]byte, string, error) {
+	resp, err := f.get(src, "image/png,image/x-icon,image/*;q=0.8")
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.ContentLength > f.config.MaxSize {
+		return nil, "", errors.New("icon is too large")
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, f.config.MaxSize+1))
+	if err != nil {
+		return nil, "", err
+	}
+	if int64(len(data)) > f.config.MaxSize {
+		return nil, "", errors.New("icon is too large")
+	}
+	if len(data) == 0 {
+		return nil, "", errors.New("empty response")
+	}
+
+	// Icons are often served with a wrong type, so the content decides
+	contentType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if _, ok := iconTypes[contentType]; !ok {
+		contentType = http.DetectContentType(data)
+	}
+	if _, ok := iconTypes[contentType]; !ok {
+		return nil, "", fmt.Errorf("unsupported icon type %s", contentType)
+	}
+	return data, contentType, nil
+}
+
+// get requests a URL, returning the response only for a successful status
+func (f *Favicons) get(src, accept string) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, src, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", f.config.UserAgent)
+	req.Header.Set("Accept", accept)
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		resp.Body.Close()
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return resp, nil
+}
+
+// write stores an icon file, replacing the previous one in a single step
+func (f *Favicons) write(name string, data []byte) error {
+	if err := os.MkdirAll(f.config.Dir, 0755); err != nil {
+		return err
+	}
+	target := filepath.Join(f.config.Dir, name)
+	tempFile := target + ".tmp"
+	if err := ioutil.WriteFile(tempFile, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tempFile, target)
+}
+
+// removeUnused deletes the icons of feeds that are no longer stored
+func (f *Favicons) removeUnused(feeds []*parser.Feed) {
+	stored := make(map[string]bool, len(feeds))
+	for _, feed := range feeds {
+		stored[feed.URL] = true
+	}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	for feedURL, icon := range f.icons {
+		if stored[feedURL] {
+			continue
+		}
+		if icon.Path != "" {
+			if err := os.Remove(filepath.Join(f.config.Dir, icon.Path)); err != nil && !os.IsNotExist(err) {
+				log.Printf("Error removing icon %s: %v", icon.Path, err)
+			}
+		}
+		delete(f.icons, feedURL)
+	}
+}
+
+// loadState reads the stored icons from the state file
+func (f *Favicons) loadState() error {
+	data, err := ioutil.ReadFile(f.config.StateFile)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var icons []*Icon
+	if err := json.Unmarshal(data, &icons); err != nil {
+		return err
+	}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	for _, icon := range icons {
+		f.icons[icon.FeedURL] = icon
+	}
+	return nil
+}
+
+// saveState writes the stored icons to the state file
+func (f *Favicons) saveState() error {
+	f.saveMutex.Lock()
+	defer f.saveMutex.Unlock()
+
+	f.mutex.RLock()
+	icons := make([]Icon, 0, len(f.icons))
+	for _, icon := range f.icons {
+		icons = append(icons, *icon)
+	}
+	f.mutex.RUnlock()
+
+	sort.Slice(icons, func(i, j int) bool {
+		return icons[i].FeedURL < icons[j].FeedURL
+	})
+	data, err := json.MarshalIndent(icons, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tempFile := f.config.StateFile + ".tmp"
+	if err := ioutil.WriteFile(tempFile, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tempFile, f.config.StateFile)
+}
+
+// siteURL returns the home page of a feed's site: its link, or the root of the
+// host it is served from
+func siteURL(feed *parser.Feed) *url.URL {
+	for _, link := range []string{feed.Link, feed.URL} {
+		parsed, err := url.Parse(strings.TrimSpace(link))
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			continue
+		}
+		if link == feed.URL {
+			return &url.URL{Scheme: parsed.Scheme, Host: parsed.Host, Path: "/"}
+		}
+		return parsed
+	}
+	return nil
+}
@@ -0,0 +1,383 @@
+package imageproxy
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/user/rss/src/netguard"
+	"github.com/user/rss/src/parser"
+)
+
+var (
+	// ErrTooLarge is returned for images over the size limit
+	ErrTooLarge = errors.New("image is too large")
+	// ErrNotImage is returned when the URL doesn't serve an image of an allowed type
+	ErrNotImage = errors.New("not a supported image")
+)
+
+// imageTypes are the image types that are proxied, with the extension they are
+// cached under. SVG is left out, as it can run scripts when opened from our origin.
+var imageTypes = map[string]string{
+	"image/jpeg":               ".jpg",
+	"image/png":                ".png",
+	"image/gif":                ".gif",
+	"image/webp":               ".webp",
+	"image/avif":               ".avif",
+	"image/bmp":                ".bmp",
+	"image/x-icon":             ".ico",
+	"image/vnd.microsoft.icon": ".ico",
+}
+
+// touchInterval is how often the modification time of a cached image is updated
+// when it is used, which is what orders the cache after a restart
+const touchInterval = time.Hour
+
+// Config holds configuration for the image proxy
+type Config struct {
+	Dir string
+	// KeyFile holds the secret proxy URLs are signed with; it is created if missing
+	KeyFile string
+	// MaxSize is the largest image in bytes that is proxied
+	MaxSize int64
+	// CacheSize is the disk space in bytes cached images may use
+	CacheSize int64
+	// MaxAge is how long browsers may keep an image
+	MaxAge    time.Duration
+	Timeout   time.Duration
+	UserAgent string
+}
+
+// DefaultConfig returns a default configuration
+func DefaultConfig() Config {
+	return Config{
+		Dir:       "images",
+		KeyFile:   "image_proxy.key",
+		MaxSize:   10 << 20,
+		CacheSize: 512 << 20,
+		MaxAge:    7 * 24 * time.Hour,
+		Timeout:   30 * time.Second,
+		UserAgent: parser.DefaultFetchConfig().UserAgent,
+	}
+}
+
+// Image is a cached image ready to be served
+type Image struct {
+	Path string
+	Type string
+	// ETag identifies the image, which never changes for a URL
+	ETag string
+}
+
+// entry is an image in the cache
+type entry struct {
+	name string
+	size int64
+	used time.Time
+}
+
+// call is a download in progress that other requests for the same image wait for
+type call struct {
+	done  chan struct{}
+	image Image
+	err   error
+}
+
+// Proxy fetches images on behalf of the browser and keeps them in a disk cache,
+// so readers don't contact the hosts of the images and images load over HTTPS
+// whenever the reader does. Image URLs come from feeds, so the proxy only
+// connects to public addresses, also when following redirects.
+type Proxy struct {
+	config   Config
+	key      []byte
+	client   *http.Client
+	entries  map[string]*entry
+	size     int64
+	fetching map[string]*call
+	mutex    sync.Mutex
+}
+
+// NewProxy creates a new image proxy, loading the signing key and the cache index
+func NewProxy(config Config) (*Proxy, error) {
+	if err := os.MkdirAll(config.Dir, 0755); err != nil {
+		return nil, err
+	}
+	key, err := loadKey(config.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("loading image proxy key: %v", err)
+	}
+
+	p := &Proxy{
+		config:   config,
+		key:      key,
+		client:   netguard.Client(config.Timeout),
+		entries:  make(map[string]*entry),
+		fetching: make(map[string]*call),
+	}
+	if err := p.loadCache(); err != nil {
+		log.Printf("Warning: Failed to read the image cache: %v", err)
+	}
+	return p, nil
+}
+
+// URL returns the proxy URL of an image. Anything but http and https URLs is
+// returned unchanged.
+func (p *Proxy) URL(src string) string {
+	parsed, err := url.Parse(strings.TrimSpace(src))
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return src
+	}
+	src = parsed.String()
+	return "/img?url=" + url.QueryEscape(src) + "&sig=" + p.sign(src)
+}
+
+// Verify checks the signature of a proxy URL
+func (p *Proxy) Verify(src, signature string) bool {
+	return hmac.Equal([]byte(p.sign(src)), []byte(signature))
+}
+
+// sign returns the signature of an image URL
+func (p *Proxy) sign(src string) string {
+	mac := hmac.New(sha256.New, p.key)
+	mac.Write([]byte(src))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil)[:16])
+}
+
+// Get returns an image from the cache, downloading it first if needed.
+// Concurrent requests for the same image share one download.
+func (p *Proxy) Get(src string) (Image, error) {
+	name := cacheKey(src)
+
+	p.mutex.Lock()
+	if cached, ok := p.entries[name]; ok {
+		touch := time.Since(cached.used) > touchInterval
+		cached.used = time.Now()
+		p.mutex.Unlock()
+
+		image := p.image(cached.name)
+		if touch {
+			os.Chtimes(image.Path, cached.used, cached.used)
+		}
+		return image, nil
+	}
+	if pending, ok := p.fetching[name]; ok {
+		p.mutex.Unlock()
+		<-pending.done
+		return pending.image, pending.err
+	}
+	pending := &call{done: make(chan struct{})}
+	p.fetching[name] = pending
+	p.mutex.Unlock()
+
+	pending.image, pending.err = p.download(src, name)
+
+	p.mutex.Lock()
+	delete(p.fetching, name)
+	p.mutex.Unlock()
+	close(pending.done)
+	return pending.image, pending.err
+}
+
+// download fetches an image into the cache and makes room for it
+func (p *Proxy) download(src, name string) (Image, error) {
+	req, err := http.NewRequest("GET", src, nil)
+	if err != nil {
+		return Image{}, err
+	}
+	req.Header.Set("User-Agent", p.config.UserAgent)
+	req.Header.Set("Accept", "image/avif,image/webp,image/png,image/jpeg,image/gif,image/*;q=0.8")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return Image{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return Image{}, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	if resp.ContentLength > p.config.MaxSize {
+		return Image{}, ErrTooLarge
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, p.config.MaxSize+1))
+	if err != nil {
+		return Image{}, err
+	}
+	if int64(len(data)) > p.config.MaxSize {
+		return Image{}, ErrTooLarge
+	}
+	if len(data) == 0 {
+		return Image{}, ErrNotImage
+	}
+
+	// Servers often send images as application/octet-stream, so the content decides
+	contentType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if _, ok := imageTypes[contentType]; !ok {
+		contentType = http.DetectContentType(data)
+	}
+	ext, ok := imageTypes[contentType]
+	if !ok {
+		return Image{}, ErrNotImage
+	}
+
+	fileName := name + ext
+	partial := filepath.Join(p.config.Dir, fileName+".tmp")
+	if err := os.WriteFile(partial, data, 0644); err != nil {
+		return Image{}, err
+	}
+	if err := os.Rename(partial, filepath.Join(p.config.Dir, fileName)); err != nil {
+		os.Remove(partial)
+		return Image{}, err
+	}
+
+	p.mutex.Lock()
+	if previous, ok := p.entries[name]; ok {
+		p.size -= previous.size
+	}
+	p.entries[name] = &entry{name: fileName, size: int64(len(data)), used: time.Now()}
+	p.size += int64(len(data))
+	p.evictLocked()
+	p.mutex.Unlock()
+
+	return p.image(fileName), nil
+}
+
+// evictLocked removes the least recently used images until the cache fits its size
+func (p *Proxy) evictLocked() {
+	if p.size <= p.config.CacheSize {
+		return
+	}
+
+	entries := make([]*entry, 0, len(p.entries))
+	for _, cached := range p.entries {
+		entries = append(entries, cached)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].used.Before(entries[j].used)
+	})
+
+	for _, cached := range entries {
+		if p.size <= p.config.CacheSize {
+			break
+		}
+		if err := os.Remove(filepath.Join(p.config.Dir, cached.name)); err != nil && !os.IsNotExist(err) {
+			log.Printf("Error removing cached image %s: %v", cached.name, err)
+			continue
+		}
+		delete(p.entries, strings.TrimSuffix(cached.name, filepath.Ext(cached.name)))
+		p.size -= cached.size
+	}
+}
+
+// MaxAge returns how long browsers may keep an image
+func (p *Proxy) MaxAge() time.Duration {
+	return p.config.MaxAge
+}
+
+// Usage returns the disk space used by cached images in bytes
+func (p *Proxy) Usage() int64 {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.size
+}
+
+// image describes a cached file
+func (p *Proxy) image(fileName string) Image {
+	ext := filepath.Ext(fileName)
+	return Image{
+		Path: filepath.Join(p.config.Dir, fileName),
+		Type: typeOf(ext),
+		ETag: `"` + strings.TrimSuffix(fileName, ext) + `"`,
+	}
+}
+
+// typeOf returns the content type of a cached image by its extension
+func typeOf(ext string) string {
+	if ext == ".ico" {
+		return "image/x-icon"
+	}
+	for imageType, typeExt := range imageTypes {
+		if typeExt == ext {
+			return imageType
+		}
+	}
+	return "application/octet-stream"
+}
+
+// loadCache indexes the images already on disk, ordered by when they were last used
+func (p *Proxy) loadCache() error {
+	files, err := os.ReadDir(p.config.Dir)
+	if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		if file.IsDir() {
+			continue
+		}
+		fileName := file.Name()
+		// Left behind by downloads that were interrupted
+		if strings.HasSuffix(fileName, ".tmp") {
+			os.Remove(filepath.Join(p.config.Dir, fileName))
+			continue
+		}
+		info, err := file.Info()
+		if err != nil {
+			continue
+		}
+		name := strings.TrimSuffix(fileName, filepath.Ext(fileName))
+		p.entries[name] = &entry{name: fileName, size: info.Size(), used: info.ModTime()}
+		p.size += info.Size()
+	}
+
+	p.mutex.Lock()
+	p.evictLocked()
+	p.mutex.Unlock()
+	return nil
+}
+
+// cacheKey returns the name an image is cached under
+func cacheKey(src string) string {
+	sum := sha256.Sum256([]byte(src))
+	return hex.EncodeToString(sum[:])
+}
+
+// loadKey reads the signing key, creating a random one on first use
+func loadKey(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err == nil {
+		key, err := hex.DecodeString(strings.TrimSpace(string(data)))
+		if err != nil || len(key) < 16 {
+			return nil, errors.New("invalid key file " + path)
+		}
+		return key, nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(path, []byte(hex.EncodeToString(key)+"\n"), 0600); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
@@ -0,0 +1,210 @@
+package imageproxy
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/user/rss/src/netguard"
+)
+
+// pngData returns a PNG signature padded to size bytes, enough for content sniffing
+func pngData(size int) []byte {
+	return append([]byte("\x89PNG\r\n\x1a\n"), bytes.Repeat([]byte{0}, size-8)...)
+}
+
+// origin serves test images and counts the requests it gets
+func origin(t *testing.T) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		switch r.URL.Path {
+		case "/a.png", "/b.png", "/c.png":
+			w.Header().Set("Content-Type", "image/png")
+			w.Write(pngData(40))
+		case "/octet":
+			// The content decides when the type is generic
+			w.Header().Set("Content-Type", "application/octet-stream")
+			w.Write([]byte("GIF89a\x01\x00\x01\x00\x00\x00\x00;"))
+		case "/page":
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte("<html><body>Not an image</body></html>"))
+		case "/image.svg":
+			w.Header().Set("Content-Type", "image/svg+xml")
+			w.Write([]byte(`<svg xmlns="http://www.w3.org/2000/svg"><script>alert(1)</script></svg>`))
+		case "/large.png":
+			w.Header().Set("Content-Type", "image/png")
+			w.Write(pngData(200))
+		case "/chunked.png":
+			// Without a Content-Length the limit applies while reading
+			w.Header().Set("Content-Type", "image/png")
+			w.(http.Flusher).Flush()
+			w.Write(pngData(200))
+		case "/redirect":
+			http.Redirect(w, r, "/a.png", http.StatusFound)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server, &requests
+}
+
+// newTestProxy returns a proxy caching in a temporary directory. Unless public is
+// set, it may connect to the loopback test origin.
+func newTestProxy(t *testing.T, dir string, public bool) *Proxy {
+	t.Helper()
+	config := DefaultConfig()
+	config.Dir = filepath.Join(dir, "images")
+	config.KeyFile = filepath.Join(dir, "image_proxy.key")
+	config.MaxSize = 100
+	config.CacheSize = 100
+	p, err := NewProxy(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !public {
+		p.client = &http.Client{Timeout: config.Timeout}
+	}
+	return p
+}
+
+func TestSignAndVerify(t *testing.T) {
+	dir := t.TempDir()
+	p := newTestProxy(t, dir, true)
+
+	src := "https://example.com/images/a b.png?size=large"
+	proxied, err := url.Parse(p.URL(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if proxied.Path != "/img" {
+		t.Errorf("proxy URL = %s, want /img", proxied)
+	}
+	signed, signature := proxied.Query().Get("url"), proxied.Query().Get("sig")
+	if !p.Verify(signed, signature) {
+		t.Errorf("signature of %s was rejected", signed)
+	}
+	if p.Verify("https://example.com/other.png", signature) {
+		t.Error("signature was accepted for another URL")
+	}
+	if p.Verify(signed, "") {
+		t.Error("an empty signature was accepted")
+	}
+
+	// The key is kept, so URLs in pages rendered before a restart still work
+	if restarted := newTestProxy(t, dir, true); !restarted.Verify(signed, signature) {
+		t.Error("signature was rejected after a restart")
+	}
+	if other := newTestProxy(t, t.TempDir(), true); other.Verify(signed, signature) {
+		t.Error("signature was accepted with another key")
+	}
+
+	for _, src := range []string{"data:image/png;base64,AAAA", "/relative.png", "javascript:alert(1)", "http:///nohost.png"} {
+		if got := p.URL(src); got != src {
+			t.Errorf("URL(%q) = %q, want it unchanged", src, got)
+		}
+	}
+}
+
+func TestGet(t *testing.T) {
+	server, requests := origin(t)
+	p := newTestProxy(t, t.TempDir(), false)
+
+	image, err := p.Get(server.URL + "/a.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if image.Type != "image/png" || !strings.HasSuffix(image.Path, ".png") {
+		t.Errorf("image = %+v, want a PNG", image)
+	}
+	if data, err := os.ReadFile(image.Path); err != nil || !bytes.Equal(data, pngData(40)) {
+		t.Errorf("cached file doesn't hold the image: %v", err)
+	}
+	if _, err := p.Get(server.URL + "/a.png"); err != nil {
+		t.Fatal(err)
+	}
+	if got := requests.Load(); got != 1 {
+		t.Errorf("origin got %d requests, want 1 with the second served from the cache", got)
+	}
+
+	if image, err := p.Get(server.URL + "/octet"); err != nil || image.Type != "image/gif" {
+		t.Errorf("octet-stream image = %+v, %v, want a GIF", image, err)
+	}
+	if image, err := p.Get(server.URL + "/redirect"); err != nil || image.Type != "image/png" {
+		t.Errorf("redirected image = %+v, %v, want a PNG", image, err)
+	}
+
+	for path, want := range map[string]error{
+		"/page":        ErrNotImage,
+		"/image.svg":   ErrNotImage,
+		"/large.png":   ErrTooLarge,
+		"/chunked.png": ErrTooLarge,
+	} {
+		if _, err := p.Get(server.URL + path); !errors.Is(err, want) {
+			t.Errorf("%s: err = %v, want %v", path, err, want)
+		}
+	}
+	if _, err := p.Get(server.URL + "/missing.png"); err == nil {
+		t.Error("a missing image was served")
+	}
+}
+
+func TestEviction(t *testing.T) {
+	server, _ := origin(t)
+	dir := t.TempDir()
+	p := newTestProxy(t, dir, false)
+
+	get := func(name string) Image {
+		t.Helper()
+		image, err := p.Get(server.URL + "/" + name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return image
+	}
+	a := get("a.png")
+	b := get("b.png")
+	get("a.png")
+	c := get("c.png")
+
+	// The cache holds two 40 byte images, and b was used least recently
+	if usage := p.Usage(); usage != 80 {
+		t.Errorf("usage = %d, want 80", usage)
+	}
+	if _, err := os.Stat(b.Path); !os.IsNotExist(err) {
+		t.Errorf("least recently used image was kept: %v", err)
+	}
+	for _, image := range []Image{a, c} {
+		if _, err := os.Stat(image.Path); err != nil {
+			t.Errorf("recently used image was removed: %v", err)
+		}
+	}
+
+	// The cache index is rebuilt from disk after a restart
+	if restarted := newTestProxy(t, dir, false); restarted.Usage() != 80 {
+		t.Errorf("usage after a restart = %d, want 80", restarted.Usage())
+	}
+}
+
+func TestRefusesPrivateAddresses(t *testing.T) {
+	server, requests := origin(t)
+	p := newTestProxy(t, t.TempDir(), true)
+
+	for _, src := range []string{server.URL + "/a.png", "http://localhost:1/a.png", "http://169.254.169.254/latest/meta-data/"} {
+		if _, err := p.Get(src); !errors.Is(err, netguard.ErrNotPublic) {
+			t.Errorf("%s: err = %v, want ErrNotPublic", src, err)
+		}
+	}
+	if got := requests.Load(); got != 0 {
+		t.Errorf("origin got %d requests, want none", got)
+	}
+}
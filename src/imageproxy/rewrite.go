@@ -0,0 +1,72 @@
+package imageproxy
+
+import (
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// RewriteHTML points the images of an HTML fragment at the proxy. The src and
+// srcset of <img> and the srcset of <picture> sources are rewritten; other tags
+// are left exactly as they were.
+func (p *Proxy) RewriteHTML(content string) string {
+	if !strings.Contains(content, "<img") && !strings.Contains(content, "<source") {
+		return content
+	}
+
+	var builder strings.Builder
+	tokenizer := html.NewTokenizer(strings.NewReader(content))
+	for {
+		tokenType := tokenizer.Next()
+		if tokenType == html.ErrorToken {
+			builder.Write(tokenizer.Raw())
+			return builder.String()
+		}
+		if tokenType != html.StartTagToken && tokenType != html.SelfClosingTagToken {
+			builder.Write(tokenizer.Raw())
+			continue
+		}
+
+		raw := string(tokenizer.Raw())
+		token := tokenizer.Token()
+		if token.Data != "img" && token.Data != "source" {
+			builder.WriteString(raw)
+			continue
+		}
+
+		changed := false
+		for i, attr := range token.Attr {
+			var rewritten string
+			switch {
+			case attr.Key == "src" && token.Data == "img":
+				rewritten = p.URL(attr.Val)
+			case attr.Key == "srcset":
+				rewritten = p.rewriteSrcset(attr.Val)
+			default:
+				continue
+			}
+			if rewritten != attr.Val {
+				token.Attr[i].Val = rewritten
+				changed = true
+			}
+		}
+		if changed {
+			builder.WriteString(token.String())
+		} else {
+			builder.WriteString(raw)
+		}
+	}
+}
+
+// rewriteSrcset points the candidates of an srcset attribute at the proxy
+func (p *Proxy) rewriteSrcset(srcset string) string {
+	candidates := strings.Split(srcset, ",")
+	for i, candidate := range candidates {
+		fields := strings.Fields(candidate)
+		if len(fields) > 0 {
+			fields[0] = p.URL(fields[0])
+		}
+		candidates[i] = strings.Join(fields, " ")
+	}
+	return strings.Join(candidates, ", ")
+}
@@ -0,0 +1,56 @@
+package lockfile
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// ErrLocked is returned when another process holds the lock
+var ErrLocked = errors.New("locked by another process")
+
+// Lock is an exclusive lock on a file, held until it is released or the process exits
+type Lock struct {
+	file *os.File
+}
+
+// Acquire takes the lock on path, creating the file if needed. It fails with an
+// error wrapping ErrLocked, and naming the holder's process ID when known, instead
+// of waiting for another process to release it.
+func Acquire(path string) (*Lock, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := lock(file); err != nil {
+		holder, _ := os.ReadFile(path)
+		file.Close()
+		if pid, convErr := strconv.Atoi(strings.TrimSpace(string(holder))); convErr == nil {
+			return nil, fmt.Errorf("%s: %w (pid %d)", path, ErrLocked, pid)
+		}
+		return nil, fmt.Errorf("%s: %w", path, ErrLocked)
+	}
+
+	// Record the holder so the next process can say who has the lock
+	if err := file.Truncate(0); err == nil {
+		file.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0)
+	}
+	return &Lock{file: file}, nil
+}
+
+// Release gives up the lock. The file is left in place, since removing it could
+// let two processes lock different files under the same name.
+func (l *Lock) Release() error {
+	if l == nil || l.file == nil {
+		return nil
+	}
+	err := unlock(l.file)
+	if closeErr := l.file.Close(); err == nil {
+		err = closeErr
+	}
+	l.file = nil
+	return err
+}
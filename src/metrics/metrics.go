@@ -0,0 +1,340 @@
+package metrics
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultBuckets are histogram buckets in seconds suited to network requests
+var DefaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+
+// Default is the registry the application's metrics are registered in
+var Default = NewRegistry()
+
+// ErrDuplicate is returned when a metric name is already registered
+var ErrDuplicate = errors.New("metric is already registered")
+
+// collector is a metric family that can write itself in the text exposition format
+type collector interface {
+	name() string
+	write(w *bufio.Writer)
+}
+
+// Registry holds metric families and renders them for Prometheus
+type Registry struct {
+	collectors map[string]collector
+	mutex      sync.RWMutex
+}
+
+// NewRegistry creates an empty registry
+func NewRegistry() *Registry {
+	return &Registry{collectors: make(map[string]collector)}
+}
+
+// register adds a metric family. If the name is taken it returns the family
+// registered under it along with ErrDuplicate.
+func (r *Registry) register(c collector) (collector, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if existing, ok := r.collectors[c.name()]; ok {
+		return existing, ErrDuplicate
+	}
+	r.collectors[c.name()] = c
+	return c, nil
+}
+
+// WriteTo writes all metrics in the Prometheus text exposition format, ordered by name
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mutex.RLock()
+	collectors := make([]collector, 0, len(r.collectors))
+	for _, c := range r.collectors {
+		collectors = append(collectors, c)
+	}
+	r.mutex.RUnlock()
+
+	sort.Slice(collectors, func(i, j int) bool {
+		return collectors[i].name() < collectors[j].name()
+	})
+
+	counter := &countingWriter{w: w}
+	buf := bufio.NewWriter(counter)
+	for _, c := range collectors {
+		c.write(buf)
+	}
+	err := buf.Flush()
+	return counter.n, err
+}
+
+// CounterVec is a counter partitioned by labels
+type CounterVec struct {
+	metricName string
+	help       string
+	labels     []string
+	values     map[string]*sample
+	mutex      sync.Mutex
+}
+
+// sample is the value of one label combination
+type sample struct {
+	labelValues []string
+	value       float64
+}
+
+// NewCounterVec creates and registers a counter. Registering a name again
+// returns the counter already registered under it.
+func (r *Registry) NewCounterVec(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{
+		metricName: name,
+		help:       help,
+		labels:     labels,
+		values:     make(map[string]*sample),
+	}
+	// Without labels there is a single series, which is reported from the start
+	if len(labels) == 0 {
+		c.values[""] = &sample{}
+	}
+	existing, err := r.register(c)
+	if err != nil {
+		counter, ok := existing.(*CounterVec)
+		if !ok {
+			panic("metrics: " + name + " is already registered as another type")
+		}
+		return counter
+	}
+	return c
+}
+
+// Inc adds one to the counter with the given label values
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add adds a non-negative value to the counter with the given label values
+func (c *CounterVec) Add(value float64, labelValues ...string) {
+	if value < 0 {
+		return
+	}
+
+	key := labelKey(labelValues)
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	s, ok := c.values[key]
+	if !ok {
+		s = &sample{labelValues: append([]string(nil), labelValues...)}
+		c.values[key] = s
+	}
+	s.value += value
+}
+
+func (c *CounterVec) name() string {
+	return c.metricName
+}
+
+func (c *CounterVec) write(w *bufio.Writer) {
+	writeHeader(w, c.metricName, c.help, "counter")
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for _, key := range sortedKeys(c.values) {
+		s := c.values[key]
+		fmt.Fprintf(w, "%s%s %s\n", c.metricName, formatLabels(c.labels, s.labelValues), formatValue(s.value))
+	}
+}
+
+// HistogramVec is a histogram partitioned by labels
+type HistogramVec struct {
+	metricName string
+	help       string
+	labels     []string
+	buckets    []float64
+	values     map[string]*histogram
+	mutex      sync.Mutex
+}
+
+// histogram holds the observations of one label combination
+type histogram struct {
+	labelValues []string
+	counts      []uint64
+	count       uint64
+	sum         float64
+}
+
+// NewHistogramVec creates and registers a histogram with the given upper bounds.
+// Registering a name again returns the histogram already registered under it.
+func (r *Registry) NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	sorted := append([]float64(nil), buckets...)
+	sort.Float64s(sorted)
+
+	h := &HistogramVec{
+		metricName: name,
+		help:       help,
+		labels:     labels,
+		buckets:    sorted,
+		values:     make(map[string]*histogram),
+	}
+	if len(labels) == 0 {
+		h.values[""] = &histogram{counts: make([]uint64, len(sorted))}
+	}
+	existing, err := r.register(h)
+	if err != nil {
+		hist, ok := existing.(*HistogramVec)
+		if !ok {
+			panic("metrics: " + name + " is already registered as another type")
+		}
+		return hist
+	}
+	return h
+}
+
+// Observe records a value for the given label values
+func (h *HistogramVec) Observe(value float64, labelValues ...string) {
+	key := labelKey(labelValues)
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	hist, ok := h.values[key]
+	if !ok {
+		hist = &histogram{
+			labelValues: append([]string(nil), labelValues...),
+			counts:      make([]uint64, len(h.buckets)),
+		}
+		h.values[key] = hist
+	}
+
+	for i, bound := range h.buckets {
+		if value <= bound {
+			hist.counts[i]++
+		}
+	}
+	hist.count++
+	hist.sum += value
+}
+
+func (h *HistogramVec) name() string {
+	return h.metricName
+}
+
+func (h *HistogramVec) write(w *bufio.Writer) {
+	writeHeader(w, h.metricName, h.help, "histogram")
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	bucketLabels := append(append([]string(nil), h.labels...), "le")
+	for _, key := range sortedKeys(h.values) {
+		hist := h.values[key]
+		for i, bound := range h.buckets {
+			values := append(append([]string(nil), hist.labelValues...), formatValue(bound))
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.metricName, formatLabels(bucketLabels, values), hist.counts[i])
+		}
+		values := append(append([]string(nil), hist.labelValues...), "+Inf")
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.metricName, formatLabels(bucketLabels, values), hist.count)
+
+		labels := formatLabels(h.labels, hist.labelValues)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.metricName, labels, formatValue(hist.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.metricName, labels, hist.count)
+	}
+}
+
+// GaugeFunc is a gauge whose value is read when metrics are collected
+type GaugeFunc struct {
+	metricName string
+	help       string
+	value      func() float64
+}
+
+// NewGaugeFunc creates and registers a gauge reporting the value of a function.
+// It fails with ErrDuplicate if the name is taken, since the gauge already
+// registered would keep reporting another function's value.
+func (r *Registry) NewGaugeFunc(name, help string, value func() float64) (*GaugeFunc, error) {
+	g := &GaugeFunc{
+		metricName: name,
+		help:       help,
+		value:      value,
+	}
+	if _, err := r.register(g); err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return g, nil
+}
+
+func (g *GaugeFunc) name() string {
+	return g.metricName
+}
+
+func (g *GaugeFunc) write(w *bufio.Writer) {
+	writeHeader(w, g.metricName, g.help, "gauge")
+	fmt.Fprintf(w, "%s %s\n", g.metricName, formatValue(g.value()))
+}
+
+// writeHeader writes the HELP and TYPE lines of a metric family
+func writeHeader(w *bufio.Writer, name, help, kind string) {
+	help = strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(help)
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+// formatLabels renders label pairs as {a="1",b="2"}
+func formatLabels(names, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+
+	escaper := strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		value := ""
+		if i < len(values) {
+			value = values[i]
+		}
+		pairs[i] = name + `="` + escaper.Replace(value) + `"`
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// formatValue renders a sample value the way Prometheus expects
+func formatValue(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	case math.IsNaN(value):
+		return "NaN"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+// labelKey joins label values into a map key
+func labelKey(values []string) string {
+	return strings.Join(values, "\xff")
+}
+
+// sortedKeys returns the keys of a map in order so output is stable
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// countingWriter counts the bytes written through it
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
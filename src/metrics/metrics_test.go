@@ -0,0 +1,108 @@
+package metrics
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// render returns the text exposition of a registry
+func render(t *testing.T, registry *Registry) string {
+	t.Helper()
+	var out strings.Builder
+	n, err := registry.WriteTo(&out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != int64(out.Len()) {
+		t.Errorf("WriteTo reported %d bytes, wrote %d", n, out.Len())
+	}
+	return out.String()
+}
+
+func TestTextFormat(t *testing.T) {
+	registry := NewRegistry()
+	requests := registry.NewCounterVec("test_requests_total", "Requests by path.", "path", "status")
+	registry.NewCounterVec("test_saves_total", "Saves.")
+	if _, err := registry.NewGaugeFunc("test_users", "Accounts.", func() float64 { return 3 }); err != nil {
+		t.Fatal(err)
+	}
+
+	requests.Inc("/b", "200")
+	requests.Add(2, "/a", "404")
+	requests.Add(-1, "/a", "404")
+
+	want := `# HELP test_requests_total Requests by path.
+# TYPE test_requests_total counter
+test_requests_total{path="/a",status="404"} 2
+test_requests_total{path="/b",status="200"} 1
+# HELP test_saves_total Saves.
+# TYPE test_saves_total counter
+test_saves_total 0
+# HELP test_users Accounts.
+# TYPE test_users gauge
+test_users 3
+`
+	if got := render(t, registry); got != want {
+		t.Errorf("unexpected output:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestEscaping(t *testing.T) {
+	registry := NewRegistry()
+	counter := registry.NewCounterVec("test_total", "Help with \\ and\nnewline.", "feed")
+	counter.Inc("http://example.com/\"quoted\"\\path\nnext")
+
+	got := render(t, registry)
+	for _, want := range []string{
+		`# HELP test_total Help with \\ and\nnewline.`,
+		`test_total{feed="http://example.com/\"quoted\"\\path\nnext"} 1`,
+	} {
+		if !strings.Contains(got, want+"\n") {
+			t.Errorf("output lacks %q:\n%s", want, got)
+		}
+	}
+}
+
+func TestHistogramBuckets(t *testing.T) {
+	registry := NewRegistry()
+	histogram := registry.NewHistogramVec("test_seconds", "Durations.", []float64{1, 0.5, 2}, "route")
+	for _, value := range []float64{0.25, 0.5, 1.5, 3} {
+		histogram.Observe(value, "/feed")
+	}
+
+	want := `# HELP test_seconds Durations.
+# TYPE test_seconds histogram
+test_seconds_bucket{route="/feed",le="0.5"} 2
+test_seconds_bucket{route="/feed",le="1"} 2
+test_seconds_bucket{route="/feed",le="2"} 3
+test_seconds_bucket{route="/feed",le="+Inf"} 4
+test_seconds_sum{route="/feed"} 5.25
+test_seconds_count{route="/feed"} 4
+`
+	if got := render(t, registry); got != want {
+		t.Errorf("unexpected output:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestDuplicateNames(t *testing.T) {
+	registry := NewRegistry()
+	first := registry.NewCounterVec("test_total", "Counter.", "feed")
+	if second := registry.NewCounterVec("test_total", "Counter.", "feed"); second != first {
+		t.Error("registering a counter again returned a new counter")
+	}
+	histogram := registry.NewHistogramVec("test_seconds", "Histogram.", DefaultBuckets)
+	if again := registry.NewHistogramVec("test_seconds", "Histogram.", DefaultBuckets); again != histogram {
+		t.Error("registering a histogram again returned a new histogram")
+	}
+
+	if _, err := registry.NewGaugeFunc("test_gauge", "Gauge.", func() float64 { return 1 }); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := registry.NewGaugeFunc("test_gauge", "Gauge.", func() float64 { return 2 }); !errors.Is(err, ErrDuplicate) {
+		t.Errorf("registering a gauge again: err = %v, want ErrDuplicate", err)
+	}
+	if got := render(t, registry); !strings.Contains(got, "test_gauge 1\n") {
+		t.Errorf("the first gauge was replaced:\n%s", got)
+	}
+}
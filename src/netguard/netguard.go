@@ -0,0 +1,89 @@
+package netguard
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+)
+
+// ErrNotPublic is returned for connections to addresses that aren't on the public internet
+var ErrNotPublic = errors.New("address is not public")
+
+// reservedNetworks are ranges that net.IP has no predicate for
+var reservedNetworks = []*net.IPNet{
+	mustParseCIDR("0.0.0.0/8"),
+	mustParseCIDR("100.64.0.0/10"),
+	mustParseCIDR("192.0.0.0/24"),
+	mustParseCIDR("198.18.0.0/15"),
+	mustParseCIDR("240.0.0.0/4"),
+	mustParseCIDR("64:ff9b::/96"),
+}
+
+// IsPublic reports whether an IP address is on the public internet, as opposed to
+// loopback, private, link-local, multicast and other reserved addresses
+func IsPublic(ip net.IP) bool {
+	if ip == nil || ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() || ip.IsMulticast() {
+		return false
+	}
+	for _, network := range reservedNetworks {
+		if network.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// CheckHost resolves a host name and returns ErrNotPublic if any of its addresses isn't public
+func CheckHost(ctx context.Context, host string) error {
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return err
+	}
+	for _, addr := range addrs {
+		if !IsPublic(addr.IP) {
+			return ErrNotPublic
+		}
+	}
+	return nil
+}
+
+// Client returns an HTTP client that only connects to public addresses. The
+// address is checked when connecting, so redirects and host names that resolve
+// differently later are covered too. Proxies from the environment are not used,
+// as they would be checked instead of the destination.
+func Client(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+		Control:   control,
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return &http.Client{Timeout: timeout, Transport: transport}
+}
+
+// control refuses connections to addresses that aren't public
+func control(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if !IsPublic(net.ParseIP(host)) {
+		return ErrNotPublic
+	}
+	return nil
+}
+
+// mustParseCIDR parses a network, panicking on a malformed one
+func mustParseCIDR(cidr string) *net.IPNet {
+	_, network, err := net.ParseCIDR(cidr)
+	if err != nil {
+		panic(err)
+	}
+	return network
+}
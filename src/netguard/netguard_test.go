@@ -0,0 +1,59 @@
+package netguard
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestIsPublic(t *testing.T) {
+	tests := []struct {
+		ip     string
+		public bool
+	}{
+		{"93.184.216.34", true},
+		{"2606:2800:220:1:248:1893:25c8:1946", true},
+		{"127.0.0.1", false},
+		{"::1", false},
+		{"10.1.2.3", false},
+		{"172.16.0.1", false},
+		{"192.168.1.1", false},
+		{"169.254.169.254", false},
+		{"fe80::1", false},
+		{"fc00::1", false},
+		{"0.0.0.0", false},
+		{"100.64.0.1", false},
+		{"224.0.0.1", false},
+		{"::ffff:127.0.0.1", false},
+		{"::ffff:10.0.0.1", false},
+	}
+	for _, test := range tests {
+		if got := IsPublic(net.ParseIP(test.ip)); got != test.public {
+			t.Errorf("IsPublic(%s) = %v, want %v", test.ip, got, test.public)
+		}
+	}
+}
+
+func TestCheckHost(t *testing.T) {
+	for _, host := range []string{"127.0.0.1", "localhost", "::1", "10.0.0.1"} {
+		if err := CheckHost(context.Background(), host); !errors.Is(err, ErrNotPublic) {
+			t.Errorf("CheckHost(%s) = %v, want ErrNotPublic", host, err)
+		}
+	}
+}
+
+func TestClientRefusesLoopback(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("internal"))
+	}))
+	defer server.Close()
+
+	_, err := Client(5 * time.Second).Get(server.URL)
+	if !errors.Is(err, ErrNotPublic) {
+		t.Fatalf("request to %s: %v, want ErrNotPublic", server.URL, err)
+	}
+}
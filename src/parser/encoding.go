@@ -0,0 +1,78 @@
+package parser
+
+import (
+	"bytes"
+	"mime"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/net/html/charset"
+	"golang.org/x/text/encoding/unicode"
+)
+
+var (
+	utf8BOM    = []byte{0xEF, 0xBB, 0xBF}
+	utf16BEBOM = []byte{0xFE, 0xFF}
+	utf16LEBOM = []byte{0xFF, 0xFE}
+)
+
+// xmlEncoding matches the encoding in an XML declaration
+var xmlEncoding = regexp.MustCompile(`^\s*<\?xml[^>]*?encoding=["']([^"']*)["']`)
+
+// decodeFeed prepares a feed document for parsing. Byte order marks are removed and
+// UTF-16 is converted to UTF-8. Documents that aren't valid UTF-8 and don't declare
+// another encoding are converted from the charset in the Content-Type header, or
+// from Windows-1252, which is what mislabelled feeds are usually written in.
+func decodeFeed(data []byte, contentType string) []byte {
+	switch {
+	case bytes.HasPrefix(data, utf8BOM):
+		data = data[len(utf8BOM):]
+	case bytes.HasPrefix(data, utf16BEBOM), bytes.HasPrefix(data, utf16LEBOM):
+		decoded, err := unicode.UTF16(unicode.BigEndian, unicode.ExpectBOM).NewDecoder().Bytes(data)
+		if err != nil {
+			return data
+		}
+		return declareUTF8(decoded)
+	}
+
+	if match := xmlEncoding.FindSubmatch(data); match != nil && !isUTF8Label(string(match[1])) {
+		// The parser converts documents that declare their encoding itself
+		return data
+	}
+	if utf8.Valid(data) {
+		return data
+	}
+
+	label := "windows-1252"
+	if _, params, err := mime.ParseMediaType(contentType); err == nil && params["charset"] != "" && !isUTF8Label(params["charset"]) {
+		label = params["charset"]
+	}
+	encoding, _ := charset.Lookup(label)
+	if encoding == nil {
+		return data
+	}
+	decoded, err := encoding.NewDecoder().Bytes(data)
+	if err != nil {
+		return data
+	}
+	return declareUTF8(decoded)
+}
+
+// declareUTF8 changes the encoding in the XML declaration of a converted document
+func declareUTF8(data []byte) []byte {
+	match := xmlEncoding.FindSubmatchIndex(data)
+	if match == nil {
+		return data
+	}
+	converted := make([]byte, 0, len(data))
+	converted = append(converted, data[:match[2]]...)
+	converted = append(converted, "UTF-8"...)
+	return append(converted, data[match[3]:]...)
+}
+
+// isUTF8Label reports whether a charset name means UTF-8
+func isUTF8Label(label string) bool {
+	label = strings.ToLower(strings.TrimSpace(label))
+	return label == "utf-8" || label == "utf8"
+}
@@ -0,0 +1,208 @@
+package parser
+
+import (
+	"errors"
+	"math"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/mmcdole/gofeed"
+)
+
+// mediaExtensions are file extensions of audio and video enclosures without a type
+var mediaExtensions = map[string]string{
+	".mp3":  "audio/mpeg",
+	".m4a":  "audio/mp4",
+	".aac":  "audio/aac",
+	".ogg":  "audio/ogg",
+	".oga":  "audio/ogg",
+	".opus": "audio/opus",
+	".wav":  "audio/wav",
+	".mp4":  "video/mp4",
+	".m4v":  "video/mp4",
+	".webm": "video/webm",
+	".mov":  "video/quicktime",
+}
+
+// Episode is an item with an audio or video enclosure, with a user's state
+type Episode struct {
+	StoredItem
+	FeedTitle string
+	Media     Enclosure
+	// Position is how far into the episode the user has listened, in seconds
+	Position float64
+}
+
+// MediaEnclosure returns the first audio or video enclosure of an item
+func (item FeedItem) MediaEnclosure() (Enclosure, bool) {
+	for _, enclosure := range item.Enclosures {
+		if strings.HasPrefix(enclosure.Type, "audio/") || strings.HasPrefix(enclosure.Type, "video/") {
+			return enclosure, true
+		}
+		if enclosure.Type == "" {
+			if mediaType, ok := mediaExtensions[strings.ToLower(path.Ext(urlPath(enclosure.URL)))]; ok {
+				enclosure.Type = mediaType
+				return enclosure, true
+			}
+		}
+	}
+	return Enclosure{}, false
+}
+
+// Episodes returns the episodes of a user's subscriptions, newest first
+func (s *Storage) Episodes(username string) []Episode {
+	titles := make(map[string]string)
+	for _, feed := range s.Subscriptions(username) {
+		titles[feed.URL] = feed.Title
+	}
+
+	positions := s.Positions(username)
+	episodes := make([]Episode, 0)
+	for _, stored := range s.Items(username) {
+		if media, ok := stored.Item.MediaEnclosure(); ok {
+			episodes = append(episodes, Episode{
+				StoredItem: stored,
+				FeedTitle:  titles[stored.FeedURL],
+				Media:      media,
+				Position:   positions[stored.Item.ID],
+			})
+		}
+	}
+
+	sort.SliceStable(episodes, func(i, j int) bool {
+		return episodes[i].Item.PublishedAt.After(episodes[j].Item.PublishedAt)
+	})
+	return episodes
+}
+
+// ErrInvalidPosition is returned for playback positions that aren't a finite,
+// non-negative number of seconds
+var ErrInvalidPosition = errors.New("position must be a finite, non-negative number of seconds")
+
+// SetPosition remembers how far into an episode a user has listened, in seconds.
+// A position of zero forgets it, and positions past the end of an episode of
+// known duration are stored as its duration. It returns the stored position.
+func (s *Storage) SetPosition(username string, id int64, position float64) (float64, error) {
+	if position < 0 || math.IsNaN(position) || math.IsInf(position, 0) {
+		return 0, ErrInvalidPosition
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	user, ok := s.users[username]
+	if !ok {
+		return 0, ErrUserNotFound
+	}
+	item := s.findItem(user, id)
+	if item == nil {
+		return 0, errors.New("episode not found")
+	}
+	if item.Podcast != nil && item.Podcast.Duration > 0 {
+		position = math.Min(position, float64(item.Podcast.Duration))
+	}
+	if position == 0 {
+		delete(user.Positions, id)
+	} else {
+		user.Positions[id] = position
+	}
+	s.saveNeeded = true
+	return position, nil
+}
+
+// SetArchive turns downloading a feed's episodes on or off. keep overrides how
+// many of the newest episodes are kept; 0 uses the configured default.
+func (s *Storage) SetArchive(url string, enabled bool, keep int) error {
+	if keep < 0 {
+		return errors.New("number of episodes to keep cannot be negative")
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	feed, ok := s.feeds[url]
+	if !ok {
+		return errors.New("feed not found")
+	}
+	feed.Archive = enabled
+	feed.ArchiveKeep = keep
+	s.saveNeeded = true
+	s.autoSaveLocked()
+	return nil
+}
+
+// Positions returns how far into each episode a user has listened, in seconds
+func (s *Storage) Positions(username string) map[int64]float64 {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	positions := make(map[int64]float64)
+	if user, ok := s.users[username]; ok {
+		for id, position := range user.Positions {
+			positions[id] = position
+		}
+	}
+	return positions
+}
+
+// findItem returns an item of one of a user's subscriptions, or nil if there
+// is none with the ID. The caller must hold the mutex.
+func (s *Storage) findItem(user *User, id int64) *FeedItem {
+	for url := range user.Subscriptions {
+		if feed, ok := s.feeds[url]; ok {
+			for i := range feed.Items {
+				if feed.Items[i].ID == id {
+					return &feed.Items[i]
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// podcastInfo reads the iTunes extension of an item
+func podcastInfo(item *gofeed.Item) *Podcast {
+	itunes := item.ITunesExt
+	if itunes == nil {
+		return nil
+	}
+
+	podcast := &Podcast{
+		Duration: parseDuration(itunes.Duration),
+		ImageURL: itunes.Image,
+	}
+	podcast.Episode, _ = strconv.Atoi(strings.TrimSpace(itunes.Episode))
+	podcast.Season, _ = strconv.Atoi(strings.TrimSpace(itunes.Season))
+	switch strings.ToLower(strings.TrimSpace(itunes.Explicit)) {
+	case "yes", "true", "explicit":
+		podcast.Explicit = true
+	}
+
+	if *podcast == (Podcast{}) {
+		return nil
+	}
+	return podcast
+}
+
+// parseDuration parses an iTunes duration, given as seconds, MM:SS or HH:MM:SS
+func parseDuration(value string) int {
+	seconds := 0
+	for _, part := range strings.Split(strings.TrimSpace(value), ":") {
+		n, err := strconv.Atoi(part)
+		if err != nil {
+			return 0
+		}
+		seconds = seconds*60 + n
+	}
+	return seconds
+}
+
+// urlPath returns the path of a URL without its query
+func urlPath(rawURL string) string {
+	if i := strings.IndexAny(rawURL, "?#"); i >= 0 {
+		return rawURL[:i]
+	}
+	return rawURL
+}
@@ -0,0 +1,63 @@
+package parser
+
+import (
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestSetPosition(t *testing.T) {
+	storage, _ := newTestStorage(t)
+	if _, err := storage.CreateUser("alice", "secret123"); err != nil {
+		t.Fatal(err)
+	}
+	url := "http://example.com/podcast.xml"
+	if err := storage.Subscribe("alice", &Feed{URL: url, Title: "Podcast"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := storage.MergeItems(url, []FeedItem{
+		{Title: "Timed", GUID: "timed", Podcast: &Podcast{Duration: 600}},
+		{Title: "Untimed", GUID: "untimed"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	feed, err := storage.CachedFeed(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := make(map[string]int64)
+	for _, item := range feed.Items {
+		ids[item.Title] = item.ID
+	}
+
+	for _, position := range []float64{-1, math.NaN(), math.Inf(1), math.Inf(-1)} {
+		if _, err := storage.SetPosition("alice", ids["Timed"], position); !errors.Is(err, ErrInvalidPosition) {
+			t.Errorf("position %v: err = %v, want ErrInvalidPosition", position, err)
+		}
+	}
+
+	for _, test := range []struct {
+		title        string
+		position     float64
+		wantPosition float64
+	}{
+		{"Timed", 120.5, 120.5},
+		{"Timed", 900, 600},
+		{"Untimed", 900, 900},
+	} {
+		got, err := storage.SetPosition("alice", ids[test.title], test.position)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != test.wantPosition {
+			t.Errorf("%s at %v: stored %v, want %v", test.title, test.position, got, test.wantPosition)
+		}
+	}
+
+	if _, err := storage.SetPosition("alice", 999, 10); err == nil {
+		t.Error("a position was stored for an unknown episode")
+	}
+	if err := storage.SaveToFile(); err != nil {
+		t.Errorf("saving after setting positions: %v", err)
+	}
+}
@@ -0,0 +1,385 @@
+package parser
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/charset"
+)
+
+const (
+	// maxArticleSize is the largest page downloaded for article extraction or scraping
+	maxArticleSize = 5 << 20
+	// minArticleLength is the amount of text an extracted article needs to be used
+	minArticleLength = 250
+	// maxExtractionsPerFetch limits how many pages a single refresh of a feed downloads
+	maxExtractionsPerFetch = 10
+	// extractWorkers is how many pages of a feed are downloaded at the same time
+	extractWorkers = 4
+	// extractRetryDelay is how long a page that failed extraction is left alone
+	extractRetryDelay = 6 * time.Hour
+)
+
+// errNoArticle is returned when no article body could be found on a page
+var errNoArticle = errors.New("no article found on the page")
+
+var (
+	// unlikelyCandidates match the class and id of page elements that hold no article text
+	unlikelyCandidates = regexp.MustCompile(`(?i)banner|breadcrumb|combx|comment|community|cookie|disqus|extra|foot|header|legends|menu|modal|nav|pager|popup|promo|related|remark|rss|share|shoutbox|sidebar|skyscraper|social|sponsor|subscribe|widget`)
+	// maybeCandidates rescue elements matching unlikelyCandidates that may still hold the article
+	maybeCandidates = regexp.MustCompile(`(?i)and|article|body|column|content|main|shadow`)
+	positiveHints   = regexp.MustCompile(`(?i)article|body|content|entry|hentry|h-entry|main|page|post|story|text|blog`)
+	negativeHints   = regexp.MustCompile(`(?i)hidden|banner|combx|comment|contact|foot|footer|masthead|meta|outbrain|promo|related|scroll|share|shoutbox|sidebar|skyscraper|sponsor|shopping|tags|tool|widget|nav|menu`)
+)
+
+// SetFullContent turns fetching the full article of a feed's items on or off.
+// It applies from the next time the feed is fetched.
+func (s *Storage) SetFullContent(url string, enabled bool) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	feed, ok := s.feeds[url]
+	if !ok {
+		return errors.New("feed not found")
+	}
+	feed.FullContent = enabled
+	s.saveNeeded = true
+	s.autoSaveLocked()
+	return nil
+}
+
+// reuseArticles gives fetched items the articles extracted for them before, since
+// merging replaces the stored items with the fetched ones. The caller must hold the mutex.
+func reuseArticles(feed *Feed, items []FeedItem) {
+	extracted := make(map[string]string)
+	for _, item := range feed.Items {
+		if item.FullContent {
+			extracted[ItemKey(item)] = item.Content
+		}
+	}
+	for i := range items {
+		if content, ok := extracted[ItemKey(items[i])]; ok {
+			items[i].Content = content
+			items[i].FullContent = true
+		}
+	}
+}
+
+// extractArticles replaces the content of a feed's stored items with the article
+// extracted from their page, in the background so fetching the feed doesn't wait
+// for the pages. Items whose page fails keep the content from the feed and are
+// retried later. Only one extraction per feed runs at a time, and none start
+// once the storage is closing.
+func (s *Storage) extractArticles(url string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.extracting[url] || s.extractCtx.Err() != nil {
+		return
+	}
+	s.extracting[url] = true
+	s.extractions.Add(1)
+	go s.fetchFullContent(url)
+}
+
+// fetchFullContent downloads the articles of a feed's items that don't have one yet
+// and stores them
+func (s *Storage) fetchFullContent(url string) {
+	defer s.extractions.Done()
+	defer func() {
+		s.mutex.Lock()
+		delete(s.extracting, url)
+		s.mutex.Unlock()
+	}()
+
+	type page struct {
+		id      int64
+		link    string
+		content string
+	}
+	pending := make([]*page, 0)
+	s.mutex.RLock()
+	if feed, ok := s.feeds[url]; ok {
+		for _, item := range feed.Items {
+			if len(pending) == maxExtractionsPerFetch {
+				break
+			}
+			failed, ok := s.extractFailures[item.Link]
+			if !item.FullContent && item.Link != "" && (!ok || time.Since(failed) > extractRetryDelay) {
+				pending = append(pending, &page{id: item.ID, link: item.Link})
+			}
+		}
+	}
+	s.mutex.RUnlock()
+
+	var wg sync.WaitGroup
+	queue := make(chan *page)
+	for i := 0; i < extractWorkers && i < len(pending); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for p := range queue {
+				content, err := ExtractArticle(s.extractCtx, p.link)
+				if s.extractCtx.Err() != nil {
+					continue
+				}
+				if err != nil {
+					log.Printf("Error extracting article %s: %v", p.link, err)
+					s.extractFailed(p.link)
+					continue
+				}
+				p.content = content
+			}
+		}()
+	}
+	for _, p := range pending {
+		queue <- p
+	}
+	close(queue)
+	wg.Wait()
+
+	articles := make(map[int64]string, len(pending))
+	for _, p := range pending {
+		if p.content != "" {
+			articles[p.id] = p.content
+		}
+	}
+	if len(articles) == 0 {
+		return
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	feed, ok := s.feeds[url]
+	if !ok || s.extractCtx.Err() != nil {
+		return
+	}
+	for i := range feed.Items {
+		if content, ok := articles[feed.Items[i].ID]; ok {
+			feed.Items[i].Content = content
+			feed.Items[i].FullContent = true
+		}
+	}
+	s.saveNeeded = true
+	s.autoSaveLocked()
+}
+
+// extractFailed remembers that extracting a page failed
+func (s *Storage) extractFailed(link string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	// Forget old failures so the map doesn't grow forever
+	for failedLink, failed := range s.extractFailures {
+		if time.Since(failed) > extractRetryDelay {
+			delete(s.extractFailures, failedLink)
+		}
+	}
+	s.extractFailures[link] = time.Now()
+}
+
+// ExtractArticle downloads a web page and extracts the main article body as HTML.
+// Pages on loopback, private and link-local addresses are refused.
+func ExtractArticle(ctx context.Context, pageURL string) (string, error) {
+	doc, base, _, err := fetchPage(ctx, articleClient, pageURL)
+	if err != nil {
+		return "", err
+	}
+	return extractArticle(doc, base)
+}
+
+// fetchPage downloads an HTML page, decoding its character set. It returns the
+// address the page was found at after redirects and the HTTP status code.
+func fetchPage(ctx context.Context, client *http.Client, pageURL string) (*goquery.Document, *url.URL, int, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", pageURL, nil)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	req.Header.Set("User-Agent", userAgent)
+	req.Header.Set("Accept", "text/html,application/xhtml+xml")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, nil, resp.StatusCode, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	contentType := resp.Header.Get("Content-Type")
+	if contentType != "" && !strings.Contains(contentType, "html") {
+		return nil, nil, resp.StatusCode, fmt.Errorf("not an HTML page: %s", contentType)
+	}
+
+	body, err := charset.NewReader(io.LimitReader(resp.Body, maxArticleSize), contentType)
+	if err != nil {
+		return nil, nil, resp.StatusCode, err
+	}
+	doc, err := goquery.NewDocumentFromReader(body)
+	if err != nil {
+		return nil, nil, resp.StatusCode, err
+	}
+	return doc, resp.Request.URL, resp.StatusCode, nil
+}
+
+// extractArticle finds the element holding the article in a page, in the manner of
+// Readability: paragraphs score points for their parents by their length and commas,
+// adjusted by class names and link density, and the best scoring element wins.
+func extractArticle(doc *goquery.Document, base *url.URL) (string, error) {
+	doc.Find("script, style, noscript, iframe, form, nav, footer, aside, button, input, select, textarea, svg, link, meta").Remove()
+	doc.Find("body *").Each(func(_ int, element *goquery.Selection) {
+		if element.Is("article, main, p, img, picture, figure") {
+			return
+		}
+		hints := element.AttrOr("class", "") + " " + element.AttrOr("id", "")
+		if unlikelyCandidates.MatchString(hints) && !maybeCandidates.MatchString(hints) {
+			element.Remove()
+		}
+	})
+
+	scores := make(map[*html.Node]float64)
+	candidates := make([]*goquery.Selection, 0)
+	addScore := func(element *goquery.Selection, score float64) {
+		if element.Length() == 0 || element.Is("html, body") {
+			return
+		}
+		node := element.Get(0)
+		if _, ok := scores[node]; !ok {
+			scores[node] = initialScore(element)
+			candidates = append(candidates, element)
+		}
+		scores[node] += score
+	}
+
+	doc.Find("p, pre, td, blockquote").Each(func(_ int, paragraph *goquery.Selection) {
+		text := strings.TrimSpace(paragraph.Text())
+		if len(text) < 25 {
+			return
+		}
+		score := 1 + float64(strings.Count(text, ",")) + math.Min(float64(len(text))/100, 3)
+		parent := paragraph.Parent()
+		addScore(parent, score)
+		addScore(parent.Parent(), score/2)
+	})
+
+	var best *goquery.Selection
+	bestScore := 0.0
+	for _, candidate := range candidates {
+		score := scores[candidate.Get(0)] * (1 - linkDensity(candidate))
+		if score > bestScore {
+			best, bestScore = candidate, score
+		}
+	}
+	if best == nil {
+		return "", errNoArticle
+	}
+
+	cleanArticle(best, base)
+	if len(strings.TrimSpace(best.Text())) < minArticleLength {
+		return "", errNoArticle
+	}
+	return best.Html()
+}
+
+// initialScore scores an element by its tag and the hints in its class and id
+func initialScore(element *goquery.Selection) float64 {
+	score := 0.0
+	switch goquery.NodeName(element) {
+	case "article":
+		score += 10
+	case "div", "main", "section":
+		score += 5
+	case "pre", "td", "blockquote":
+		score += 3
+	case "ol", "ul", "dl", "dd", "dt", "li", "form":
+		score -= 3
+	case "h1", "h2", "h3", "h4", "h5", "h6", "th":
+		score -= 5
+	}
+
+	for _, hint := range []string{element.AttrOr("class", ""), element.AttrOr("id", "")} {
+		if hint == "" {
+			continue
+		}
+		if negativeHints.MatchString(hint) {
+			score -= 25
+		}
+		if positiveHints.MatchString(hint) {
+			score += 25
+		}
+	}
+	return score
+}
+
+// linkDensity returns the share of an element's text that is inside links
+func linkDensity(element *goquery.Selection) float64 {
+	textLength := len(strings.TrimSpace(element.Text()))
+	if textLength == 0 {
+		return 0
+	}
+	linkLength := 0
+	element.Find("a").Each(func(_ int, link *goquery.Selection) {
+		linkLength += len(strings.TrimSpace(link.Text()))
+	})
+	return float64(linkLength) / float64(textLength)
+}
+
+// cleanArticle removes leftover clutter from an extracted article, strips
+// presentational attributes and makes links and images absolute
+func cleanArticle(article *goquery.Selection, base *url.URL) {
+	article.Find("div, section, ul, ol, table").Each(func(_ int, element *goquery.Selection) {
+		hints := element.AttrOr("class", "") + " " + element.AttrOr("id", "")
+		if (negativeHints.MatchString(hints) && !positiveHints.MatchString(hints)) ||
+			(linkDensity(element) > 0.5 && element.Find("img").Length() == 0) {
+			element.Remove()
+		}
+	})
+
+	article.Find("*").AddSelection(article).Each(func(_ int, element *goquery.Selection) {
+		node := element.Get(0)
+
+		// Lazy loaded images keep the real address in a data attribute
+		if goquery.NodeName(element) == "img" {
+			if src := element.AttrOr("data-src", ""); src != "" {
+				element.SetAttr("src", src)
+			}
+		}
+
+		kept := node.Attr[:0]
+		for _, attr := range node.Attr {
+			switch attr.Key {
+			case "href", "src":
+				attr.Val = resolveURL(base, attr.Val)
+			case "alt", "title", "colspan", "rowspan":
+			default:
+				continue
+			}
+			kept = append(kept, attr)
+		}
+		node.Attr = kept
+	})
+}
+
+// resolveURL makes a link absolute against the page it was found on
+func resolveURL(base *url.URL, link string) string {
+	if base == nil {
+		return link
+	}
+	ref, err := url.Parse(strings.TrimSpace(link))
+	if err != nil {
+		return link
+	}
+	return base.ResolveReference(ref).String()
+}
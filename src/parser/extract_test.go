@@ -0,0 +1,219 @@
+package parser
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/user/rss/src/netguard"
+)
+
+// loadPage parses an HTML page in testdata as if it was found at pageURL
+func loadPage(t *testing.T, name, pageURL string) (*goquery.Document, *url.URL) {
+	t.Helper()
+	file, err := os.Open(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	doc, err := goquery.NewDocumentFromReader(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	base, err := url.Parse(pageURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return doc, base
+}
+
+// allowLoopbackArticles lets article extraction reach test servers on loopback
+func allowLoopbackArticles(t *testing.T) {
+	t.Helper()
+	guarded := articleClient
+	articleClient = &http.Client{Timeout: 5 * time.Second}
+	t.Cleanup(func() { articleClient = guarded })
+}
+
+func TestExtractArticle(t *testing.T) {
+	doc, base := loadPage(t, "article.html", "http://example.com/posts/tide-pools.html")
+	article, err := extractArticle(doc, base)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{
+		"Tide pools are small worlds",
+		"natural laboratory",
+		`src="http://example.com/images/pool.jpg"`,
+		`href="http://example.com/studies/long-term.html"`,
+		`alt="A tide pool at low tide"`,
+	} {
+		if !strings.Contains(article, want) {
+			t.Errorf("article lacks %q:\n%s", want, article)
+		}
+	}
+	for _, unwanted := range []string{"Coastal Notes", "Archive", "cookies", "newsletter", "Copyright", "tracking", "Share", "class=", "width="} {
+		if strings.Contains(article, unwanted) {
+			t.Errorf("article contains %q:\n%s", unwanted, article)
+		}
+	}
+}
+
+func TestExtractArticleNotFound(t *testing.T) {
+	for _, name := range []string{"links.html", "page.html"} {
+		doc, base := loadPage(t, name, "http://example.com/")
+		if article, err := extractArticle(doc, base); err != errNoArticle {
+			t.Errorf("%s: article = %q, err = %v, want errNoArticle", name, article, err)
+		}
+	}
+}
+
+func TestFullContentInBackground(t *testing.T) {
+	release := make(chan struct{})
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/feed.xml":
+			w.Header().Set("Content-Type", "application/rss+xml")
+			fmt.Fprintf(w, `<?xml version="1.0"?>
+<rss version="2.0"><channel><title>Coastal Notes</title><link>%[1]s/</link>
+<item><title>Why tide pools matter</title><link>%[1]s/posts/article.html</link><guid>tide-pools</guid><description>Teaser</description></item>
+</channel></rss>`, server.URL)
+		case "/posts/article.html":
+			<-release
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			http.ServeFile(w, r, filepath.Join("testdata", "article.html"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+	defer close(release)
+	allowLoopbackArticles(t)
+
+	storage, _ := newTestStorage(t)
+	feedURL := server.URL + "/feed.xml"
+	if err := storage.AddFeed(&Feed{URL: feedURL}); err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.SetFullContent(feedURL, true); err != nil {
+		t.Fatal(err)
+	}
+
+	// The feed is served while the article page is still downloading
+	feed, err := storage.GetFeed(feedURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(feed.Items) != 1 || feed.Items[0].Content != "" || feed.Items[0].FullContent {
+		t.Fatalf("items before extraction = %+v, want no article yet", feed.Items)
+	}
+	release <- struct{}{}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		feed, err = storage.CachedFeed(feedURL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if feed.Items[0].FullContent {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the article was not extracted")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !strings.Contains(feed.Items[0].Content, "natural laboratory") {
+		t.Errorf("content = %q, want the article", feed.Items[0].Content)
+	}
+
+	// Fetching again keeps the article
+	feed, err = storage.GetFeed(feedURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !feed.Items[0].FullContent || !strings.Contains(feed.Items[0].Content, "natural laboratory") {
+		t.Errorf("refetched item = %+v, want the article kept", feed.Items[0])
+	}
+}
+
+func TestExtractArticleRefusesPrivateAddresses(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("page %s was requested", r.URL)
+	}))
+	defer server.Close()
+
+	for _, link := range []string{server.URL + "/posts/article.html", "http://169.254.169.254/latest/meta-data/"} {
+		if _, err := ExtractArticle(context.Background(), link); !errors.Is(err, netguard.ErrNotPublic) {
+			t.Errorf("%s: err = %v, want ErrNotPublic", link, err)
+		}
+	}
+}
+
+func TestCloseStopsExtraction(t *testing.T) {
+	requested := make(chan struct{}, 1)
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/feed.xml":
+			w.Header().Set("Content-Type", "application/rss+xml")
+			fmt.Fprintf(w, `<?xml version="1.0"?>
+<rss version="2.0"><channel><title>Coastal Notes</title>
+<item><title>Why tide pools matter</title><link>%s/posts/article.html</link><guid>tide-pools</guid></item>
+</channel></rss>`, server.URL)
+		case "/posts/article.html":
+			// Hang until the client gives up
+			requested <- struct{}{}
+			<-r.Context().Done()
+		}
+	}))
+	defer server.Close()
+	allowLoopbackArticles(t)
+
+	storage, _ := newTestStorage(t)
+	feedURL := server.URL + "/feed.xml"
+	if err := storage.AddFeed(&Feed{URL: feedURL}); err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.SetFullContent(feedURL, true); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := storage.GetFeed(feedURL); err != nil {
+		t.Fatal(err)
+	}
+	<-requested
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := storage.Close(ctx); err != nil {
+		t.Fatalf("Close didn't stop the extraction: %v", err)
+	}
+	feed, err := storage.CachedFeed(feedURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if feed.Items[0].FullContent {
+		t.Error("an article was stored after Close")
+	}
+
+	// Fetching after Close doesn't start another extraction
+	if _, err := storage.GetFeed(feedURL); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-requested:
+		t.Error("a page was requested after Close")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
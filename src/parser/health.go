@@ -0,0 +1,49 @@
+package parser
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// ErrNotLoaded is returned when saving or creating the first account while stored
+// data could not be loaded, which would overwrite or take over the existing data
+var ErrNotLoaded = errors.New("stored data could not be loaded")
+
+// Loaded returns false if stored feeds, items or accounts could not be loaded.
+// NewStorage loads them before returning, so there is no loading state to report.
+func (s *Storage) Loaded() bool {
+	s.status.Lock()
+	defer s.status.Unlock()
+	return s.loaded
+}
+
+// SaveStatus describes recent attempts to write storage to disk
+type SaveStatus struct {
+	// Failures counts the saves that failed since the last one that succeeded
+	Failures int
+	// LastSuccess is when the data files were last written, or loaded at startup
+	LastSuccess time.Time
+	// Err is the error of the most recent save, or nil if it succeeded
+	Err error
+}
+
+// SaveStatus returns the outcome of recent saves
+func (s *Storage) SaveStatus() SaveStatus {
+	s.status.Lock()
+	defer s.status.Unlock()
+	return SaveStatus{Failures: s.saveFailures, LastSuccess: s.savedAt, Err: s.saveErr}
+}
+
+// CheckWritable verifies that files can be created next to the feeds file
+func (s *Storage) CheckWritable() error {
+	file, err := ioutil.TempFile(filepath.Dir(s.filePath), ".writable-")
+	if err != nil {
+		return err
+	}
+	name := file.Name()
+	file.Close()
+	return os.Remove(name)
+}
@@ -0,0 +1,83 @@
+package parser
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// discoverHub finds the WebSub hub and self links declared at the top of a feed document
+func discoverHub(data []byte) (hub, self string) {
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	decoder.Strict = false
+	decoder.CharsetReader = func(charset string, input io.Reader) (io.Reader, error) {
+		return input, nil
+	}
+
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return hub, self
+		}
+
+		start, ok := token.(xml.StartElement)
+		if !ok {
+			continue
+		}
+
+		switch start.Name.Local {
+		case "item", "entry":
+			// Links inside items belong to the items, not the feed
+			return hub, self
+		case "link":
+			var rel, href string
+			for _, attr := range start.Attr {
+				switch attr.Name.Local {
+				case "rel":
+					rel = attr.Value
+				case "href":
+					href = attr.Value
+				}
+			}
+			if href == "" {
+				continue
+			}
+			if rel == "hub" && hub == "" {
+				hub = href
+			} else if rel == "self" && self == "" {
+				self = href
+			}
+		}
+	}
+}
+
+// discoverHubFromHeader finds WebSub hub and self links in HTTP Link headers
+func discoverHubFromHeader(header http.Header) (hub, self string) {
+	for _, value := range header.Values("Link") {
+		for _, link := range strings.Split(value, ",") {
+			parts := strings.Split(link, ";")
+			target := strings.TrimSpace(parts[0])
+			if !strings.HasPrefix(target, "<") || !strings.HasSuffix(target, ">") {
+				continue
+			}
+			target = strings.Trim(target, "<>")
+
+			for _, param := range parts[1:] {
+				param = strings.TrimSpace(param)
+				if !strings.HasPrefix(param, "rel=") {
+					continue
+				}
+				for _, rel := range strings.Fields(strings.Trim(strings.TrimPrefix(param, "rel="), `"`)) {
+					if rel == "hub" && hub == "" {
+						hub = target
+					} else if rel == "self" && self == "" {
+						self = target
+					}
+				}
+			}
+		}
+	}
+	return hub, self
+}
@@ -0,0 +1,74 @@
+package parser
+
+import (
+	"sort"
+	"strings"
+)
+
+// maxCachedItems is the number of items kept in memory per feed
+const maxCachedItems = 200
+
+// ItemKey returns the value that identifies an item within its feed
+func ItemKey(item FeedItem) string {
+	if item.GUID != "" {
+		return item.GUID
+	}
+	if item.Link != "" {
+		return item.Link
+	}
+	return item.Title + "|" + item.PublishedAt.String()
+}
+
+// AuthorNames returns the names of an item's authors separated by commas,
+// falling back to their email addresses
+func (item FeedItem) AuthorNames() string {
+	names := make([]string, 0, len(item.Authors))
+	for _, author := range item.Authors {
+		if author.Name != "" {
+			names = append(names, author.Name)
+		} else if author.Email != "" {
+			names = append(names, author.Email)
+		}
+	}
+	return strings.Join(names, ", ")
+}
+
+// mergeItems merges incoming items into existing ones, newest first.
+// Incoming items replace existing items with the same key, and items
+// for which keep returns true survive the size limit.
+func mergeItems(existing, incoming []FeedItem, keep func(FeedItem) bool) []FeedItem {
+	merged := make([]FeedItem, 0, len(existing)+len(incoming))
+	seen := make(map[string]bool, len(existing)+len(incoming))
+
+	for _, item := range incoming {
+		key := ItemKey(item)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		merged = append(merged, item)
+	}
+	for _, item := range existing {
+		key := ItemKey(item)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		merged = append(merged, item)
+	}
+
+	sort.SliceStable(merged, func(i, j int) bool {
+		return merged[i].PublishedAt.After(merged[j].PublishedAt)
+	})
+
+	if len(merged) > maxCachedItems {
+		kept := merged[:maxCachedItems]
+		for _, item := range merged[maxCachedItems:] {
+			if keep != nil && keep(item) {
+				kept = append(kept, item)
+			}
+		}
+		merged = kept
+	}
+	return merged
+}
@@ -0,0 +1,70 @@
+package parser
+
+import (
+	"bytes"
+	"encoding/json"
+)
+
+// jsonFeedItem holds the fields of a JSON Feed item that gofeed doesn't carry over
+type jsonFeedItem struct {
+	ExternalURL string
+	// Sizes are the size_in_bytes of the attachments, which gofeed replaces with their duration
+	Sizes []int64
+	// Duration is the longest duration_in_seconds of the item's attachments
+	Duration int
+}
+
+// normalizeJSONFeed rewrites a JSON Feed so gofeed can parse it, turning numeric
+// ids into strings as many publishers use them, and returns the fields of its items
+// that gofeed drops. Documents that aren't a JSON object are returned unchanged.
+func normalizeJSONFeed(data []byte) ([]byte, []jsonFeedItem) {
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) == 0 || trimmed[0] != '{' {
+		return data, nil
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(trimmed))
+	decoder.UseNumber()
+	var document map[string]interface{}
+	if err := decoder.Decode(&document); err != nil {
+		return data, nil
+	}
+	items, _ := document["items"].([]interface{})
+
+	extras := make([]jsonFeedItem, len(items))
+	changed := false
+	for i, entry := range items {
+		item, ok := entry.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if id, ok := item["id"].(json.Number); ok {
+			item["id"] = id.String()
+			changed = true
+		}
+		extras[i].ExternalURL, _ = item["external_url"].(string)
+
+		attachments, _ := item["attachments"].([]interface{})
+		extras[i].Sizes = make([]int64, len(attachments))
+		for j, attachment := range attachments {
+			fields, _ := attachment.(map[string]interface{})
+			if size, ok := fields["size_in_bytes"].(json.Number); ok {
+				extras[i].Sizes[j], _ = size.Int64()
+			}
+			if duration, ok := fields["duration_in_seconds"].(json.Number); ok {
+				if seconds, err := duration.Float64(); err == nil && int(seconds) > extras[i].Duration {
+					extras[i].Duration = int(seconds)
+				}
+			}
+		}
+	}
+
+	if !changed {
+		return data, extras
+	}
+	normalized, err := json.Marshal(document)
+	if err != nil {
+		return data, extras
+	}
+	return normalized, extras
+}
@@ -0,0 +1,234 @@
+package parser
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mmcdole/gofeed"
+)
+
+// maxJSONSize is the largest JSON API response read
+const maxJSONSize = 10 << 20
+
+// JSONSource describes how to turn the response of a JSON API into feed items.
+// Paths are dot-separated keys and array indexes, e.g. "data.releases" or
+// "author.0.name". Field paths are evaluated within each item; empty ones fall
+// back to commonly used keys.
+type JSONSource struct {
+	// Name is the title of the feed, as APIs don't have one
+	Name string `json:"name,omitempty"`
+	// Items is the path of the array of items; empty means the response is the array
+	Items   string `json:"items,omitempty"`
+	Title   string `json:"title,omitempty"`
+	Link    string `json:"link,omitempty"`
+	Date    string `json:"date,omitempty"`
+	ID      string `json:"id,omitempty"`
+	Content string `json:"content,omitempty"`
+}
+
+// defaultJSONFields are the keys tried for fields without a path, in order
+var defaultJSONFields = map[string][]string{
+	"title":   {"title", "name", "summary"},
+	"link":    {"url", "link", "html_url", "href", "permalink"},
+	"date":    {"published_at", "date", "created_at", "updated_at", "timestamp", "time"},
+	"id":      {"id", "guid", "uuid", "key"},
+	"content": {"content", "body", "description", "summary", "text", "message"},
+}
+
+// FetchJSONFeed requests a JSON API and turns its items into a feed
+func FetchJSONFeed(apiURL string, source JSONSource) (*Feed, error) {
+	if apiURL == "" {
+		return nil, errors.New("URL cannot be empty")
+	}
+
+	req, err := http.NewRequest("GET", apiURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", userAgent)
+	req.Header.Set("Accept", "application/json")
+
+	started := time.Now()
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		observeFetch(apiURL, started, 0, false, err)
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		err := gofeed.HTTPError{
+			StatusCode: resp.StatusCode,
+			Status:     resp.Status,
+		}
+		observeFetch(apiURL, started, resp.StatusCode, false, err)
+		return nil, err
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxJSONSize))
+	if err != nil {
+		observeFetch(apiURL, started, resp.StatusCode, false, err)
+		return nil, err
+	}
+
+	feed, err := ParseJSONSource(apiURL, data, source)
+	observeFetch(apiURL, started, resp.StatusCode, err != nil, err)
+	return feed, err
+}
+
+// ParseJSONSource turns a JSON API response retrieved from the given URL into a feed
+func ParseJSONSource(apiURL string, data []byte, source JSONSource) (*Feed, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var document interface{}
+	if err := decoder.Decode(&document); err != nil {
+		return nil, fmt.Errorf("invalid JSON: %v", err)
+	}
+
+	list, ok := lookupJSON(document, source.Items)
+	if !ok {
+		return nil, fmt.Errorf("items path %q not found", source.Items)
+	}
+	entries, ok := list.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("items path %q is not an array", source.Items)
+	}
+
+	base, err := url.Parse(apiURL)
+	if err != nil {
+		return nil, err
+	}
+	feed := &Feed{
+		URL:        apiURL,
+		Title:      source.Name,
+		Link:       apiURL,
+		JSONSource: &source,
+		UpdatedAt:  time.Now(),
+		Items:      make([]FeedItem, 0, len(entries)),
+	}
+	if feed.Title == "" {
+		feed.Title = base.Host + base.Path
+	}
+
+	for _, entry := range entries {
+		field := func(name, path string) string {
+			return jsonField(entry, name, path)
+		}
+
+		item := FeedItem{
+			Title:       collapseSpace(field("title", source.Title)),
+			Link:        strings.TrimSpace(field("link", source.Link)),
+			GUID:        field("id", source.ID),
+			Description: field("content", source.Content),
+			PublishedAt: parseJSONDate(entry, source.Date),
+		}
+		resolveItem(&item, base)
+		if item.Title == "" && item.Link == "" && item.GUID == "" {
+			continue
+		}
+		feed.Items = append(feed.Items, item)
+	}
+
+	if len(feed.Items) == 0 && len(entries) > 0 {
+		return nil, errors.New("no items had a title, link or id")
+	}
+	return feed, nil
+}
+
+// jsonField reads a field of an item as a string, trying the default keys for the
+// field when no path is given
+func jsonField(entry interface{}, name, path string) string {
+	if path != "" {
+		value, _ := lookupJSON(entry, path)
+		return jsonString(value)
+	}
+	for _, key := range defaultJSONFields[name] {
+		if value, ok := lookupJSON(entry, key); ok && value != nil {
+			return jsonString(value)
+		}
+	}
+	return ""
+}
+
+// parseJSONDate reads the date of an item, given as text or as a Unix timestamp
+// in seconds or milliseconds
+func parseJSONDate(entry interface{}, path string) time.Time {
+	var value interface{}
+	if path != "" {
+		value, _ = lookupJSON(entry, path)
+	} else {
+		for _, key := range defaultJSONFields["date"] {
+			if found, ok := lookupJSON(entry, key); ok && found != nil {
+				value = found
+				break
+			}
+		}
+	}
+
+	text := jsonString(value)
+	if number, err := strconv.ParseInt(text, 10, 64); err == nil {
+		if number > 1e12 {
+			return time.UnixMilli(number).UTC()
+		}
+		return time.Unix(number, 0).UTC()
+	}
+	if parsed, err := time.Parse(time.RFC3339Nano, text); err == nil {
+		return parsed
+	}
+	return parseDate(text)
+}
+
+// lookupJSON follows a dot-separated path of keys and array indexes
+func lookupJSON(value interface{}, path string) (interface{}, bool) {
+	if path = strings.Trim(path, ". "); path == "" {
+		return value, true
+	}
+
+	for _, key := range strings.Split(path, ".") {
+		switch current := value.(type) {
+		case map[string]interface{}:
+			next, ok := current[key]
+			if !ok {
+				return nil, false
+			}
+			value = next
+		case []interface{}:
+			index, err := strconv.Atoi(key)
+			if err != nil || index < 0 || index >= len(current) {
+				return nil, false
+			}
+			value = current[index]
+		default:
+			return nil, false
+		}
+	}
+	return value, true
+}
+
+// jsonString converts a JSON value to text. Objects and arrays are encoded as JSON.
+func jsonString(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	case bool:
+		return strconv.FormatBool(v)
+	default:
+		encoded, err := json.Marshal(v)
+		if err != nil {
+			return ""
+		}
+		return string(encoded)
+	}
+}
@@ -0,0 +1,74 @@
+package parser
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"io"
+	"strings"
+
+	"github.com/mmcdole/gofeed"
+)
+
+// discoverIcon finds the small icon of a feed, which gofeed drops when a feed
+// also has a larger logo. Atom feeds declare it in <icon>, JSON feeds in "favicon".
+func discoverIcon(data []byte, feedType string) string {
+	switch feedType {
+	case "json":
+		var jsonFeed struct {
+			Favicon string `json:"favicon"`
+		}
+		if json.Unmarshal(data, &jsonFeed) == nil {
+			return jsonFeed.Favicon
+		}
+	case "atom":
+		decoder := xml.NewDecoder(bytes.NewReader(data))
+		decoder.Strict = false
+		decoder.CharsetReader = func(charset string, input io.Reader) (io.Reader, error) {
+			return input, nil
+		}
+
+		depth := 0
+		for {
+			token, err := decoder.Token()
+			if err != nil {
+				return ""
+			}
+			switch token := token.(type) {
+			case xml.StartElement:
+				depth++
+				// Only the feed's own icon, not one of an entry's source
+				if depth == 2 && token.Name.Local == "icon" {
+					var icon string
+					if decoder.DecodeElement(&icon, &token) == nil {
+						return icon
+					}
+					return ""
+				}
+				if token.Name.Local == "entry" {
+					return ""
+				}
+			case xml.EndElement:
+				depth--
+			}
+		}
+	}
+	return ""
+}
+
+// mediaImage finds an item image in the Media RSS extension, which many feeds
+// use instead of <image>
+func mediaImage(item *gofeed.Item) string {
+	media := item.Extensions["media"]
+	for _, thumbnail := range media["thumbnail"] {
+		if url := thumbnail.Attrs["url"]; url != "" {
+			return url
+		}
+	}
+	for _, content := range media["content"] {
+		if content.Attrs["medium"] == "image" || strings.HasPrefix(content.Attrs["type"], "image/") {
+			return content.Attrs["url"]
+		}
+	}
+	return ""
+}
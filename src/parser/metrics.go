@@ -0,0 +1,76 @@
+package parser
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/user/rss/src/metrics"
+)
+
+// Feed and storage metrics exposed at /metrics
+var (
+	fetchesTotal = metrics.Default.NewCounterVec("rss_feed_fetches_total",
+		"Feed fetches by feed URL and HTTP status code (\"error\" when no response was received).", "feed", "status")
+	fetchErrorsTotal = metrics.Default.NewCounterVec("rss_feed_fetch_errors_total",
+		"Failed feed fetches by feed URL and HTTP status code, \"error\" for network and \"parse\" for parse errors.", "feed", "status")
+	fetchDuration = metrics.Default.NewHistogramVec("rss_feed_fetch_duration_seconds",
+		"Time taken to fetch and parse a feed.", metrics.DefaultBuckets, "feed")
+	itemsIngestedTotal = metrics.Default.NewCounterVec("rss_items_ingested_total",
+		"New items added to the cache by feed URL.", "feed")
+	saveDuration = metrics.Default.NewHistogramVec("rss_storage_save_duration_seconds",
+		"Time taken to write feeds, items and accounts to disk.", metrics.DefaultBuckets)
+	saveFailuresTotal = metrics.Default.NewCounterVec("rss_storage_save_failures_total",
+		"Failed attempts to write storage to disk.")
+)
+
+// observeFetch records the outcome of fetching a feed
+func observeFetch(url string, started time.Time, statusCode int, parseFailed bool, err error) {
+	status := "error"
+	if statusCode != 0 {
+		status = strconv.Itoa(statusCode)
+	}
+
+	fetchesTotal.Inc(url, status)
+	fetchDuration.Observe(time.Since(started).Seconds(), url)
+	if err != nil {
+		if parseFailed {
+			status = "parse"
+		}
+		fetchErrorsTotal.Inc(url, status)
+	}
+}
+
+// RegisterMetrics exposes subscription counts of the storage in a registry.
+// Only one storage can be registered in a registry.
+func (s *Storage) RegisterMetrics(registry *metrics.Registry) error {
+	gauges := []struct {
+		name, help string
+		value      func() float64
+	}{
+		{"rss_feeds", "Distinct feeds being fetched.", func() float64 {
+			s.mutex.RLock()
+			defer s.mutex.RUnlock()
+			return float64(len(s.feeds))
+		}},
+		{"rss_subscriptions", "Subscriptions across all accounts.", func() float64 {
+			s.mutex.RLock()
+			defer s.mutex.RUnlock()
+			count := 0
+			for _, user := range s.users {
+				count += len(user.Subscriptions)
+			}
+			return float64(count)
+		}},
+		{"rss_users", "Accounts.", func() float64 {
+			s.mutex.RLock()
+			defer s.mutex.RUnlock()
+			return float64(len(s.users))
+		}},
+	}
+	for _, gauge := range gauges {
+		if _, err := registry.NewGaugeFunc(gauge.name, gauge.help, gauge.value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
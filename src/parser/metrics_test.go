@@ -0,0 +1,36 @@
+package parser
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/user/rss/src/metrics"
+)
+
+func TestRegisterMetricsTwice(t *testing.T) {
+	registry := metrics.NewRegistry()
+	first, _ := newTestStorage(t)
+	second, _ := newTestStorage(t)
+	if _, err := first.CreateUser("alice", "secret123"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := first.RegisterMetrics(registry); err != nil {
+		t.Fatal(err)
+	}
+	if err := first.RegisterMetrics(registry); !errors.Is(err, metrics.ErrDuplicate) {
+		t.Errorf("registering again: err = %v, want ErrDuplicate", err)
+	}
+	if err := second.RegisterMetrics(registry); !errors.Is(err, metrics.ErrDuplicate) {
+		t.Errorf("registering another storage: err = %v, want ErrDuplicate", err)
+	}
+
+	var out strings.Builder
+	if _, err := registry.WriteTo(&out); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "rss_users 1\n") {
+		t.Errorf("metrics don't report the first storage:\n%s", out.String())
+	}
+}
@@ -1,29 +1,117 @@
 package parser
 
 import (
+	"bytes"
 	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
 	"time"
 
 	"github.com/mmcdole/gofeed"
+	"github.com/user/rss/src/netguard"
 )
 
 // Feed represents an RSS feed
 type Feed struct {
+	ID          int64
 	URL         string
 	Title       string
 	Description string
-	UpdatedAt   time.Time
-	Items       []FeedItem
+	// Link is the website the feed belongs to
+	Link     string
+	Language string
+	ImageURL string
+	IconURL  string
+	Folder   string
+	HubURL   string
+	SelfURL  string
+	// Archive downloads the feed's episodes; ArchiveKeep overrides how many are kept
+	Archive     bool
+	ArchiveKeep int
+	// FullContent replaces the content of items with the article on their page
+	FullContent bool
+	// Scrape is set for web pages without a feed, whose items are found with CSS selectors
+	Scrape *ScrapeConfig
+	// JSONSource is set for JSON APIs, whose items are read through field mappings
+	JSONSource *JSONSource
+	UpdatedAt  time.Time
+	Items      []FeedItem
 }
 
 // FeedItem represents a single item in an RSS feed
 type FeedItem struct {
+	ID          int64
 	Title       string
 	Description string
 	Content     string
 	Link        string
 	PublishedAt time.Time
 	GUID        string
+	UpdatedAt   time.Time
+	Authors     []Person    `json:",omitempty"`
+	Categories  []string    `json:",omitempty"`
+	Enclosures  []Enclosure `json:",omitempty"`
+	ImageURL    string      `json:",omitempty"`
+	Podcast     *Podcast    `json:",omitempty"`
+	// FullContent is set when Content holds the article extracted from the item's page
+	FullContent bool `json:",omitempty"`
+}
+
+// Podcast holds the iTunes podcast details of an episode
+type Podcast struct {
+	// Duration is the length of the episode in seconds
+	Duration int    `json:",omitempty"`
+	Episode  int    `json:",omitempty"`
+	Season   int    `json:",omitempty"`
+	ImageURL string `json:",omitempty"`
+	Explicit bool   `json:",omitempty"`
+}
+
+// Person is an author of a feed item
+type Person struct {
+	Name  string `json:",omitempty"`
+	Email string `json:",omitempty"`
+}
+
+// Enclosure is a media file attached to a feed item
+type Enclosure struct {
+	URL    string
+	Type   string `json:",omitempty"`
+	Length int64  `json:",omitempty"`
+}
+
+// FetchConfig holds settings for fetching feeds
+type FetchConfig struct {
+	Timeout   time.Duration
+	UserAgent string
+}
+
+// DefaultFetchConfig returns a default fetch configuration
+func DefaultFetchConfig() FetchConfig {
+	return FetchConfig{
+		Timeout:   30 * time.Second,
+		UserAgent: "Gofeed/1.0",
+	}
+}
+
+// userAgent is sent with every feed request
+var userAgent = DefaultFetchConfig().UserAgent
+
+// httpClient is the client used to fetch feeds
+var httpClient = &http.Client{Timeout: DefaultFetchConfig().Timeout}
+
+// articleClient fetches the pages items link to. Feeds choose these addresses, so
+// it only connects to public ones.
+var articleClient = netguard.Client(DefaultFetchConfig().Timeout)
+
+// ConfigureFetch changes the settings used for all later feed requests.
+// It must be called before fetching starts.
+func ConfigureFetch(config FetchConfig) {
+	userAgent = config.UserAgent
+	httpClient = &http.Client{Timeout: config.Timeout}
+	articleClient = netguard.Client(config.Timeout)
 }
 
 // FetchFeed fetches and parses an RSS feed from the given URL
@@ -32,27 +120,120 @@ func FetchFeed(url string) (*Feed, error) {
 		return nil, errors.New("URL cannot be empty")
 	}
 
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", userAgent)
+
+	started := time.Now()
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		observeFetch(url, started, 0, false, err)
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		err := gofeed.HTTPError{
+			StatusCode: resp.StatusCode,
+			Status:     resp.Status,
+		}
+		observeFetch(url, started, resp.StatusCode, false, err)
+		return nil, err
+	}
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		observeFetch(url, started, resp.StatusCode, false, err)
+		return nil, err
+	}
+
+	result, err := parseFeed(url, data, resp.Header.Get("Content-Type"))
+	observeFetch(url, started, resp.StatusCode, err != nil, err)
+	if err != nil {
+		return nil, err
+	}
+
+	// WebSub hubs may be advertised in the HTTP headers as well as the document
+	if hub, self := discoverHubFromHeader(resp.Header); hub != "" {
+		result.HubURL = hub
+		if self != "" {
+			result.SelfURL = self
+		}
+	}
+
+	return result, nil
+}
+
+// ParseFeed parses a feed document that was retrieved from the given URL
+func ParseFeed(url string, data []byte) (*Feed, error) {
+	return parseFeed(url, data, "")
+}
+
+// parseFeed parses a feed document, using the Content-Type it was served with to
+// decode documents that don't declare their encoding
+func parseFeed(feedURL string, data []byte, contentType string) (*Feed, error) {
+	data = decodeFeed(data, contentType)
+	data, jsonItems := normalizeJSONFeed(data)
+
+	// Relative links are resolved against the xml:base of the feed and its items,
+	// or the address the feed was fetched from
+	base, err := url.Parse(feedURL)
+	if err != nil {
+		base = nil
+	}
+	var itemBases []*url.URL
+	if jsonItems == nil {
+		base, itemBases = xmlBases(data, base)
+		data = stripXMLBase(data)
+	}
+
 	fp := gofeed.NewParser()
-	feed, err := fp.ParseURL(url)
+	feed, err := fp.Parse(bytes.NewReader(data))
 	if err != nil {
 		return nil, err
 	}
+	if len(itemBases) != len(feed.Items) {
+		itemBases = nil
+	}
+
+	hub, self := discoverHub(data)
 
 	result := &Feed{
-		URL:         url,
+		URL:         feedURL,
 		Title:       feed.Title,
 		Description: feed.Description,
+		Link:        feed.Link,
+		Language:    feed.Language,
+		IconURL:     discoverIcon(data, feed.FeedType),
+		HubURL:      hub,
+		SelfURL:     self,
 		UpdatedAt:   time.Now(),
 		Items:       make([]FeedItem, 0, len(feed.Items)),
 	}
+	if feed.Image != nil {
+		result.ImageURL = feed.Image.URL
+	} else if feed.ITunesExt != nil {
+		result.ImageURL = feed.ITunesExt.Image
+	}
+	for _, link := range []*string{&result.Link, &result.ImageURL, &result.IconURL} {
+		if *link != "" {
+			*link = resolveURL(base, *link)
+		}
+	}
 
-	for _, item := range feed.Items {
+	for i, item := range feed.Items {
 		feedItem := FeedItem{
 			Title:       item.Title,
 			Description: item.Description,
 			Content:     item.Content,
 			Link:        item.Link,
 			GUID:        item.GUID,
+			Categories:  item.Categories,
+		}
+		if i < len(jsonItems) && feedItem.Link == "" {
+			feedItem.Link = jsonItems[i].ExternalURL
 		}
 
 		if item.PublishedParsed != nil {
@@ -60,6 +241,54 @@ func FetchFeed(url string) (*Feed, error) {
 		} else if item.UpdatedParsed != nil {
 			feedItem.PublishedAt = *item.UpdatedParsed
 		}
+		if item.UpdatedParsed != nil {
+			feedItem.UpdatedAt = *item.UpdatedParsed
+		}
+		if item.Image != nil {
+			feedItem.ImageURL = item.Image.URL
+		} else {
+			feedItem.ImageURL = mediaImage(item)
+		}
+
+		feedItem.Podcast = podcastInfo(item)
+		if feedItem.Podcast == nil && i < len(jsonItems) && jsonItems[i].Duration > 0 {
+			feedItem.Podcast = &Podcast{Duration: jsonItems[i].Duration}
+		}
+
+		authors := item.Authors
+		if len(authors) == 0 && item.Author != nil {
+			authors = []*gofeed.Person{item.Author}
+		}
+		// Atom entries and JSON Feed items inherit the authors of the feed
+		if len(authors) == 0 && (feed.FeedType == "atom" || feed.FeedType == "json") {
+			authors = feed.Authors
+		}
+		for _, author := range authors {
+			if author != nil && (author.Name != "" || author.Email != "") {
+				feedItem.Authors = append(feedItem.Authors, Person{Name: author.Name, Email: author.Email})
+			}
+		}
+
+		for j, enclosure := range item.Enclosures {
+			if enclosure == nil || enclosure.URL == "" {
+				continue
+			}
+			length, _ := strconv.ParseInt(enclosure.Length, 10, 64)
+			if i < len(jsonItems) && j < len(jsonItems[i].Sizes) {
+				length = jsonItems[i].Sizes[j]
+			}
+			feedItem.Enclosures = append(feedItem.Enclosures, Enclosure{
+				URL:    enclosure.URL,
+				Type:   enclosure.Type,
+				Length: length,
+			})
+		}
+
+		itemBase := base
+		if itemBases != nil {
+			itemBase = itemBases[i]
+		}
+		resolveItem(&feedItem, itemBase)
 
 		result.Items = append(result.Items, feedItem)
 	}
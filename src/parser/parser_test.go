@@ -0,0 +1,448 @@
+package parser
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// fixtureTypes are the Content-Type headers fixtures are served with, by file name.
+// Others are served as application/xml.
+var fixtureTypes = map[string]string{
+	"feed.json":          "application/feed+json",
+	"bom.json":           "application/json; charset=utf-8",
+	"header-charset.xml": "text/xml; charset=windows-1251",
+	"mislabelled.xml":    "application/rss+xml; charset=utf-8",
+	"rss10.rdf":          "application/rdf+xml",
+}
+
+// fixtureServer serves the feeds in testdata
+func fixtureServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := filepath.Base(r.URL.Path)
+		data, err := os.ReadFile(filepath.Join("testdata", name))
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		contentType, ok := fixtureTypes[name]
+		if !ok {
+			contentType = "application/xml"
+		}
+		w.Header().Set("Content-Type", contentType)
+		w.Write(data)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// date parses the RFC 3339 time of an expected item
+func date(value string) time.Time {
+	parsed, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		panic(err)
+	}
+	return parsed.UTC()
+}
+
+func TestFetchFeed(t *testing.T) {
+	server := fixtureServer(t)
+
+	tests := []struct {
+		file  string
+		feed  Feed
+		items []FeedItem
+	}{
+		{
+			file: "rss091.xml",
+			feed: Feed{
+				Title:       "Netscape Classic",
+				Description: "An RSS 0.91 channel & its items",
+				Link:        "http://classic.example.com/",
+				Language:    "en-us",
+				ImageURL:    "http://classic.example.com/logo.gif",
+			},
+			items: []FeedItem{
+				{
+					Title:       "First & foremost",
+					Description: "The first item has <b>escaped</b> markup",
+					Link:        "http://classic.example.com/first.html",
+				},
+				{Title: "Second", Link: "http://classic.example.com/second.html"},
+			},
+		},
+		{
+			file: "rss092.xml",
+			feed: Feed{
+				Title:       "Radio 0.92",
+				Description: "Items without titles",
+				Link:        "http://radio.example.com/",
+			},
+			items: []FeedItem{
+				{
+					Description: "Only a description, as 0.92 allows",
+					Categories:  []string{"Music"},
+					Enclosures: []Enclosure{
+						{URL: "http://radio.example.com/show.mp3", Type: "audio/mpeg", Length: 12216320},
+					},
+				},
+			},
+		},
+		{
+			file: "rss10.rdf",
+			feed: Feed{
+				Title:       "RDF Site Summary",
+				Description: "An RSS 1.0 channel",
+				Link:        "http://rdf.example.org/",
+				Language:    "de",
+			},
+			items: []FeedItem{
+				{
+					Title:       "Dublin Core dates",
+					Description: "Summary of A",
+					Content:     "<p>Full <em>content</em> of A</p>",
+					Link:        "http://rdf.example.org/a",
+					PublishedAt: date("2024-03-05T07:30:00Z"),
+					Authors:     []Person{{Name: "Erika Mustermann"}},
+					Categories:  []string{"Semantics"},
+				},
+				{Title: "No date at all", Link: "http://rdf.example.org/b"},
+			},
+		},
+		{
+			file: "rss20.xml",
+			feed: Feed{
+				Title:       "News & Views",
+				Description: "All the <b>news</b>",
+				Link:        "https://news.example.com/",
+				Language:    "en",
+				ImageURL:    "https://news.example.com/cover.jpg",
+				HubURL:      "https://hub.example.com/",
+				SelfURL:     "https://news.example.com/feed.xml",
+			},
+			items: []FeedItem{
+				{
+					Title:       "Release <1.0> is out",
+					Description: `<p>Teaser with <a href="https://news.example.com/x">a link</a></p>`,
+					Content:     "<p>The whole story]]>, with a CDATA end inside</p>",
+					Link:        "https://news.example.com/release",
+					PublishedAt: date("2024-01-02T15:04:05Z"),
+					GUID:        "tag:news.example.com,2024:1",
+					Authors:     []Person{{Name: "Jane Doe"}},
+					Categories:  []string{"Releases", "Go"},
+					ImageURL:    "https://news.example.com/thumb.jpg",
+				},
+				{
+					Title:       "Episode 7",
+					Link:        "https://news.example.com/ep7",
+					PublishedAt: date("2024-01-03T10:00:00Z"),
+					GUID:        "https://news.example.com/ep7",
+					Authors:     []Person{{Name: "The Editor", Email: "editor@example.com"}},
+					Enclosures: []Enclosure{
+						{URL: "https://news.example.com/ep7.mp3", Type: "audio/mpeg", Length: 1024},
+					},
+					Podcast: &Podcast{Duration: 3723, Episode: 7, Season: 2, Explicit: true},
+				},
+				{Title: "Undated", Link: "https://news.example.com/undated"},
+			},
+		},
+		{
+			file: "relative.xml",
+			feed: Feed{
+				Title:       "Relative",
+				Description: "Links relative to the feed",
+				Link:        server.URL + "/",
+			},
+			items: []FeedItem{
+				{
+					Title:       "Root relative",
+					Description: `<p><img src="` + server.URL + `/img/1.png" alt="one"> <a href="` + server.URL + `/posts/1#top">top</a> <a href="mailto:me@example.com">mail</a></p>`,
+					Link:        server.URL + "/posts/1",
+					PublishedAt: date("2024-01-01T00:00:00Z"),
+					ImageURL:    server.URL + "/img/1.png",
+				},
+				{
+					Title: "Path relative",
+					Description: `<img src="` + server.URL + `/posts/a.png" srcset="` + server.URL + `/posts/a.png 1x, ` + server.URL + `/b.png 2x, https://x.example/c.png 3x">` +
+						`<a href=https://abs.example/ >Absolute &amp; untouched</a>`,
+					Link:     server.URL + "/posts/2.html",
+					ImageURL: server.URL + "/posts/a.png",
+				},
+				{
+					Title: "Protocol relative",
+					Link:  "http://cdn.example.net/3",
+					Enclosures: []Enclosure{
+						{URL: "http://cdn.example.net/audio/3.mp3", Type: "audio/mpeg", Length: 3},
+					},
+				},
+			},
+		},
+		{
+			file: "rss-base.xml",
+			feed: Feed{
+				Title:       "RSS with xml:base",
+				Description: "Bases on the channel and an item",
+				Link:        "http://base.example.com/blog/",
+			},
+			items: []FeedItem{
+				{
+					Title:   "Channel base",
+					Content: `<p><a href="http://base.example.com/blog/post-1#more">More</a></p>`,
+					Link:    "http://base.example.com/blog/post-1",
+				},
+				{Title: "Item base", Link: "http://base.example.com/other/post-2"},
+				{
+					Title:       "No link",
+					Description: `<img src="http://base.example.com/blog/pic.png">`,
+					ImageURL:    "http://base.example.com/blog/pic.png",
+				},
+			},
+		},
+		{
+			file: "atom-base.xml",
+			feed: Feed{
+				Title: "Atom with xml:base",
+				Link:  "http://atom-base.example.org/root/",
+			},
+			items: []FeedItem{
+				{
+					Title:       "Entry base",
+					Content:     `<img src="http://atom-base.example.org/root/entries/img.png">`,
+					Link:        "http://atom-base.example.org/root/entries/1.html",
+					GUID:        "tag:atom-base.example.org,2024:1",
+					PublishedAt: date("2024-07-01T00:00:00Z"),
+					UpdatedAt:   date("2024-07-01T00:00:00Z"),
+				},
+				{
+					Title:       "Feed base",
+					Description: `<a href="http://atom-base.example.org/rel">relative</a>`,
+					Link:        "http://atom-base.example.org/abs",
+					GUID:        "tag:atom-base.example.org,2024:2",
+					PublishedAt: date("2024-06-01T00:00:00Z"),
+					UpdatedAt:   date("2024-06-01T00:00:00Z"),
+				},
+			},
+		},
+		{
+			file: "latin1.xml",
+			feed: Feed{
+				Title:       "Café des élèves",
+				Description: "Déclaration ISO-8859-1",
+				Link:        "http://latin.example.fr/",
+			},
+			items: []FeedItem{
+				{Title: "Résumé naïve", Link: "http://latin.example.fr/resume", PublishedAt: date("2024-01-05T11:00:00Z")},
+			},
+		},
+		{
+			file: "mislabelled.xml",
+			feed: Feed{
+				Title:       "Mislabelled",
+				Description: "Claims UTF-8",
+				Link:        "http://bad.example.com/",
+			},
+			items: []FeedItem{
+				{Title: "It’s a café", Description: "Prices in €", Link: "http://bad.example.com/cafe"},
+			},
+		},
+		{
+			file: "header-charset.xml",
+			feed: Feed{
+				Title:       "Header charset",
+				Description: "windows-1251",
+				Link:        "http://header.example.com/",
+			},
+			items: []FeedItem{
+				{Title: "Привет", Link: "http://header.example.com/1"},
+			},
+		},
+		{
+			file: "utf16.xml",
+			feed: Feed{
+				Title:       "UTF-16 Ünïcode",
+				Description: "Little endian with a byte order mark",
+				Link:        "http://utf16.example.com/",
+			},
+			items: []FeedItem{
+				{Title: "日本語のタイトル", Link: "http://utf16.example.com/ja", PublishedAt: date("2024-01-06T00:00:00Z")},
+			},
+		},
+		{
+			file: "bom.xml",
+			feed: Feed{
+				Title: "Byte Order Mark",
+				Link:  "https://bom.example.com/",
+			},
+			items: []FeedItem{
+				{
+					Title:       "Zürich",
+					Link:        "https://bom.example.com/zurich",
+					GUID:        "urn:uuid:bom-1",
+					PublishedAt: date("2024-02-01T00:00:00Z"),
+					UpdatedAt:   date("2024-02-01T00:00:00Z"),
+				},
+			},
+		},
+		{
+			file: "atom.xml",
+			feed: Feed{
+				Title:       "Atom Example",
+				Description: "Everything <i>Atom</i>",
+				Link:        "https://atom.example.org/",
+				Language:    "en",
+				ImageURL:    "https://atom.example.org/logo.png",
+				IconURL:     "https://atom.example.org/icon.png",
+				SelfURL:     "https://atom.example.org/feed.atom",
+			},
+			items: []FeedItem{
+				{
+					Title:       "Atom & XML",
+					Description: "Plain summary",
+					Content:     "<p>This is <strong>XHTML</strong> content.</p>",
+					Link:        "https://atom.example.org/2024/04/atom",
+					PublishedAt: date("2024-04-09T14:00:00Z"),
+					UpdatedAt:   date("2024-04-10T18:30:02Z"),
+					GUID:        "urn:uuid:1225c695-cfb8-4ebb-aaaa-80da344efa6a",
+					Authors:     []Person{{Name: "John Doe", Email: "johndoe@example.com"}, {Name: "Mary Major"}},
+					Categories:  []string{"Atom", "xml"},
+					Enclosures: []Enclosure{
+						{URL: "https://atom.example.org/diagram.png", Type: "image/png", Length: 4096},
+					},
+				},
+				{
+					Title:       "Updated only",
+					Content:     "<p>Escaped <em>HTML</em></p>",
+					Link:        "https://atom.example.org/2024/04/updated",
+					PublishedAt: date("2024-04-08T08:00:00Z"),
+					UpdatedAt:   date("2024-04-08T08:00:00Z"),
+					GUID:        "tag:atom.example.org,2024:2",
+				},
+				{
+					Title:   "Without dates",
+					Content: "Text & more",
+					Link:    "https://atom.example.org/2024/04/undated",
+					GUID:    "tag:atom.example.org,2024:3",
+				},
+			},
+		},
+		{
+			file: "feed.json",
+			feed: Feed{
+				Title:       "JSON Feed Example",
+				Description: "A JSON Feed 1.1 document",
+				Link:        "https://json.example.net/",
+				Language:    "en-GB",
+				ImageURL:    "https://json.example.net/icon.png",
+				IconURL:     "https://json.example.net/favicon.ico",
+			},
+			items: []FeedItem{
+				{
+					Title:       "HTML content",
+					Description: "A greeting",
+					Content:     "<p>Hello, <b>world</b></p>",
+					Link:        "https://json.example.net/2",
+					PublishedAt: date("2024-05-01T07:00:00Z"),
+					UpdatedAt:   date("2024-05-02T10:00:00Z"),
+					GUID:        "2",
+					Authors:     []Person{{Name: "Item Author"}},
+					Categories:  []string{"greetings", "json"},
+					Enclosures: []Enclosure{
+						{URL: "https://json.example.net/2.mp3", Type: "audio/mpeg", Length: 2048},
+					},
+					ImageURL: "https://json.example.net/2.png",
+					Podcast:  &Podcast{Duration: 95},
+				},
+				{
+					Content:     "Only text, and an external link",
+					Link:        "https://elsewhere.example.com/linked",
+					PublishedAt: date("2024-04-30T12:00:00Z"),
+					UpdatedAt:   date("2024-04-30T12:00:00Z"),
+					GUID:        "1",
+					Authors:     []Person{{Name: "Feed Author"}},
+				},
+				{
+					Title:   "No date, no link",
+					Content: "<p>Undated</p>",
+					GUID:    "0",
+					Authors: []Person{{Name: "Feed Author"}},
+				},
+			},
+		},
+		{
+			file: "bom.json",
+			feed: Feed{
+				Title: "JSON with BOM",
+				Link:  "https://bom.example.net/",
+			},
+			items: []FeedItem{
+				{
+					Title:       "Version 1 item",
+					Content:     "Text",
+					Link:        "https://bom.example.net/a",
+					PublishedAt: date("2024-06-01T00:00:00Z"),
+					GUID:        "a",
+					Authors:     []Person{{Name: "Old Style"}},
+				},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.file, func(t *testing.T) {
+			feedURL := server.URL + "/" + test.file
+			feed, err := FetchFeed(feedURL)
+			if err != nil {
+				t.Fatalf("FetchFeed: %v", err)
+			}
+
+			if feed.URL != feedURL {
+				t.Errorf("URL = %q, want %q", feed.URL, feedURL)
+			}
+			fields := []struct{ name, got, want string }{
+				{"Title", feed.Title, test.feed.Title},
+				{"Description", feed.Description, test.feed.Description},
+				{"Link", feed.Link, test.feed.Link},
+				{"Language", feed.Language, test.feed.Language},
+				{"ImageURL", feed.ImageURL, test.feed.ImageURL},
+				{"IconURL", feed.IconURL, test.feed.IconURL},
+				{"HubURL", feed.HubURL, test.feed.HubURL},
+				{"SelfURL", feed.SelfURL, test.feed.SelfURL},
+			}
+			for _, field := range fields {
+				if field.got != field.want {
+					t.Errorf("%s = %q, want %q", field.name, field.got, field.want)
+				}
+			}
+
+			if len(feed.Items) != len(test.items) {
+				t.Fatalf("got %d items, want %d", len(feed.Items), len(test.items))
+			}
+			for i, item := range feed.Items {
+				item.PublishedAt = item.PublishedAt.UTC()
+				item.UpdatedAt = item.UpdatedAt.UTC()
+				if !reflect.DeepEqual(item, test.items[i]) {
+					t.Errorf("item %d:\n got %+v\nwant %+v", i, item, test.items[i])
+				}
+			}
+		})
+	}
+}
+
+func TestFetchFeedErrors(t *testing.T) {
+	server := fixtureServer(t)
+
+	if _, err := FetchFeed(""); err == nil {
+		t.Error("expected an error for an empty URL")
+	}
+	if _, err := FetchFeed(server.URL + "/missing.xml"); err == nil {
+		t.Error("expected an error for a missing feed")
+	}
+	if _, err := FetchFeed(server.URL + "/page.html"); err == nil {
+		t.Error("expected an error for a document that isn't a feed")
+	}
+}
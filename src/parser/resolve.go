@@ -0,0 +1,198 @@
+package parser
+
+import (
+	"bytes"
+	"encoding/xml"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/charset"
+)
+
+// xmlBaseAttribute matches xml:base attributes in a feed document
+var xmlBaseAttribute = regexp.MustCompile(`\sxml:base\s*=\s*("[^"]*"|'[^']*')`)
+
+// urlAttributes are the HTML attributes holding a URL that are made absolute
+var urlAttributes = map[string]bool{
+	"href":   true,
+	"src":    true,
+	"poster": true,
+	"cite":   true,
+	"srcset": true,
+}
+
+// resolveItem makes the links of an item absolute. The item link is resolved
+// against base, and the URLs in its content, description, image and enclosures
+// against the item link, falling back to base.
+func resolveItem(item *FeedItem, base *url.URL) {
+	if item.Link != "" {
+		item.Link = resolveURL(base, item.Link)
+	}
+
+	contentBase := base
+	if link, err := url.Parse(item.Link); err == nil && link.IsAbs() {
+		contentBase = link
+	}
+	if contentBase == nil {
+		return
+	}
+
+	item.Description = resolveHTML(item.Description, contentBase)
+	item.Content = resolveHTML(item.Content, contentBase)
+	if item.ImageURL != "" {
+		item.ImageURL = resolveURL(contentBase, item.ImageURL)
+	}
+	for i := range item.Enclosures {
+		item.Enclosures[i].URL = resolveURL(contentBase, item.Enclosures[i].URL)
+	}
+	if item.Podcast != nil && item.Podcast.ImageURL != "" {
+		item.Podcast.ImageURL = resolveURL(contentBase, item.Podcast.ImageURL)
+	}
+}
+
+// resolveHTML makes the relative URLs in the attributes of an HTML fragment
+// absolute. Tags without relative URLs are left exactly as they were.
+func resolveHTML(content string, base *url.URL) string {
+	if base == nil || !strings.Contains(content, "=") {
+		return content
+	}
+
+	var builder strings.Builder
+	tokenizer := html.NewTokenizer(strings.NewReader(content))
+	for {
+		tokenType := tokenizer.Next()
+		if tokenType == html.ErrorToken {
+			// Anything the tokenizer couldn't read is kept as it is
+			builder.Write(tokenizer.Raw())
+			return builder.String()
+		}
+		if tokenType != html.StartTagToken && tokenType != html.SelfClosingTagToken {
+			builder.Write(tokenizer.Raw())
+			continue
+		}
+
+		raw := string(tokenizer.Raw())
+		token := tokenizer.Token()
+		changed := false
+		for i, attr := range token.Attr {
+			if !urlAttributes[attr.Key] || attr.Namespace != "" {
+				continue
+			}
+			var resolved string
+			if attr.Key == "srcset" {
+				resolved = resolveSrcset(attr.Val, base)
+			} else if isRelative(attr.Val) {
+				resolved = resolveURL(base, attr.Val)
+			} else {
+				continue
+			}
+			if resolved != attr.Val {
+				token.Attr[i].Val = resolved
+				changed = true
+			}
+		}
+		if changed {
+			builder.WriteString(token.String())
+		} else {
+			builder.WriteString(raw)
+		}
+	}
+}
+
+// resolveSrcset resolves the URLs of an srcset attribute, keeping their descriptors
+func resolveSrcset(srcset string, base *url.URL) string {
+	candidates := strings.Split(srcset, ",")
+	for i, candidate := range candidates {
+		fields := strings.Fields(candidate)
+		if len(fields) > 0 && isRelative(fields[0]) {
+			fields[0] = resolveURL(base, fields[0])
+		}
+		candidates[i] = strings.Join(fields, " ")
+	}
+	return strings.Join(candidates, ", ")
+}
+
+// isRelative reports whether a URL lacks a scheme, so it depends on where it's read
+func isRelative(link string) bool {
+	link = strings.TrimSpace(link)
+	if link == "" {
+		return false
+	}
+	parsed, err := url.Parse(link)
+	return err == nil && !parsed.IsAbs()
+}
+
+// xmlBases finds the xml:base in effect for a feed document and for each of its
+// items, in document order, resolved against the URL the feed was fetched from.
+// Items without one of their own inherit the base of the feed.
+func xmlBases(data []byte, feedURL *url.URL) (*url.URL, []*url.URL) {
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	decoder.Strict = false
+	decoder.CharsetReader = charset.NewReaderLabel
+
+	feedBase := feedURL
+	itemBases := make([]*url.URL, 0)
+	stack := []*url.URL{feedURL}
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return feedBase, itemBases
+		}
+
+		switch token := token.(type) {
+		case xml.StartElement:
+			base := stack[len(stack)-1]
+			for _, attr := range token.Attr {
+				if attr.Name.Space == "xml" || attr.Name.Space == "http://www.w3.org/XML/1998/namespace" {
+					if attr.Name.Local != "base" {
+						continue
+					}
+					if parsed, err := url.Parse(strings.TrimSpace(attr.Value)); err == nil {
+						if base != nil {
+							parsed = base.ResolveReference(parsed)
+						}
+						base = parsed
+					}
+				}
+			}
+			stack = append(stack, base)
+
+			switch {
+			case len(stack) == 2 || token.Name.Local == "channel" && len(stack) == 3:
+				// The root element, or the channel of an RSS document
+				feedBase = base
+			case isItemElement(token.Name):
+				itemBases = append(itemBases, base)
+			}
+		case xml.EndElement:
+			if len(stack) > 1 {
+				stack = stack[:len(stack)-1]
+			}
+		}
+	}
+}
+
+// isItemElement reports whether an element is an RSS item or an Atom entry, as
+// opposed to an element of the same name from an extension
+func isItemElement(name xml.Name) bool {
+	switch name.Local {
+	case "item":
+		return name.Space == "" || name.Space == "http://purl.org/rss/1.0/" ||
+			name.Space == "http://my.netscape.com/rdf/simple/0.9/" ||
+			name.Space == "http://backend.userland.com/rss2"
+	case "entry":
+		return name.Space == "http://www.w3.org/2005/Atom" || name.Space == "http://purl.org/atom/ns#"
+	}
+	return false
+}
+
+// stripXMLBase removes the xml:base attributes from a feed document. gofeed loses
+// track of nested bases, so they are resolved by resolveItem instead.
+func stripXMLBase(data []byte) []byte {
+	if !bytes.Contains(data, []byte("xml:base")) {
+		return data
+	}
+	return xmlBaseAttribute.ReplaceAll(data, nil)
+}
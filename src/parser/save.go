@@ -0,0 +1,120 @@
+package parser
+
+import (
+	"context"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// requestSave asks the save worker to write changes. Requests made while one
+// is pending are coalesced into a single save.
+func (s *Storage) requestSave() {
+	if !s.autoSave {
+		return
+	}
+	select {
+	case s.saveRequests <- struct{}{}:
+	default:
+	}
+}
+
+// saveWorker is the only goroutine autosaving. It waits for a request, lets
+// further changes gather for the save delay, then writes them all at once.
+func (s *Storage) saveWorker() {
+	defer close(s.workerDone)
+
+	for {
+		select {
+		case <-s.saveRequests:
+		case <-s.closing:
+			return
+		}
+
+		timer := time.NewTimer(s.saveDelay)
+		select {
+		case <-timer.C:
+		case <-s.closing:
+			// Close writes the remaining changes
+			timer.Stop()
+			return
+		}
+
+		// Drop a request that arrived during the delay; this save covers it
+		select {
+		case <-s.saveRequests:
+		default:
+		}
+
+		if err := s.SaveIfNeeded(); err != nil {
+			log.Printf("Error saving feeds: %v", err)
+		}
+	}
+}
+
+// Close stops article extraction and the save worker and writes any remaining
+// changes. It gives up waiting for them when ctx is done.
+func (s *Storage) Close(ctx context.Context) error {
+	// Canceling under the mutex keeps extractArticles from starting one after Wait
+	s.mutex.Lock()
+	s.stopExtracting()
+	s.mutex.Unlock()
+
+	extracted := make(chan struct{})
+	go func() {
+		s.extractions.Wait()
+		close(extracted)
+	}()
+	select {
+	case <-extracted:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	s.closeOnce.Do(func() {
+		close(s.closing)
+	})
+
+	select {
+	case <-s.workerDone:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return s.SaveIfNeeded()
+}
+
+// writeFileAtomic writes data to a temporary file, flushes it to disk and
+// renames it over path, so a crash never leaves a partially written file
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	file, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	tempFile := file.Name()
+
+	_, err = file.Write(data)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tempFile, perm)
+	}
+	if err == nil {
+		err = os.Rename(tempFile, path)
+	}
+	if err != nil {
+		os.Remove(tempFile)
+		return err
+	}
+
+	// Persist the rename itself
+	if dir, err := os.Open(filepath.Dir(path)); err == nil {
+		dir.Sync()
+		dir.Close()
+	}
+	return nil
+}
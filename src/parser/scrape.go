@@ -0,0 +1,229 @@
+package parser
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/andybalholm/cascadia"
+)
+
+// ScrapeConfig describes how to find the items of a web page that has no feed.
+// Selectors other than Item are evaluated within each item. A selector may end in
+// @attribute to read an attribute instead of the text, e.g. "time@datetime", and
+// "@href" reads an attribute of the item itself.
+type ScrapeConfig struct {
+	// Item matches the element of each item on the page
+	Item  string `json:"item"`
+	Title string `json:"title"`
+	// Link defaults to the item itself if it is a link, otherwise its first link
+	Link string `json:"link,omitempty"`
+	Date string `json:"date,omitempty"`
+	// Summary keeps the HTML of the matched element, unless an attribute is read
+	Summary string `json:"summary,omitempty"`
+}
+
+// dateLayouts are the formats tried for scraped dates, in order
+var dateLayouts = []string{
+	time.RFC3339,
+	time.RFC1123Z,
+	time.RFC1123,
+	time.RFC822Z,
+	time.RFC822,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02",
+	"2006/01/02",
+	"January 2, 2006 3:04 PM",
+	"January 2, 2006",
+	"Jan 2, 2006",
+	"2 January 2006",
+	"2 Jan 2006",
+	"02 Jan 2006",
+	"Monday, January 2, 2006",
+	"Mon, Jan 2, 2006",
+	"02.01.2006",
+}
+
+// ordinalSuffix matches the suffix of day numbers like "1st" or "23rd"
+var ordinalSuffix = regexp.MustCompile(`\b(\d{1,2})(st|nd|rd|th)\b`)
+
+// Validate checks that the selectors are present and valid CSS
+func (config ScrapeConfig) Validate() error {
+	if strings.TrimSpace(config.Item) == "" {
+		return errors.New("item selector is required")
+	}
+	if strings.TrimSpace(config.Title) == "" {
+		return errors.New("title selector is required")
+	}
+
+	fields := []struct{ name, selector string }{
+		{"item", config.Item},
+		{"title", config.Title},
+		{"link", config.Link},
+		{"date", config.Date},
+		{"summary", config.Summary},
+	}
+	for _, field := range fields {
+		selector, _ := splitSelector(field.selector)
+		if selector == "" {
+			continue
+		}
+		if field.name == "item" && strings.Contains(field.selector, "@") {
+			return errors.New("item selector cannot read an attribute")
+		}
+		if _, err := cascadia.ParseGroup(selector); err != nil {
+			return fmt.Errorf("invalid %s selector: %v", field.name, err)
+		}
+	}
+	return nil
+}
+
+// ScrapeFeed downloads a web page and turns the items matched by the selectors into a feed
+func ScrapeFeed(pageURL string, config ScrapeConfig) (*Feed, error) {
+	if pageURL == "" {
+		return nil, errors.New("URL cannot be empty")
+	}
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+
+	started := time.Now()
+	doc, base, statusCode, err := fetchPage(context.Background(), httpClient, pageURL)
+	if err != nil {
+		observeFetch(pageURL, started, statusCode, false, err)
+		return nil, err
+	}
+
+	feed, err := ScrapePage(pageURL, doc, base, config)
+	observeFetch(pageURL, started, statusCode, err != nil, err)
+	return feed, err
+}
+
+// ScrapePage turns the items of a parsed web page into a feed. Links are resolved
+// against base, the address the page was found at.
+func ScrapePage(pageURL string, doc *goquery.Document, base *url.URL, config ScrapeConfig) (*Feed, error) {
+	feed := &Feed{
+		URL:         pageURL,
+		Title:       strings.TrimSpace(doc.Find("title").First().Text()),
+		Description: strings.TrimSpace(doc.Find(`meta[name="description"]`).AttrOr("content", "")),
+		Link:        base.String(),
+		Language:    doc.Find("html").AttrOr("lang", ""),
+		ImageURL:    doc.Find(`meta[property="og:image"]`).AttrOr("content", ""),
+		Scrape:      &config,
+		UpdatedAt:   time.Now(),
+		Items:       make([]FeedItem, 0),
+	}
+	if feed.Title == "" {
+		feed.Title = doc.Find(`meta[property="og:site_name"]`).AttrOr("content", "")
+	}
+	if feed.Title == "" {
+		feed.Title = base.Host
+	}
+	if icon, ok := doc.Find(`link[rel~="icon"]`).Attr("href"); ok {
+		feed.IconURL = resolveURL(base, icon)
+	}
+	if feed.ImageURL != "" {
+		feed.ImageURL = resolveURL(base, feed.ImageURL)
+	}
+
+	doc.Find(config.Item).Each(func(_ int, element *goquery.Selection) {
+		item := FeedItem{
+			Title: collapseSpace(selectValue(element, config.Title, false)),
+		}
+
+		if config.Link != "" {
+			// A link selector without an attribute reads the href of the matched link
+			selector, attr := splitSelector(config.Link)
+			if attr == "" {
+				attr = "href"
+			}
+			item.Link = selectElement(element, selector).AttrOr(attr, "")
+		} else if href, ok := element.Attr("href"); ok {
+			item.Link = href
+		} else {
+			item.Link = element.Find("a[href]").First().AttrOr("href", "")
+		}
+		if item.Link = strings.TrimSpace(item.Link); item.Link != "" {
+			item.Link = resolveURL(base, item.Link)
+		}
+
+		if config.Date != "" {
+			item.PublishedAt = parseDate(selectValue(element, config.Date, false))
+		}
+		if config.Summary != "" {
+			// The summary comes from the page itself, so its links are relative to the page
+			item.Description = resolveHTML(strings.TrimSpace(selectValue(element, config.Summary, true)), base)
+		}
+
+		if item.Title == "" && item.Link == "" {
+			return
+		}
+		item.GUID = item.Link
+		feed.Items = append(feed.Items, item)
+	})
+
+	if len(feed.Items) == 0 {
+		return nil, errors.New("no items matched the selectors")
+	}
+	return feed, nil
+}
+
+// selectValue reads the text, HTML or attribute selected within an element
+func selectValue(element *goquery.Selection, selector string, asHTML bool) string {
+	selector, attr := splitSelector(selector)
+	selected := selectElement(element, selector)
+	if attr != "" {
+		return selected.AttrOr(attr, "")
+	}
+	if asHTML {
+		content, _ := selected.Html()
+		return content
+	}
+	return selected.Text()
+}
+
+// selectElement finds the first match of a selector within an element, or the
+// element itself for an empty selector
+func selectElement(element *goquery.Selection, selector string) *goquery.Selection {
+	if selector == "" {
+		return element
+	}
+	return element.Find(selector).First()
+}
+
+// splitSelector splits "selector@attribute" into its parts
+func splitSelector(selector string) (string, string) {
+	selector = strings.TrimSpace(selector)
+	i := strings.LastIndex(selector, "@")
+	if i < 0 {
+		return selector, ""
+	}
+	attr := selector[i+1:]
+	if attr == "" || strings.ContainsAny(attr, " []()=\"'>~+,.#:") {
+		return selector, ""
+	}
+	return strings.TrimSpace(selector[:i]), attr
+}
+
+// parseDate parses a date in one of the common formats, returning the zero time if none fits
+func parseDate(value string) time.Time {
+	value = ordinalSuffix.ReplaceAllString(collapseSpace(value), "$1")
+	for _, layout := range dateLayouts {
+		if parsed, err := time.Parse(layout, value); err == nil {
+			return parsed
+		}
+	}
+	return time.Time{}
+}
+
+// collapseSpace trims a string and collapses runs of whitespace into single spaces
+func collapseSpace(value string) string {
+	return strings.Join(strings.Fields(value), " ")
+}
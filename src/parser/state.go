@@ -0,0 +1,274 @@
+package parser
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"log"
+	"os"
+	"sort"
+	"time"
+)
+
+// itemsData is the on-disk format of cached items.
+// Read and Saved hold item state from before accounts existed.
+type itemsData struct {
+	NextItemID int64                 `json:"next_item_id"`
+	Feeds      map[string][]FeedItem `json:"feeds"`
+	Read       []int64               `json:"read,omitempty"`
+	Saved      []int64               `json:"saved,omitempty"`
+}
+
+// StoredItem is a cached item together with its feed and a user's state
+type StoredItem struct {
+	FeedID  int64
+	FeedURL string
+	Item    FeedItem
+	Read    bool
+	Saved   bool
+}
+
+// Items returns the cached items of a user's subscriptions, ordered by ID
+func (s *Storage) Items(username string) []StoredItem {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	items := make([]StoredItem, 0)
+	user, ok := s.users[username]
+	if !ok {
+		return items
+	}
+
+	for url := range user.Subscriptions {
+		feed, ok := s.feeds[url]
+		if !ok {
+			continue
+		}
+		for _, item := range feed.Items {
+			items = append(items, StoredItem{
+				FeedID:  feed.ID,
+				FeedURL: feed.URL,
+				Item:    item,
+				Read:    user.Read[item.ID],
+				Saved:   user.Saved[item.ID],
+			})
+		}
+	}
+
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].Item.ID < items[j].Item.ID
+	})
+	return items
+}
+
+// SetRead marks items as read or unread for a user
+func (s *Storage) SetRead(username string, ids []int64, read bool) error {
+	return s.setFlag(username, func(user *User) map[int64]bool { return user.Read }, ids, read)
+}
+
+// SetSaved marks items as saved or unsaved for a user
+func (s *Storage) SetSaved(username string, ids []int64, saved bool) error {
+	return s.setFlag(username, func(user *User) map[int64]bool { return user.Saved }, ids, saved)
+}
+
+// MarkFeedRead marks all items of a feed published before the given time as read
+// for a user. A zero time marks all items.
+func (s *Storage) MarkFeedRead(username, url string, before time.Time) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	user, ok := s.users[username]
+	if !ok {
+		return ErrUserNotFound
+	}
+	feed, ok := s.feeds[url]
+	if _, subscribed := user.Subscriptions[url]; !ok || !subscribed {
+		return errors.New("feed not found")
+	}
+	s.markRead(user, feed, before)
+	return nil
+}
+
+// MarkFolderRead marks items of all feeds in a user's folder published before the
+// given time as read. An empty folder marks items of all the user's feeds.
+func (s *Storage) MarkFolderRead(username, folder string, before time.Time) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	user, ok := s.users[username]
+	if !ok {
+		return ErrUserNotFound
+	}
+	for url, subscription := range user.Subscriptions {
+		feed, ok := s.feeds[url]
+		if ok && (folder == "" || subscription.Folder == folder) {
+			s.markRead(user, feed, before)
+		}
+	}
+	return nil
+}
+
+// FeedByID finds one of a user's subscriptions by its numeric ID
+func (s *Storage) FeedByID(username string, id int64) (*Feed, bool) {
+	for _, feed := range s.Subscriptions(username) {
+		if feed.ID == id {
+			return feed, true
+		}
+	}
+	return nil, false
+}
+
+// LastRefresh returns the most recent time any feed was refreshed
+func (s *Storage) LastRefresh() time.Time {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	var last time.Time
+	for _, feed := range s.feeds {
+		if feed.UpdatedAt.After(last) {
+			last = feed.UpdatedAt
+		}
+	}
+	return last
+}
+
+// RefreshAll fetches fresh content for all feeds
+func (s *Storage) RefreshAll() error {
+	var lastErr error
+	for _, feed := range s.GetAllFeeds() {
+		if _, err := s.GetFeed(feed.URL); err != nil {
+			log.Printf("Error refreshing feed %s: %v", feed.URL, err)
+			lastErr = err
+		}
+	}
+	return lastErr
+}
+
+// setFlag sets or clears a state flag of a user's items
+func (s *Storage) setFlag(username string, flags func(*User) map[int64]bool, ids []int64, value bool) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	user, ok := s.users[username]
+	if !ok {
+		return ErrUserNotFound
+	}
+
+	userFlags := flags(user)
+	for _, id := range ids {
+		if value {
+			userFlags[id] = true
+		} else {
+			delete(userFlags, id)
+		}
+	}
+	s.saveNeeded = true
+	return nil
+}
+
+// markRead marks items of a feed as read for a user. The caller must hold the mutex.
+func (s *Storage) markRead(user *User, feed *Feed, before time.Time) {
+	for _, item := range feed.Items {
+		if before.IsZero() || item.PublishedAt.Before(before) {
+			user.Read[item.ID] = true
+		}
+	}
+	s.saveNeeded = true
+}
+
+// isSaved returns true if any user saved the item. The caller must hold the mutex.
+func (s *Storage) isSaved(id int64) bool {
+	if s.legacySaved[id] {
+		return true
+	}
+	for _, user := range s.users {
+		if user.Saved[id] {
+			return true
+		}
+	}
+	return false
+}
+
+// assignFeedIDs gives feeds loaded without an ID one, oldest first.
+// The caller must hold the mutex.
+func (s *Storage) assignFeedIDs() {
+	missing := make([]*Feed, 0)
+	for _, feed := range s.feeds {
+		if feed.ID >= s.nextFeedID {
+			s.nextFeedID = feed.ID + 1
+		}
+		if feed.ID == 0 {
+			missing = append(missing, feed)
+		}
+	}
+
+	sort.Slice(missing, func(i, j int) bool {
+		return missing[i].UpdatedAt.Before(missing[j].UpdatedAt)
+	})
+	for _, feed := range missing {
+		feed.ID = s.nextFeedID
+		s.nextFeedID++
+		s.saveNeeded = true
+	}
+}
+
+// loadItems loads cached items. The caller must hold the mutex.
+func (s *Storage) loadItems() error {
+	if s.itemsFilePath == "" {
+		return nil
+	}
+
+	data, err := ioutil.ReadFile(s.itemsFilePath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var stored itemsData
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return err
+	}
+
+	if stored.NextItemID > s.nextItemID {
+		s.nextItemID = stored.NextItemID
+	}
+	for url, items := range stored.Feeds {
+		if feed, ok := s.feeds[url]; ok {
+			feed.Items = items
+		}
+	}
+	for _, id := range stored.Read {
+		s.legacyRead[id] = true
+	}
+	for _, id := range stored.Saved {
+		s.legacySaved[id] = true
+	}
+	return nil
+}
+
+// marshalItems encodes cached items for saving. The caller must hold the mutex.
+func (s *Storage) marshalItems() ([]byte, error) {
+	if s.itemsFilePath == "" {
+		return nil, nil
+	}
+
+	stored := itemsData{
+		NextItemID: s.nextItemID,
+		Feeds:      make(map[string][]FeedItem, len(s.feeds)),
+	}
+	for url, feed := range s.feeds {
+		stored.Feeds[url] = feed.Items
+	}
+
+	// Keep state from before accounts existed until the first user adopts it
+	for id := range s.legacyRead {
+		stored.Read = append(stored.Read, id)
+	}
+	for id := range s.legacySaved {
+		stored.Saved = append(stored.Saved, id)
+	}
+
+	return json.Marshal(stored)
+}
@@ -1,8 +1,10 @@
 package parser
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io/ioutil"
 	"log"
 	"os"
@@ -13,43 +15,111 @@ import (
 
 // FeedMetadata represents the essential information about a feed without its content
 type FeedMetadata struct {
-	URL         string    `json:"url"`
-	Title       string    `json:"title"`
-	Description string    `json:"description"`
-	AddedAt     time.Time `json:"added_at"`
+	ID          int64         `json:"id,omitempty"`
+	URL         string        `json:"url"`
+	Title       string        `json:"title"`
+	Description string        `json:"description"`
+	Link        string        `json:"link,omitempty"`
+	Language    string        `json:"language,omitempty"`
+	ImageURL    string        `json:"image_url,omitempty"`
+	IconURL     string        `json:"icon_url,omitempty"`
+	Folder      string        `json:"folder,omitempty"`
+	HubURL      string        `json:"hub_url,omitempty"`
+	SelfURL     string        `json:"self_url,omitempty"`
+	Archive     bool          `json:"archive,omitempty"`
+	ArchiveKeep int           `json:"archive_keep,omitempty"`
+	FullContent bool          `json:"full_content,omitempty"`
+	Scrape      *ScrapeConfig `json:"scrape,omitempty"`
+	JSONSource  *JSONSource   `json:"json_source,omitempty"`
+	AddedAt     time.Time     `json:"added_at"`
 }
 
 // Storage represents a storage for feeds with JSON file persistence
 type Storage struct {
-	feeds      map[string]*Feed
-	mutex      sync.RWMutex
-	filePath   string
-	autoSave   bool
-	lastSave   time.Time
-	saveNeeded bool
+	feeds         map[string]*Feed
+	mutex         sync.RWMutex
+	filePath      string
+	itemsFilePath string
+	usersFilePath string
+	autoSave      bool
+	lastSave      time.Time
+	saveNeeded    bool
+	listeners     []ItemListener
+	nextFeedID    int64
+	nextItemID    int64
+	users         map[string]*User
+	legacyRead    map[int64]bool
+	legacySaved   map[int64]bool
+	// extractFailures holds when extracting the article of a page last failed
+	extractFailures map[string]time.Time
+	// extracting holds the feeds whose articles are being extracted
+	extracting map[string]bool
+	// extractCtx is canceled by Close, which waits for extractions to stop
+	extractCtx     context.Context
+	stopExtracting context.CancelFunc
+	extractions    sync.WaitGroup
+	// saveMutex serializes writes to the data files
+	saveMutex    sync.Mutex
+	saveDelay    time.Duration
+	saveRequests chan struct{}
+	closing      chan struct{}
+	closeOnce    sync.Once
+	workerDone   chan struct{}
+	// status guards the fields below, which health checks read without touching the feeds
+	status       sync.Mutex
+	loaded       bool
+	saveErr      error
+	saveFailures int
+	savedAt      time.Time
 }
 
+// ItemListener is called with the items a feed gained since it was last seen
+type ItemListener func(url string, items []FeedItem)
+
 // StorageConfig holds configuration for the storage
 type StorageConfig struct {
 	FilePath string
-	AutoSave bool
+	// ItemsFilePath is where cached items and their read state are kept; empty disables it
+	ItemsFilePath string
+	// UsersFilePath is where accounts, their subscriptions and item state are kept
+	UsersFilePath string
+	AutoSave      bool
+	// SaveDelay is how long autosave waits to collect further changes before writing
+	SaveDelay time.Duration
 }
 
 // DefaultStorageConfig returns a default configuration
 func DefaultStorageConfig() StorageConfig {
 	return StorageConfig{
-		FilePath: "feeds.json",
-		AutoSave: true,
+		FilePath:      "feeds.json",
+		ItemsFilePath: "items.json",
+		UsersFilePath: "users.json",
+		AutoSave:      true,
+		SaveDelay:     500 * time.Millisecond,
 	}
 }
 
 // NewStorage creates a new storage instance
 func NewStorage(config StorageConfig) *Storage {
 	s := &Storage{
-		feeds:    make(map[string]*Feed),
-		filePath: config.FilePath,
-		autoSave: config.AutoSave,
+		feeds:           make(map[string]*Feed),
+		filePath:        config.FilePath,
+		itemsFilePath:   config.ItemsFilePath,
+		usersFilePath:   config.UsersFilePath,
+		autoSave:        config.AutoSave,
+		nextFeedID:      1,
+		nextItemID:      1,
+		users:           make(map[string]*User),
+		legacyRead:      make(map[int64]bool),
+		legacySaved:     make(map[int64]bool),
+		extractFailures: make(map[string]time.Time),
+		extracting:      make(map[string]bool),
+		saveDelay:       config.SaveDelay,
+		saveRequests:    make(chan struct{}, 1),
+		closing:         make(chan struct{}),
+		workerDone:      make(chan struct{}),
 	}
+	s.extractCtx, s.stopExtracting = context.WithCancel(context.Background())
 
 	// Create directory for the file if it doesn't exist
 	dir := filepath.Dir(config.FilePath)
@@ -61,7 +131,18 @@ func NewStorage(config StorageConfig) *Storage {
 
 	// Load feeds from file if it exists
 	if err := s.LoadFromFile(); err != nil {
-		log.Printf("Warning: Failed to load feeds from file: %v", err)
+		log.Printf("Warning: Failed to load stored data, it won't be saved over: %v", err)
+	} else {
+		s.status.Lock()
+		s.loaded = true
+		s.savedAt = time.Now()
+		s.status.Unlock()
+	}
+
+	if s.autoSave {
+		go s.saveWorker()
+	} else {
+		close(s.workerDone)
 	}
 	return s
 }
@@ -83,40 +164,53 @@ func (s *Storage) AddFeed(feed *Feed) error {
 		// Update only metadata for existing feed
 		existingFeed.Title = feed.Title
 		existingFeed.Description = feed.Description
+		existingFeed.Link = feed.Link
+		existingFeed.Language = feed.Language
+		existingFeed.ImageURL = feed.ImageURL
+		existingFeed.IconURL = feed.IconURL
+		if feed.Folder != "" {
+			existingFeed.Folder = feed.Folder
+		}
+		existingFeed.HubURL = feed.HubURL
+		existingFeed.SelfURL = feed.SelfURL
+		if feed.Scrape != nil {
+			existingFeed.Scrape = feed.Scrape
+		}
+		if feed.JSONSource != nil {
+			existingFeed.JSONSource = feed.JSONSource
+		}
 		// Don't update the items - we'll fetch them fresh each time
 
 		s.saveNeeded = true
 
-		if s.autoSave {
-			go func() {
-				if err := s.SaveToFile(); err != nil {
-					log.Printf("Error saving feeds: %v", err)
-				}
-			}()
-		}
+		s.autoSaveLocked()
 		return nil
 	}
 
 	// Add new feed (only metadata is important for storage)
 	s.feeds[feed.URL] = &Feed{
+		ID:          s.nextFeedID,
 		URL:         feed.URL,
 		Title:       feed.Title,
 		Description: feed.Description,
+		Link:        feed.Link,
+		Language:    feed.Language,
+		ImageURL:    feed.ImageURL,
+		IconURL:     feed.IconURL,
+		Folder:      feed.Folder,
+		HubURL:      feed.HubURL,
+		SelfURL:     feed.SelfURL,
+		Scrape:      feed.Scrape,
+		JSONSource:  feed.JSONSource,
 		UpdatedAt:   time.Now(),
 		// Don't store items - we'll fetch them fresh when needed
 		Items: nil,
 	}
+	s.nextFeedID++
 
 	s.saveNeeded = true
 
-	if s.autoSave {
-		// Save in a goroutine to avoid blocking
-		go func() {
-			if err := s.SaveToFile(); err != nil {
-				log.Printf("Error saving feeds: %v", err)
-			}
-		}()
-	}
+	s.autoSaveLocked()
 	return nil
 }
 
@@ -134,24 +228,168 @@ func (s *Storage) GetFeed(url string) (*Feed, error) {
 		return nil, errors.New("feed not found")
 	}
 
+	s.mutex.RLock()
+	fullContent := storedFeed.FullContent
+	scrape := storedFeed.Scrape
+	jsonSource := storedFeed.JSONSource
+	s.mutex.RUnlock()
+
 	// Always fetch fresh content for the feed
 	log.Printf("Fetching fresh content for feed: %s", url)
-	freshFeed, err := FetchFeed(url)
+	var freshFeed *Feed
+	var err error
+	if scrape != nil {
+		freshFeed, err = ScrapeFeed(url, *scrape)
+	} else if jsonSource != nil {
+		freshFeed, err = FetchJSONFeed(url, *jsonSource)
+	} else {
+		freshFeed, err = FetchFeed(url)
+	}
 	if err != nil {
 		return nil, err
 	}
 
 	// Update stored feed metadata if needed
 	s.mutex.Lock()
+	if fullContent {
+		reuseArticles(storedFeed, freshFeed.Items)
+	}
 	storedFeed.Title = freshFeed.Title
 	storedFeed.Description = freshFeed.Description
+	if freshFeed.Link != storedFeed.Link || freshFeed.Language != storedFeed.Language ||
+		freshFeed.ImageURL != storedFeed.ImageURL || freshFeed.IconURL != storedFeed.IconURL {
+		storedFeed.Link = freshFeed.Link
+		storedFeed.Language = freshFeed.Language
+		storedFeed.ImageURL = freshFeed.ImageURL
+		storedFeed.IconURL = freshFeed.IconURL
+		s.saveNeeded = true
+	}
+	if freshFeed.HubURL != storedFeed.HubURL || freshFeed.SelfURL != storedFeed.SelfURL {
+		storedFeed.HubURL = freshFeed.HubURL
+		storedFeed.SelfURL = freshFeed.SelfURL
+		s.saveNeeded = true
+	}
 	storedFeed.UpdatedAt = time.Now()
+
+	// Keep items that were pushed to us but have already left the upstream document.
+	// The first fetch only establishes a baseline, so it doesn't count as new items.
+	hadItems := len(storedFeed.Items) > 0
+	added := s.mergeFeedItems(storedFeed, freshFeed.Items)
+	freshFeed.Items = append([]FeedItem(nil), storedFeed.Items...)
 	s.mutex.Unlock()
 
+	if hadItems {
+		s.notifyNewItems(url, added)
+	}
+	if fullContent {
+		s.extractArticles(url)
+	}
+
 	// Return the fresh feed with content
+	freshFeed.ID = storedFeed.ID
+	freshFeed.Folder = storedFeed.Folder
+	freshFeed.Archive = storedFeed.Archive
+	freshFeed.ArchiveKeep = storedFeed.ArchiveKeep
+	freshFeed.FullContent = storedFeed.FullContent
+	freshFeed.Scrape = storedFeed.Scrape
+	freshFeed.JSONSource = storedFeed.JSONSource
 	return freshFeed, nil
 }
 
+// MergeItems merges items into a stored feed, returning how many of them were new
+func (s *Storage) MergeItems(url string, items []FeedItem) (int, error) {
+	if url == "" {
+		return 0, errors.New("URL cannot be empty")
+	}
+
+	s.mutex.Lock()
+	storedFeed, ok := s.feeds[url]
+	if !ok {
+		s.mutex.Unlock()
+		return 0, errors.New("feed not found")
+	}
+	added := s.mergeFeedItems(storedFeed, items)
+	// Pushed items arrive between refreshes, so they are saved right away
+	// rather than waiting for the next scheduled save
+	if len(added) > 0 {
+		s.autoSaveLocked()
+	}
+	s.mutex.Unlock()
+
+	s.notifyNewItems(url, added)
+	return len(added), nil
+}
+
+// CachedFeed gets a feed with the items currently held in memory, without fetching it
+func (s *Storage) CachedFeed(url string) (*Feed, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	feed, ok := s.feeds[url]
+	if !ok {
+		return nil, errors.New("feed not found")
+	}
+
+	feedCopy := *feed
+	feedCopy.Items = append([]FeedItem(nil), feed.Items...)
+	return &feedCopy, nil
+}
+
+// OnNewItems registers a listener that is called when feeds gain new items
+func (s *Storage) OnNewItems(listener ItemListener) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.listeners = append(s.listeners, listener)
+}
+
+// mergeFeedItems merges items into a stored feed and returns the ones it didn't have.
+// The caller must hold the mutex.
+func (s *Storage) mergeFeedItems(feed *Feed, items []FeedItem) []FeedItem {
+	ids := make(map[string]int64, len(feed.Items))
+	for _, item := range feed.Items {
+		ids[ItemKey(item)] = item.ID
+	}
+
+	added := make([]FeedItem, 0)
+	incoming := make([]FeedItem, len(items))
+	for i, item := range items {
+		key := ItemKey(item)
+		if id, ok := ids[key]; ok {
+			item.ID = id
+		} else {
+			item.ID = s.nextItemID
+			s.nextItemID++
+			ids[key] = item.ID
+			added = append(added, item)
+		}
+		incoming[i] = item
+	}
+
+	feed.Items = mergeItems(feed.Items, incoming, func(item FeedItem) bool {
+		return s.isSaved(item.ID)
+	})
+	if len(added) > 0 {
+		s.saveNeeded = true
+		itemsIngestedTotal.Add(float64(len(added)), feed.URL)
+	}
+	return added
+}
+
+// notifyNewItems calls the registered listeners with new items of a feed
+func (s *Storage) notifyNewItems(url string, items []FeedItem) {
+	if len(items) == 0 {
+		return
+	}
+
+	s.mutex.RLock()
+	listeners := append([]ItemListener(nil), s.listeners...)
+	s.mutex.RUnlock()
+
+	for _, listener := range listeners {
+		listener(url, items)
+	}
+}
+
 // GetAllFeeds gets all feeds from the storage (without their content)
 func (s *Storage) GetAllFeeds() []*Feed {
 	s.mutex.RLock()
@@ -161,9 +399,18 @@ func (s *Storage) GetAllFeeds() []*Feed {
 	for _, feed := range s.feeds {
 		// Create a copy without items to reduce memory usage
 		feedCopy := &Feed{
+			ID:          feed.ID,
 			URL:         feed.URL,
 			Title:       feed.Title,
 			Description: feed.Description,
+			Folder:      feed.Folder,
+			HubURL:      feed.HubURL,
+			SelfURL:     feed.SelfURL,
+			Archive:     feed.Archive,
+			ArchiveKeep: feed.ArchiveKeep,
+			FullContent: feed.FullContent,
+			Scrape:      feed.Scrape,
+			JSONSource:  feed.JSONSource,
 			UpdatedAt:   feed.UpdatedAt,
 			// Don't include items - they'll be fetched when needed
 			Items: nil,
@@ -188,74 +435,153 @@ func (s *Storage) RemoveFeed(url string) error {
 	}
 
 	delete(s.feeds, url)
+	for _, user := range s.users {
+		delete(user.Subscriptions, url)
+	}
 	s.saveNeeded = true
 
-	if s.autoSave {
-		// Save in a goroutine to avoid blocking
-		go func() {
-			if err := s.SaveToFile(); err != nil {
-				log.Printf("Error saving feeds: %v", err)
-			}
-		}()
-	}
+	s.autoSaveLocked()
 	return nil
 }
 
 // SaveToFile saves feed metadata to a JSON file
 func (s *Storage) SaveToFile() error {
-	s.mutex.RLock()
-	defer s.mutex.RUnlock()
+	started := time.Now()
+	err := s.saveToFile()
+	saveDuration.Observe(time.Since(started).Seconds())
+	if err != nil {
+		saveFailuresTotal.Inc()
+	}
 
-	// Create directory if it doesn't exist
-	dir := filepath.Dir(s.filePath)
-	if dir != "." && dir != "" {
-		if err := os.MkdirAll(dir, 0755); err != nil {
-			return err
-		}
+	s.status.Lock()
+	s.saveErr = err
+	if err != nil {
+		s.saveFailures++
+	} else {
+		s.saveFailures = 0
+		s.savedAt = time.Now()
+	}
+	s.status.Unlock()
+	return err
+}
+
+// saveToFile writes feed metadata, items and accounts. The data is collected
+// under the lock and written after releasing it, so readers aren't blocked on disk.
+func (s *Storage) saveToFile() error {
+	// Saving would replace what couldn't be read with what little was
+	if !s.Loaded() {
+		return ErrNotLoaded
 	}
 
+	s.saveMutex.Lock()
+	defer s.saveMutex.Unlock()
+
+	s.mutex.Lock()
 	// Convert feeds map to metadata for more efficient storage
 	metadataList := make([]FeedMetadata, 0, len(s.feeds))
 	for _, feed := range s.feeds {
 		metadata := FeedMetadata{
+			ID:          feed.ID,
 			URL:         feed.URL,
 			Title:       feed.Title,
 			Description: feed.Description,
+			Link:        feed.Link,
+			Language:    feed.Language,
+			ImageURL:    feed.ImageURL,
+			IconURL:     feed.IconURL,
+			Folder:      feed.Folder,
+			HubURL:      feed.HubURL,
+			SelfURL:     feed.SelfURL,
+			Archive:     feed.Archive,
+			ArchiveKeep: feed.ArchiveKeep,
+			FullContent: feed.FullContent,
+			Scrape:      feed.Scrape,
+			JSONSource:  feed.JSONSource,
 			AddedAt:     feed.UpdatedAt,
 		}
 		metadataList = append(metadataList, metadata)
 	}
 
-	// Marshal to JSON
 	data, err := json.MarshalIndent(metadataList, "", "  ")
+	var itemsData, usersData []byte
+	if err == nil {
+		itemsData, err = s.marshalItems()
+	}
+	if err == nil {
+		usersData, err = s.marshalUsers()
+	}
 	if err != nil {
+		s.mutex.Unlock()
 		return err
 	}
+	// Changes made while writing mark the storage dirty again
+	s.saveNeeded = false
+	s.mutex.Unlock()
 
-	// Write to temp file first, then rename
-	tempFile := s.filePath + ".tmp"
-	if err := ioutil.WriteFile(tempFile, data, 0644); err != nil {
+	err = s.writeFiles(data, itemsData, usersData)
+	if err != nil {
+		s.mutex.Lock()
+		s.saveNeeded = true
+		s.mutex.Unlock()
 		return err
 	}
 
-	if err := os.Rename(tempFile, s.filePath); err != nil {
-		// If rename fails, try direct write
-		if err2 := ioutil.WriteFile(s.filePath, data, 0644); err2 != nil {
-			return err2
+	s.mutex.Lock()
+	s.lastSave = time.Now()
+	s.mutex.Unlock()
+	log.Printf("Saved %d feed subscriptions to %s", len(metadataList), s.filePath)
+	return nil
+}
+
+// writeFiles writes the marshalled data files
+func (s *Storage) writeFiles(feedsData, itemsData, usersData []byte) error {
+	// Create directory if it doesn't exist
+	dir := filepath.Dir(s.filePath)
+	if dir != "." && dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
 		}
 	}
 
-	s.lastSave = time.Now()
-	s.saveNeeded = false
-	log.Printf("Saved %d feed subscriptions to %s", len(metadataList), s.filePath)
+	if err := writeFileAtomic(s.filePath, feedsData, 0644); err != nil {
+		return err
+	}
+	if s.itemsFilePath != "" {
+		if err := writeFileAtomic(s.itemsFilePath, itemsData, 0644); err != nil {
+			return err
+		}
+	}
+	if s.usersFilePath != "" {
+		// Users file contains password hashes, so keep it private
+		if err := writeFileAtomic(s.usersFilePath, usersData, 0600); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
-// LoadFromFile loads feed metadata from a JSON file
+// LoadFromFile loads feeds, items and accounts from their files. Each file is
+// loaded on its own, so a damaged one doesn't keep the others from loading;
+// the errors of all files that failed are returned.
 func (s *Storage) LoadFromFile() error {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 
+	var errs []error
+	if err := s.loadFeeds(); err != nil {
+		errs = append(errs, fmt.Errorf("%s: %v", s.filePath, err))
+	}
+	if err := s.loadItems(); err != nil {
+		errs = append(errs, fmt.Errorf("%s: %v", s.itemsFilePath, err))
+	}
+	if err := s.loadUsers(); err != nil {
+		errs = append(errs, fmt.Errorf("%s: %v", s.usersFilePath, err))
+	}
+	return errors.Join(errs...)
+}
+
+// loadFeeds loads feed metadata. The caller must hold the mutex.
+func (s *Storage) loadFeeds() error {
 	// Check if file exists
 	if _, err := os.Stat(s.filePath); os.IsNotExist(err) {
 		log.Printf("Feeds file %s doesn't exist, starting with empty storage", s.filePath)
@@ -285,9 +611,22 @@ func (s *Storage) LoadFromFile() error {
 	for _, metadata := range metadataList {
 		if metadata.URL != "" {
 			s.feeds[metadata.URL] = &Feed{
+				ID:          metadata.ID,
 				URL:         metadata.URL,
 				Title:       metadata.Title,
 				Description: metadata.Description,
+				Link:        metadata.Link,
+				Language:    metadata.Language,
+				ImageURL:    metadata.ImageURL,
+				IconURL:     metadata.IconURL,
+				Folder:      metadata.Folder,
+				HubURL:      metadata.HubURL,
+				SelfURL:     metadata.SelfURL,
+				Archive:     metadata.Archive,
+				ArchiveKeep: metadata.ArchiveKeep,
+				FullContent: metadata.FullContent,
+				Scrape:      metadata.Scrape,
+				JSONSource:  metadata.JSONSource,
 				UpdatedAt:   metadata.AddedAt,
 				// Don't load items - will fetch fresh when needed
 				Items: nil,
@@ -295,6 +634,8 @@ func (s *Storage) LoadFromFile() error {
 		}
 	}
 
+	s.assignFeedIDs()
+
 	log.Printf("Loaded %d feed subscriptions from %s", len(metadataList), s.filePath)
 	return nil
 }
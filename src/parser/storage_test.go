@@ -0,0 +1,141 @@
+package parser
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestStorage returns an autosaving storage in a temporary directory
+func newTestStorage(t *testing.T) (*Storage, string) {
+	t.Helper()
+	dir := t.TempDir()
+	storage := NewStorage(StorageConfig{
+		FilePath:      filepath.Join(dir, "feeds.json"),
+		ItemsFilePath: filepath.Join(dir, "items.json"),
+		UsersFilePath: filepath.Join(dir, "users.json"),
+		AutoSave:      true,
+		SaveDelay:     10 * time.Millisecond,
+	})
+	t.Cleanup(func() { storage.Close(context.Background()) })
+	return storage, dir
+}
+
+// waitForFile waits until a file contains text
+func waitForFile(t *testing.T, path, text string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if data, err := os.ReadFile(path); err == nil && strings.Contains(string(data), text) {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("%s doesn't contain %q", filepath.Base(path), text)
+}
+
+func TestMergeItemsSaves(t *testing.T) {
+	storage, dir := newTestStorage(t)
+	if err := storage.AddFeed(&Feed{URL: "http://example.com/feed.xml", Title: "Pushed"}); err != nil {
+		t.Fatal(err)
+	}
+	// Let the save for the new feed finish, so only the merge can save the item
+	waitForFile(t, filepath.Join(dir, "feeds.json"), "Pushed")
+
+	added, err := storage.MergeItems("http://example.com/feed.xml", []FeedItem{
+		{Title: "Pushed item", Link: "http://example.com/pushed", GUID: "pushed"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if added != 1 {
+		t.Fatalf("added = %d, want 1", added)
+	}
+	waitForFile(t, filepath.Join(dir, "items.json"), "Pushed item")
+}
+
+func TestSaveStatus(t *testing.T) {
+	storage, dir := newTestStorage(t)
+	if status := storage.SaveStatus(); status.Failures != 0 || status.LastSuccess.IsZero() {
+		t.Fatalf("status after loading = %+v, want no failures and a success", status)
+	}
+
+	// A file in place of the data directory makes every save fail
+	if err := os.RemoveAll(dir); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(dir, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i <= 2; i++ {
+		if err := storage.SaveToFile(); err == nil {
+			t.Fatal("save succeeded without a data directory")
+		}
+		if status := storage.SaveStatus(); status.Failures != i || status.Err == nil {
+			t.Errorf("status after %d failures = %+v", i, status)
+		}
+	}
+
+	if err := os.Remove(dir); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	before := time.Now()
+	if err := storage.SaveToFile(); err != nil {
+		t.Fatal(err)
+	}
+	if status := storage.SaveStatus(); status.Failures != 0 || status.Err != nil || status.LastSuccess.Before(before) {
+		t.Errorf("status after a successful save = %+v", status)
+	}
+}
+
+func TestDamagedFileKeepsData(t *testing.T) {
+	storage, dir := newTestStorage(t)
+	if _, err := storage.CreateUser("alice", "secret123"); err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.Subscribe("alice", &Feed{URL: "http://example.com/feed.xml", Title: "Feed"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	usersFile := filepath.Join(dir, "users.json")
+	users, err := os.ReadFile(usersFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "items.json"), []byte("{damaged"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened := NewStorage(StorageConfig{
+		FilePath:      filepath.Join(dir, "feeds.json"),
+		ItemsFilePath: filepath.Join(dir, "items.json"),
+		UsersFilePath: usersFile,
+	})
+	if reopened.Loaded() {
+		t.Error("storage with a damaged items file reports it was loaded")
+	}
+	// The other files are still loaded
+	if !reopened.HasUser("alice") || !reopened.IsSubscribed("alice", "http://example.com/feed.xml") {
+		t.Error("accounts were not loaded next to a damaged items file")
+	}
+
+	if err := reopened.SaveToFile(); !errors.Is(err, ErrNotLoaded) {
+		t.Errorf("save: err = %v, want ErrNotLoaded", err)
+	}
+	if _, err := reopened.CreateUser("mallory", "secret123"); !errors.Is(err, ErrNotLoaded) {
+		t.Errorf("creating an account: err = %v, want ErrNotLoaded", err)
+	}
+	reopened.Close(context.Background())
+	if data, _ := os.ReadFile(usersFile); string(data) != string(users) {
+		t.Errorf("users file was changed:\n%s", data)
+	}
+}
@@ -0,0 +1,555 @@
+package parser
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// ErrUserNotFound is returned when a user doesn't exist
+var ErrUserNotFound = errors.New("user not found")
+
+// ErrInvalidCredentials is returned when a username and password don't match
+var ErrInvalidCredentials = errors.New("invalid username or password")
+
+// dummyHash is compared against for unknown users so they take as long as wrong passwords
+var (
+	dummyHash     []byte
+	dummyHashOnce sync.Once
+)
+
+// User represents an account with its own subscriptions and item state
+type User struct {
+	Username     string
+	PasswordHash string
+	// FeverKey is the Fever api_key, md5("username:password")
+	FeverKey string
+	// GReaderToken is handed to Google Reader API clients on login
+	GReaderToken  string
+	Admin         bool
+	CreatedAt     time.Time
+	Subscriptions map[string]*Subscription
+	Read          map[int64]bool
+	Saved         map[int64]bool
+	// Positions is how far into each episode the user has listened, in seconds
+	Positions map[int64]float64
+}
+
+// Subscription represents a user's subscription to a shared feed
+type Subscription struct {
+	URL     string    `json:"url"`
+	Folder  string    `json:"folder,omitempty"`
+	AddedAt time.Time `json:"added_at"`
+}
+
+// userData is the on-disk format of a user
+type userData struct {
+	Username      string            `json:"username"`
+	PasswordHash  string            `json:"password_hash"`
+	FeverKey      string            `json:"fever_key"`
+	GReaderToken  string            `json:"greader_token"`
+	Admin         bool              `json:"admin,omitempty"`
+	CreatedAt     time.Time         `json:"created_at"`
+	Subscriptions []*Subscription   `json:"subscriptions"`
+	Read          []int64           `json:"read"`
+	Saved         []int64           `json:"saved"`
+	Positions     map[int64]float64 `json:"positions,omitempty"`
+}
+
+// CreateUser creates an account. The first account becomes an admin and
+// adopts the subscriptions that existed before accounts were introduced.
+func (s *Storage) CreateUser(username, password string) (*User, error) {
+	username = strings.TrimSpace(username)
+	if username == "" {
+		return nil, errors.New("username cannot be empty")
+	}
+	if len(password) < 8 {
+		return nil, errors.New("password must be at least 8 characters")
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, err
+	}
+	token, err := randomHex(20)
+	if err != nil {
+		return nil, err
+	}
+
+	if !s.Loaded() {
+		return nil, ErrNotLoaded
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, ok := s.users[username]; ok {
+		return nil, errors.New("username is already taken")
+	}
+
+	user := &User{
+		Username:      username,
+		PasswordHash:  string(hash),
+		FeverKey:      feverKey(username, password),
+		GReaderToken:  username + "/" + token,
+		Admin:         len(s.users) == 0,
+		CreatedAt:     time.Now(),
+		Subscriptions: make(map[string]*Subscription),
+		Read:          make(map[int64]bool),
+		Saved:         make(map[int64]bool),
+		Positions:     make(map[int64]float64),
+	}
+
+	if user.Admin {
+		s.adoptLegacyState(user)
+	}
+
+	s.users[username] = user
+	s.saveNeeded = true
+	s.autoSaveLocked()
+
+	log.Printf("Created user %s", username)
+	return user.copy(), nil
+}
+
+// SetPassword changes a user's password
+func (s *Storage) SetPassword(username, password string) error {
+	if len(password) < 8 {
+		return errors.New("password must be at least 8 characters")
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	user, ok := s.users[username]
+	if !ok {
+		return ErrUserNotFound
+	}
+	user.PasswordHash = string(hash)
+	user.FeverKey = feverKey(username, password)
+	s.saveNeeded = true
+	s.autoSaveLocked()
+	return nil
+}
+
+// Authenticate checks a username and password
+func (s *Storage) Authenticate(username, password string) (*User, error) {
+	s.mutex.RLock()
+	user, ok := s.users[username]
+	var hash string
+	if ok {
+		hash = user.PasswordHash
+	}
+	s.mutex.RUnlock()
+
+	if !ok {
+		dummyHashOnce.Do(func() {
+			dummyHash, _ = bcrypt.GenerateFromPassword([]byte("dummy password"), bcrypt.DefaultCost)
+		})
+		bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+		return nil, ErrInvalidCredentials
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)); err != nil {
+		return nil, ErrInvalidCredentials
+	}
+	return s.GetUser(username)
+}
+
+// GetUser gets a user by username
+func (s *Storage) GetUser(username string) (*User, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	user, ok := s.users[username]
+	if !ok {
+		return nil, ErrUserNotFound
+	}
+	return user.copy(), nil
+}
+
+// UserByFeverKey finds the user a Fever api_key belongs to
+func (s *Storage) UserByFeverKey(key string) (*User, error) {
+	return s.findUser(func(user *User) string { return user.FeverKey }, strings.ToLower(key))
+}
+
+// UserByGReaderToken finds the user a Google Reader API token belongs to
+func (s *Storage) UserByGReaderToken(token string) (*User, error) {
+	return s.findUser(func(user *User) string { return user.GReaderToken }, token)
+}
+
+// Users returns all users, ordered by username
+func (s *Storage) Users() []*User {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	users := make([]*User, 0, len(s.users))
+	for _, user := range s.users {
+		users = append(users, user.copy())
+	}
+	sort.Slice(users, func(i, j int) bool {
+		return users[i].Username < users[j].Username
+	})
+	return users
+}
+
+// HasUsers returns true if at least one account exists
+func (s *Storage) HasUsers() bool {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return len(s.users) > 0
+}
+
+// HasUser returns true if an account with the username exists
+func (s *Storage) HasUser(username string) bool {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	_, ok := s.users[username]
+	return ok
+}
+
+// Subscribe subscribes a user to a feed, adding it to the shared storage if needed.
+// The feed's Folder is used as the user's folder.
+func (s *Storage) Subscribe(username string, feed *Feed) error {
+	if feed == nil {
+		return errors.New("feed cannot be nil")
+	}
+
+	s.mutex.RLock()
+	_, ok := s.users[username]
+	s.mutex.RUnlock()
+	if !ok {
+		return ErrUserNotFound
+	}
+
+	folder := feed.Folder
+	shared := *feed
+	shared.Folder = ""
+	if err := s.AddFeed(&shared); err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	user, ok := s.users[username]
+	if !ok {
+		return ErrUserNotFound
+	}
+	if subscription, ok := user.Subscriptions[feed.URL]; ok {
+		if folder != "" {
+			subscription.Folder = folder
+		}
+	} else {
+		user.Subscriptions[feed.URL] = &Subscription{
+			URL:     feed.URL,
+			Folder:  folder,
+			AddedAt: time.Now(),
+		}
+	}
+	s.saveNeeded = true
+	s.autoSaveLocked()
+	return nil
+}
+
+// Unsubscribe removes a user's subscription. The shared feed is removed as well
+// when nobody subscribes to it anymore, in which case true is returned.
+func (s *Storage) Unsubscribe(username, url string) (bool, error) {
+	s.mutex.Lock()
+	user, ok := s.users[username]
+	if !ok {
+		s.mutex.Unlock()
+		return false, ErrUserNotFound
+	}
+	if _, ok := user.Subscriptions[url]; !ok {
+		s.mutex.Unlock()
+		return false, errors.New("feed not found")
+	}
+	delete(user.Subscriptions, url)
+	s.saveNeeded = true
+
+	orphaned := true
+	for _, other := range s.users {
+		if _, ok := other.Subscriptions[url]; ok {
+			orphaned = false
+			break
+		}
+	}
+	if !orphaned {
+		s.autoSaveLocked()
+	}
+	s.mutex.Unlock()
+
+	if orphaned {
+		return true, s.RemoveFeed(url)
+	}
+	return false, nil
+}
+
+// IsSubscribed returns true if a user subscribes to a feed
+func (s *Storage) IsSubscribed(username, url string) bool {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	user, ok := s.users[username]
+	if !ok {
+		return false
+	}
+	_, ok = user.Subscriptions[url]
+	return ok
+}
+
+// Subscriptions returns the feeds a user subscribes to (without their content),
+// with Folder set to the user's folder
+func (s *Storage) Subscriptions(username string) []*Feed {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	user, ok := s.users[username]
+	if !ok {
+		return []*Feed{}
+	}
+
+	feeds := make([]*Feed, 0, len(user.Subscriptions))
+	for url, subscription := range user.Subscriptions {
+		feed, ok := s.feeds[url]
+		if !ok {
+			continue
+		}
+		feedCopy := *feed
+		feedCopy.Folder = subscription.Folder
+		feedCopy.Items = nil
+		feeds = append(feeds, &feedCopy)
+	}
+
+	sort.Slice(feeds, func(i, j int) bool {
+		return feeds[i].ID < feeds[j].ID
+	})
+	return feeds
+}
+
+// SetFolder moves a user's subscription into a folder; an empty folder removes it from its folder
+func (s *Storage) SetFolder(username, url, folder string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	user, ok := s.users[username]
+	if !ok {
+		return ErrUserNotFound
+	}
+	subscription, ok := user.Subscriptions[url]
+	if !ok {
+		return errors.New("feed not found")
+	}
+
+	subscription.Folder = folder
+	s.saveNeeded = true
+	s.autoSaveLocked()
+	return nil
+}
+
+// findUser finds a user by comparing a secret attribute in constant time
+func (s *Storage) findUser(attribute func(*User) string, value string) (*User, error) {
+	if value == "" {
+		return nil, ErrUserNotFound
+	}
+
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	for _, user := range s.users {
+		if subtle.ConstantTimeCompare([]byte(attribute(user)), []byte(value)) == 1 {
+			return user.copy(), nil
+		}
+	}
+	return nil, ErrUserNotFound
+}
+
+// adoptLegacyState gives a user all feeds and item state stored before accounts existed.
+// The caller must hold the mutex.
+func (s *Storage) adoptLegacyState(user *User) {
+	for url, feed := range s.feeds {
+		user.Subscriptions[url] = &Subscription{
+			URL:     url,
+			Folder:  feed.Folder,
+			AddedAt: feed.UpdatedAt,
+		}
+		feed.Folder = ""
+	}
+	for id := range s.legacyRead {
+		user.Read[id] = true
+	}
+	for id := range s.legacySaved {
+		user.Saved[id] = true
+	}
+	s.legacyRead = make(map[int64]bool)
+	s.legacySaved = make(map[int64]bool)
+
+	if len(user.Subscriptions) > 0 {
+		log.Printf("User %s adopted %d existing subscriptions", user.Username, len(user.Subscriptions))
+	}
+}
+
+// autoSaveLocked asks the save worker to write changes soon.
+// The caller must hold the mutex.
+func (s *Storage) autoSaveLocked() {
+	s.requestSave()
+}
+
+// loadUsers loads accounts from the users file. The caller must hold the mutex.
+func (s *Storage) loadUsers() error {
+	if s.usersFilePath == "" {
+		return nil
+	}
+
+	data, err := ioutil.ReadFile(s.usersFilePath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var stored []userData
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return err
+	}
+
+	s.users = make(map[string]*User, len(stored))
+	for _, data := range stored {
+		user := &User{
+			Username:      data.Username,
+			PasswordHash:  data.PasswordHash,
+			FeverKey:      data.FeverKey,
+			GReaderToken:  data.GReaderToken,
+			Admin:         data.Admin,
+			CreatedAt:     data.CreatedAt,
+			Subscriptions: make(map[string]*Subscription, len(data.Subscriptions)),
+			Read:          make(map[int64]bool, len(data.Read)),
+			Saved:         make(map[int64]bool, len(data.Saved)),
+			Positions:     make(map[int64]float64, len(data.Positions)),
+		}
+		for _, subscription := range data.Subscriptions {
+			user.Subscriptions[subscription.URL] = subscription
+		}
+		for _, id := range data.Read {
+			user.Read[id] = true
+		}
+		for _, id := range data.Saved {
+			user.Saved[id] = true
+		}
+		for id, position := range data.Positions {
+			user.Positions[id] = position
+		}
+		s.users[user.Username] = user
+	}
+
+	log.Printf("Loaded %d users from %s", len(s.users), s.usersFilePath)
+	return nil
+}
+
+// marshalUsers encodes accounts for saving. The caller must hold the mutex.
+func (s *Storage) marshalUsers() ([]byte, error) {
+	if s.usersFilePath == "" {
+		return nil, nil
+	}
+
+	// Only keep state for items that are still cached
+	cached := make(map[int64]bool)
+	for _, feed := range s.feeds {
+		for _, item := range feed.Items {
+			cached[item.ID] = true
+		}
+	}
+
+	stored := make([]userData, 0, len(s.users))
+	for _, user := range s.users {
+		data := userData{
+			Username:      user.Username,
+			PasswordHash:  user.PasswordHash,
+			FeverKey:      user.FeverKey,
+			GReaderToken:  user.GReaderToken,
+			Admin:         user.Admin,
+			CreatedAt:     user.CreatedAt,
+			Subscriptions: make([]*Subscription, 0, len(user.Subscriptions)),
+			Read:          make([]int64, 0, len(user.Read)),
+			Saved:         make([]int64, 0, len(user.Saved)),
+			Positions:     make(map[int64]float64, len(user.Positions)),
+		}
+		for _, subscription := range user.Subscriptions {
+			data.Subscriptions = append(data.Subscriptions, subscription)
+		}
+		for id := range user.Read {
+			if cached[id] {
+				data.Read = append(data.Read, id)
+			}
+		}
+		for id := range user.Saved {
+			if cached[id] {
+				data.Saved = append(data.Saved, id)
+			}
+		}
+		for id, position := range user.Positions {
+			if cached[id] {
+				data.Positions[id] = position
+			}
+		}
+		stored = append(stored, data)
+	}
+
+	return json.MarshalIndent(stored, "", "  ")
+}
+
+// copy returns a deep copy of the user
+func (u *User) copy() *User {
+	userCopy := *u
+	userCopy.Subscriptions = make(map[string]*Subscription, len(u.Subscriptions))
+	for url, subscription := range u.Subscriptions {
+		subscriptionCopy := *subscription
+		userCopy.Subscriptions[url] = &subscriptionCopy
+	}
+	userCopy.Read = make(map[int64]bool, len(u.Read))
+	for id := range u.Read {
+		userCopy.Read[id] = true
+	}
+	userCopy.Saved = make(map[int64]bool, len(u.Saved))
+	for id := range u.Saved {
+		userCopy.Saved[id] = true
+	}
+	userCopy.Positions = make(map[int64]float64, len(u.Positions))
+	for id, position := range u.Positions {
+		userCopy.Positions[id] = position
+	}
+	return &userCopy
+}
+
+// feverKey returns the Fever api_key for a username and password
+func feverKey(username, password string) string {
+	sum := md5.Sum([]byte(username + ":" + password))
+	return hex.EncodeToString(sum[:])
+}
+
+// randomHex returns a random hex string of n bytes
+func randomHex(n int) (string, error) {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
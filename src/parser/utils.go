@@ -2,6 +2,8 @@ package parser
 
 import (
 	"strings"
+
+	"golang.org/x/net/html"
 )
 
 // SplitURLs splits a comma-separated string of URLs into a slice
@@ -28,3 +30,59 @@ func SplitURLs(urlsStr string) []string {
 
 	return filtered
 }
+
+// PlainText converts an HTML fragment into plain text, keeping paragraph breaks
+func PlainText(content string) string {
+	tokenizer := html.NewTokenizer(strings.NewReader(content))
+
+	var builder strings.Builder
+	skip := 0
+	for {
+		switch tokenizer.Next() {
+		case html.ErrorToken:
+			return collapseWhitespace(builder.String())
+		case html.TextToken:
+			if skip == 0 {
+				builder.Write(tokenizer.Text())
+			}
+		case html.StartTagToken, html.SelfClosingTagToken:
+			name, _ := tokenizer.TagName()
+			switch string(name) {
+			case "script", "style":
+				skip++
+			case "br", "p", "div", "li", "tr", "h1", "h2", "h3", "h4", "h5", "h6", "blockquote":
+				builder.WriteString("\n")
+			}
+		case html.EndTagToken:
+			name, _ := tokenizer.TagName()
+			switch string(name) {
+			case "script", "style":
+				if skip > 0 {
+					skip--
+				}
+			case "p", "div", "li", "tr", "h1", "h2", "h3", "h4", "h5", "h6", "blockquote":
+				builder.WriteString("\n")
+			}
+		}
+	}
+}
+
+// collapseWhitespace squeezes runs of spaces and blank lines
+func collapseWhitespace(text string) string {
+	lines := strings.Split(text, "\n")
+	result := make([]string, 0, len(lines))
+	blank := false
+	for _, line := range lines {
+		line = strings.Join(strings.Fields(line), " ")
+		if line == "" {
+			if !blank && len(result) > 0 {
+				result = append(result, "")
+			}
+			blank = true
+			continue
+		}
+		result = append(result, line)
+		blank = false
+	}
+	return strings.TrimSpace(strings.Join(result, "\n"))
+}
@@ -0,0 +1,126 @@
+package scheduler
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+// Job represents a task that runs periodically
+type Job struct {
+	Name     string
+	Interval time.Duration
+	Run      func() error
+}
+
+// Scheduler runs registered jobs on their intervals in the background
+type Scheduler struct {
+	jobs    []Job
+	mutex   sync.RWMutex
+	running bool
+	stop    chan struct{}
+	wg      sync.WaitGroup
+}
+
+// NewScheduler creates a new scheduler instance
+func NewScheduler() *Scheduler {
+	return &Scheduler{}
+}
+
+// Add registers a job that runs every interval once the scheduler is started
+func (s *Scheduler) Add(name string, interval time.Duration, run func() error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	job := Job{Name: name, Interval: interval, Run: run}
+	s.jobs = append(s.jobs, job)
+
+	// Jobs added after Start are launched immediately
+	if s.running {
+		s.launch(job)
+	}
+}
+
+// Start starts running all registered jobs
+func (s *Scheduler) Start() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.running {
+		return
+	}
+	s.running = true
+	s.stop = make(chan struct{})
+
+	for _, job := range s.jobs {
+		s.launch(job)
+	}
+	log.Printf("Scheduler started with %d jobs", len(s.jobs))
+}
+
+// Stop stops all jobs and waits for running ones to finish
+func (s *Scheduler) Stop() {
+	s.mutex.Lock()
+	if !s.running {
+		s.mutex.Unlock()
+		return
+	}
+	s.running = false
+	close(s.stop)
+	s.mutex.Unlock()
+
+	s.wg.Wait()
+	log.Println("Scheduler stopped")
+}
+
+// Shutdown stops all jobs like Stop, but gives up waiting for running ones when ctx is done
+func (s *Scheduler) Shutdown(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		s.Stop()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Running returns true if the scheduler has been started and not stopped
+func (s *Scheduler) Running() bool {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.running
+}
+
+// launch runs a job in its own goroutine until the scheduler stops
+func (s *Scheduler) launch(job Job) {
+	if job.Interval <= 0 {
+		log.Printf("Skipping job %s: interval must be positive", job.Name)
+		return
+	}
+
+	stop := s.stop
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+
+		ticker := time.NewTicker(job.Interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				if err := job.Run(); err != nil {
+					log.Printf("Job %s failed: %v", job.Name, err)
+				}
+			}
+		}
+	}()
+}
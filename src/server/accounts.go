@@ -0,0 +1,105 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// loginPage shows the login form, or the registration form before the first account exists
+func (s *Server) loginPage(c *gin.Context) {
+	if !s.storage.HasUsers() {
+		c.Redirect(http.StatusFound, "/register")
+		return
+	}
+	s.renderLogin(c, http.StatusOK, false, "", "")
+}
+
+// login starts a session for valid credentials
+func (s *Server) login(c *gin.Context) {
+	username := strings.TrimSpace(c.PostForm("username"))
+	user, err := s.storage.Authenticate(username, c.PostForm("password"))
+	if err != nil {
+		s.renderLogin(c, http.StatusUnauthorized, false, username, err.Error())
+		return
+	}
+	s.startSession(c, user.Username)
+}
+
+// registerPage shows the registration form if accounts can be created
+func (s *Server) registerPage(c *gin.Context) {
+	if !s.canRegister() {
+		c.Redirect(http.StatusFound, "/login")
+		return
+	}
+	s.renderLogin(c, http.StatusOK, true, "", "")
+}
+
+// register creates an account and logs it in
+func (s *Server) register(c *gin.Context) {
+	if !s.canRegister() {
+		s.renderLogin(c, http.StatusForbidden, false, "", "registration is disabled")
+		return
+	}
+
+	username := strings.TrimSpace(c.PostForm("username"))
+	user, err := s.storage.CreateUser(username, c.PostForm("password"))
+	if err != nil {
+		s.renderLogin(c, http.StatusBadRequest, true, username, err.Error())
+		return
+	}
+	s.startSession(c, user.Username)
+}
+
+// logout ends the current session
+func (s *Server) logout(c *gin.Context) {
+	if id, err := c.Cookie(sessionCookie); err == nil {
+		s.sessions.Delete(id)
+	}
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(sessionCookie, "", -1, "/", "", s.secureCookies(), true)
+	c.Redirect(http.StatusSeeOther, "/login")
+}
+
+// startSession sets the session cookie and sends the browser home
+func (s *Server) startSession(c *gin.Context, username string) {
+	id, err := s.sessions.Create(username)
+	if err != nil {
+		s.renderLogin(c, http.StatusInternalServerError, false, username, err.Error())
+		return
+	}
+
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(sessionCookie, id, int(s.sessions.MaxAge().Seconds()), "/", "", s.secureCookies(), true)
+	c.Redirect(http.StatusSeeOther, "/")
+}
+
+// renderLogin renders the login or registration form
+func (s *Server) renderLogin(c *gin.Context, status int, register bool, username, message string) {
+	title := "Log in - RSS Reader"
+	if register {
+		title = "Create account - RSS Reader"
+	}
+	c.HTML(status, "login.html", gin.H{
+		"title":    title,
+		"register": register,
+		"signup":   s.canRegister(),
+		"hasUsers": s.storage.HasUsers(),
+		"username": username,
+		"error":    message,
+	})
+}
+
+// canRegister returns true if new accounts may be created. The first account
+// can always be created; later ones only when signup is allowed. Nobody may
+// register while stored accounts couldn't be loaded, or the first visitor would
+// become the admin.
+func (s *Server) canRegister() bool {
+	return s.storage.Loaded() && (s.allowSignup || !s.storage.HasUsers())
+}
+
+// secureCookies returns true if the server is reached over HTTPS
+func (s *Server) secureCookies() bool {
+	return strings.HasPrefix(s.baseURL, "https://")
+}
@@ -0,0 +1,117 @@
+package server
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// archivedEpisode serves a downloaded episode, supporting range requests so players can seek
+func (s *Server) archivedEpisode(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid episode ID"})
+		return
+	}
+	if s.archive == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "episode not archived"})
+		return
+	}
+
+	file, ok := s.archive.File(id)
+	if !ok || !s.storage.IsSubscribed(currentUser(c), file.FeedURL) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "episode not archived"})
+		return
+	}
+
+	f, err := os.Open(s.archive.Path(file))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "episode not archived"})
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	contentType := file.Type
+	if contentType == "" {
+		contentType = mime.TypeByExtension(filepath.Ext(file.Path))
+	}
+	if contentType != "" {
+		c.Header("Content-Type", contentType)
+	}
+	c.Header("Cache-Control", "private, max-age=86400")
+	http.ServeContent(c.Writer, c.Request, filepath.Base(file.Path), info.ModTime(), f)
+}
+
+// setArchive turns downloading a feed's episodes on or off. The optional keep
+// field sets how many of the newest episodes are kept.
+func (s *Server) setArchive(c *gin.Context) {
+	feedURL := c.Query("url")
+	if feedURL == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "URL parameter is required"})
+		return
+	}
+	if !s.storage.IsSubscribed(currentUser(c), feedURL) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "feed not found"})
+		return
+	}
+
+	enabled, err := strconv.ParseBool(c.PostForm("enabled"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "enabled must be true or false"})
+		return
+	}
+	keep := 0
+	if value := c.PostForm("keep"); value != "" {
+		if keep, err = strconv.Atoi(value); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "keep must be a number of episodes"})
+			return
+		}
+	}
+	if err := s.storage.SetArchive(feedURL, enabled, keep); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Fetch the new episodes right away rather than at the next scheduled run.
+	// Downloads can take long, so they are interrupted on shutdown instead of awaited.
+	if enabled && s.archive != nil {
+		go func() {
+			if err := s.archive.Run(); err != nil {
+				gin.DefaultWriter.Write([]byte(fmt.Sprintf("Error archiving episodes: %v\n", err)))
+			}
+		}()
+	}
+
+	feed, err := s.storage.CachedFeed(feedURL)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if c.GetHeader("HX-Request") == "true" {
+		c.Header("Content-Type", "text/html")
+		c.String(http.StatusOK, archiveButtonHTML(feed))
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"url": feed.URL, "archive": feed.Archive, "keep": feed.ArchiveKeep})
+}
+
+// archivedURL returns where an archived copy of an episode is served, or "" if there is none
+func (s *Server) archivedURL(id int64) string {
+	if s.archive == nil {
+		return ""
+	}
+	if _, ok := s.archive.File(id); !ok {
+		return ""
+	}
+	return fmt.Sprintf("/archive/%d", id)
+}
@@ -0,0 +1,81 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/user/rss/src/auth"
+)
+
+// sessionCookie is the name of the cookie holding the session ID
+const sessionCookie = "rss_session"
+
+// Context keys set by the authentication middleware
+const (
+	userKey  = "user"
+	scopeKey = "scope"
+)
+
+// authenticate identifies the user by bearer API token or session cookie.
+// Browsers asking for the home page without a session are sent to the login page.
+func (s *Server) authenticate(c *gin.Context) {
+	if header := c.GetHeader("Authorization"); strings.HasPrefix(header, "Bearer ") {
+		token, ok := s.tokens.Authenticate(strings.TrimPrefix(header, "Bearer "))
+		if !ok || !s.storage.HasUser(token.Username) {
+			c.Header("WWW-Authenticate", `Bearer error="invalid_token"`)
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid API token"})
+			return
+		}
+		c.Set(userKey, token.Username)
+		c.Set(scopeKey, token.Scope)
+		c.Next()
+		return
+	}
+
+	id, _ := c.Cookie(sessionCookie)
+	session, ok := s.sessions.Get(id)
+	if ok && s.storage.HasUser(session.Username) {
+		// A logged in browser acts with the full rights of its account
+		c.Set(userKey, session.Username)
+		c.Set(scopeKey, auth.ScopeAdmin)
+		c.Set(csrfKey, session.CSRFToken)
+		c.Next()
+		return
+	}
+
+	if c.Request.Method == http.MethodGet && c.Request.URL.Path == "/" {
+		c.Redirect(http.StatusFound, "/login")
+		c.Abort()
+		return
+	}
+	c.Header("WWW-Authenticate", "Bearer")
+	c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "login or API token required"})
+}
+
+// requireScope rejects requests whose credentials don't include a scope
+func (s *Server) requireScope(scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !auth.Allows(c.GetString(scopeKey), scope) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": fmt.Sprintf("this request requires the %s scope", scope)})
+			return
+		}
+		c.Next()
+	}
+}
+
+// requireAdmin rejects requests from accounts that aren't administrators
+func (s *Server) requireAdmin(c *gin.Context) {
+	user, err := s.storage.GetUser(currentUser(c))
+	if err != nil || !user.Admin {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "administrator access required"})
+		return
+	}
+	c.Next()
+}
+
+// currentUser returns the username set by the authentication middleware
+func currentUser(c *gin.Context) string {
+	return c.GetString(userKey)
+}
@@ -0,0 +1,71 @@
+package server
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/user/rss/src/auth"
+)
+
+// authRouter guards a read, a write and an admin route the way NewServer does
+func authRouter(s *Server) *gin.Engine {
+	ok := func(c *gin.Context) { c.Status(http.StatusNoContent) }
+	router := gin.New()
+	authenticated := router.Group("/", s.authenticate, s.verifyCSRF)
+	authenticated.GET("/feeds", s.requireScope(auth.ScopeRead), ok)
+	authenticated.POST("/feeds", s.requireScope(auth.ScopeWrite), ok)
+	authenticated.POST("/tokens", s.requireScope(auth.ScopeAdmin), ok)
+	return router
+}
+
+// bearer returns the Authorization header for an API token of alice with a scope
+func bearer(t *testing.T, s *Server, scope string) http.Header {
+	t.Helper()
+	token, _, err := s.tokens.Create("alice", scope+" client", scope)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return http.Header{"Authorization": {"Bearer " + token}}
+}
+
+func TestTokenScopes(t *testing.T) {
+	s := newTestServer(t)
+	router := authRouter(s)
+
+	tests := []struct {
+		scope               string
+		read, write, manage int
+	}{
+		{auth.ScopeRead, http.StatusNoContent, http.StatusForbidden, http.StatusForbidden},
+		{auth.ScopeWrite, http.StatusNoContent, http.StatusNoContent, http.StatusForbidden},
+		{auth.ScopeAdmin, http.StatusNoContent, http.StatusNoContent, http.StatusNoContent},
+	}
+	for _, test := range tests {
+		header := bearer(t, s, test.scope)
+		if w := request(router, http.MethodGet, "/feeds", "", header); w.Code != test.read {
+			t.Errorf("%s token reading: status = %d, want %d", test.scope, w.Code, test.read)
+		}
+		if w := request(router, http.MethodPost, "/feeds", "", header); w.Code != test.write {
+			t.Errorf("%s token writing: status = %d, want %d", test.scope, w.Code, test.write)
+		}
+		if w := request(router, http.MethodPost, "/tokens", "", header); w.Code != test.manage {
+			t.Errorf("%s token managing tokens: status = %d, want %d", test.scope, w.Code, test.manage)
+		}
+	}
+
+	for _, header := range []http.Header{nil, {"Authorization": {"Bearer rss_invalid"}}} {
+		if w := request(router, http.MethodGet, "/feeds", "", header); w.Code != http.StatusUnauthorized {
+			t.Errorf("%v: status = %d, want 401", header, w.Code)
+		}
+	}
+
+	// Revoked tokens stop working
+	header := bearer(t, s, auth.ScopeRead)
+	for _, token := range s.tokens.List("alice") {
+		s.tokens.Revoke("alice", token.ID)
+	}
+	if w := request(router, http.MethodGet, "/feeds", "", header); w.Code != http.StatusUnauthorized {
+		t.Errorf("revoked token: status = %d, want 401", w.Code)
+	}
+}
@@ -0,0 +1,245 @@
+package server
+
+import (
+	"fmt"
+	"html/template"
+	"net/url"
+	"strings"
+
+	"github.com/user/rss/src/parser"
+)
+
+// feedHeaderHTML renders the feed's image, title, site link and language above its items,
+// and the switch for full articles. Feeds without an image show their stored icon.
+// Feeds with episodes get a switch to archive them when archiving is available.
+func feedHeaderHTML(feed *parser.Feed, icon string, archivable bool) string {
+	image := safeURL(feed.ImageURL)
+	if image == "" {
+		image = icon
+	}
+	if image == "" {
+		image = safeURL(feed.IconURL)
+	}
+
+	var header strings.Builder
+	header.WriteString(`<header class="flex items-center gap-4 mb-6 pb-4 border-b border-dark-border">`)
+	if image != "" {
+		header.WriteString(fmt.Sprintf(`<img src="%s" alt="" loading="lazy" class="w-12 h-12 rounded object-contain bg-dark-hover flex-shrink-0">`,
+			template.HTMLEscaper(image)))
+	}
+	header.WriteString(`<div class="min-w-0 flex-1">`)
+	header.WriteString(fmt.Sprintf(`<h2 class="text-2xl font-bold text-dark-text truncate">%s</h2>`, template.HTMLEscaper(feed.Title)))
+	header.WriteString(`<div class="flex items-center gap-3 text-sm text-dark-text-secondary">`)
+	if link := safeURL(feed.Link); link != "" {
+		header.WriteString(fmt.Sprintf(`<a href="%s" target="_blank" rel="noopener noreferrer" class="hover:text-blue-400 truncate"><i class="bi bi-globe mr-1"></i>%s</a>`,
+			template.HTMLEscaper(link), template.HTMLEscaper(hostname(link))))
+	}
+	if feed.Language != "" {
+		header.WriteString(fmt.Sprintf(`<span><i class="bi bi-translate mr-1"></i>%s</span>`, template.HTMLEscaper(feed.Language)))
+	}
+	header.WriteString(`</div></div>`)
+	header.WriteString(`<div class="flex items-center gap-2 flex-shrink-0">`)
+	header.WriteString(fullContentButtonHTML(feed))
+	if archivable && hasEpisodes(feed) {
+		header.WriteString(archiveButtonHTML(feed))
+	}
+	header.WriteString(`</div></header>`)
+	return header.String()
+}
+
+// fullContentButtonHTML renders the switch that turns fetching full articles on or off
+func fullContentButtonHTML(feed *parser.Feed) string {
+	label, class, title := "Full articles", "border-dark-border text-dark-text-secondary", "Download the full article for items that only have a teaser"
+	if feed.FullContent {
+		label, class, title = "Full articles", "border-green-700 text-green-400", "Full articles are downloaded from each item's page"
+	}
+	return fmt.Sprintf(`<button hx-put="/feed/full-content?url=%s" hx-vals='{"enabled": "%t"}' hx-swap="outerHTML" title="%s"
+		class="flex items-center text-sm px-3 py-1.5 rounded border hover:bg-dark-hover transition-colors %s">
+		<i class="bi bi-file-earmark-text mr-2"></i>%s</button>`,
+		template.HTMLEscaper(url.QueryEscape(feed.URL)), !feed.FullContent, title, class, label)
+}
+
+// archiveButtonHTML renders the switch that turns downloading a feed's episodes on or off
+func archiveButtonHTML(feed *parser.Feed) string {
+	label, class, title := "Archive episodes", "border-dark-border text-dark-text-secondary", "Download new episodes to the server"
+	if feed.Archive {
+		label, class, title = "Archiving", "border-green-700 text-green-400", "Episodes are downloaded to the server"
+	}
+	return fmt.Sprintf(`<button hx-put="/feed/archive?url=%s" hx-vals='{"enabled": "%t"}' hx-swap="outerHTML" title="%s"
+		class="flex items-center text-sm px-3 py-1.5 rounded border hover:bg-dark-hover transition-colors %s">
+		<i class="bi bi-archive mr-2"></i>%s</button>`,
+		template.HTMLEscaper(url.QueryEscape(feed.URL)), !feed.Archive, title, class, label)
+}
+
+// hasEpisodes returns true if any item of a feed has an audio or video enclosure
+func hasEpisodes(feed *parser.Feed) bool {
+	for _, item := range feed.Items {
+		if _, ok := item.MediaEnclosure(); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// itemMetaHTML renders the date, authors and categories of an item
+func itemMetaHTML(item parser.FeedItem) string {
+	var meta strings.Builder
+	meta.WriteString(`<div class="flex flex-wrap items-center gap-x-4 gap-y-2 text-dark-text-secondary text-sm mb-4">`)
+	meta.WriteString(fmt.Sprintf(`<span><i class="bi bi-calendar mr-2 text-blue-400"></i>%s</span>`,
+		item.PublishedAt.Format("January 2, 2006 at 3:04 PM")))
+	if item.UpdatedAt.After(item.PublishedAt) {
+		meta.WriteString(fmt.Sprintf(`<span title="Updated"><i class="bi bi-arrow-repeat mr-1"></i>%s</span>`,
+			item.UpdatedAt.Format("January 2, 2006")))
+	}
+	if podcast := item.Podcast; podcast != nil {
+		if podcast.Season > 0 || podcast.Episode > 0 {
+			meta.WriteString(fmt.Sprintf(`<span><i class="bi bi-collection-play mr-1"></i>%s</span>`, episodeNumber(podcast)))
+		}
+		if podcast.Duration > 0 {
+			meta.WriteString(fmt.Sprintf(`<span><i class="bi bi-clock mr-1"></i>%s</span>`, formatDuration(float64(podcast.Duration))))
+		}
+		if podcast.Explicit {
+			meta.WriteString(`<span class="px-2 py-0.5 rounded bg-red-900 text-red-200 text-xs font-semibold">EXPLICIT</span>`)
+		}
+	}
+	if authors := item.AuthorNames(); authors != "" {
+		meta.WriteString(fmt.Sprintf(`<span><i class="bi bi-person mr-1"></i>%s</span>`, template.HTMLEscaper(authors)))
+	}
+	for _, category := range item.Categories {
+		meta.WriteString(fmt.Sprintf(`<span class="px-2 py-0.5 rounded-full bg-dark-hover text-xs">%s</span>`, template.HTMLEscaper(category)))
+	}
+	meta.WriteString(`</div>`)
+	return meta.String()
+}
+
+// itemImageHTML renders an item's image unless its content already shows it.
+// Episodes show their artwork next to the player instead.
+func itemImageHTML(item parser.FeedItem, content string) string {
+	if _, ok := item.MediaEnclosure(); ok {
+		return ""
+	}
+	image := safeURL(item.ImageURL)
+	if image == "" || strings.Contains(content, item.ImageURL) {
+		return ""
+	}
+	return fmt.Sprintf(`<img src="%s" alt="" loading="lazy" class="w-full max-h-96 object-cover rounded mb-4">`, template.HTMLEscaper(image))
+}
+
+// mediaPlayerHTML renders an audio or video player for an item's episode, which
+// resumes at the given position and reports progress back to the server.
+// An archived copy is played from local instead of the enclosure's URL.
+func mediaPlayerHTML(item parser.FeedItem, position float64, local string) string {
+	media, ok := item.MediaEnclosure()
+	src := safeURL(media.URL)
+	if local != "" {
+		src = local
+	}
+	if !ok || src == "" {
+		return ""
+	}
+
+	tag := "audio"
+	class := "w-full"
+	if strings.HasPrefix(media.Type, "video/") {
+		tag = "video"
+		class = "w-full max-h-96 rounded bg-black"
+	}
+	player := fmt.Sprintf(`<%s controls preload="none" class="%s" data-episode="%d" data-position="%.0f"><source src="%s" type="%s"></%s>`,
+		tag, class, item.ID, position, template.HTMLEscaper(src), template.HTMLEscaper(media.Type), tag)
+
+	artwork := ""
+	image := item.ImageURL
+	if item.Podcast != nil && item.Podcast.ImageURL != "" {
+		image = item.Podcast.ImageURL
+	}
+	if image = safeURL(image); image != "" && tag == "audio" {
+		artwork = fmt.Sprintf(`<img src="%s" alt="" loading="lazy" class="w-16 h-16 rounded object-cover flex-shrink-0">`, template.HTMLEscaper(image))
+	}
+
+	resume := ""
+	if position > 0 {
+		resume = fmt.Sprintf(`<p class="text-xs text-dark-text-secondary mt-1">Resumes at %s</p>`, formatDuration(position))
+	}
+	return fmt.Sprintf(`<div class="flex items-center gap-4 mb-4">%s<div class="flex-1 min-w-0">%s%s</div></div>`, artwork, player, resume)
+}
+
+// episodeNumber formats the season and episode number like "S2 E5"
+func episodeNumber(podcast *parser.Podcast) string {
+	parts := make([]string, 0, 2)
+	if podcast.Season > 0 {
+		parts = append(parts, fmt.Sprintf("S%d", podcast.Season))
+	}
+	if podcast.Episode > 0 {
+		parts = append(parts, fmt.Sprintf("E%d", podcast.Episode))
+	}
+	return strings.Join(parts, " ")
+}
+
+// formatDuration formats seconds like "1:02:03" or "4:05"
+func formatDuration(seconds float64) string {
+	total := int(seconds)
+	hours, minutes, secs := total/3600, total/60%60, total%60
+	if hours > 0 {
+		return fmt.Sprintf("%d:%02d:%02d", hours, minutes, secs)
+	}
+	return fmt.Sprintf("%d:%02d", minutes, secs)
+}
+
+// enclosuresHTML renders download links for an item's enclosures
+func enclosuresHTML(item parser.FeedItem) string {
+	if len(item.Enclosures) == 0 {
+		return ""
+	}
+
+	var links strings.Builder
+	links.WriteString(`<div class="flex flex-wrap gap-2 mt-4">`)
+	for _, enclosure := range item.Enclosures {
+		href := safeURL(enclosure.URL)
+		if href == "" {
+			continue
+		}
+		label := enclosure.Type
+		if label == "" {
+			label = "attachment"
+		}
+		if enclosure.Length > 0 {
+			label += ", " + formatBytes(enclosure.Length)
+		}
+		links.WriteString(fmt.Sprintf(`<a href="%s" target="_blank" rel="noopener noreferrer" class="px-3 py-1 rounded border border-dark-border text-sm text-dark-text-secondary hover:text-blue-400 hover:border-blue-500"><i class="bi bi-paperclip mr-1"></i>%s</a>`,
+			template.HTMLEscaper(href), template.HTMLEscaper(label)))
+	}
+	links.WriteString(`</div>`)
+	return links.String()
+}
+
+// safeURL returns the URL if it is an absolute http(s) URL, so feeds can't inject scripts
+func safeURL(value string) string {
+	parsed, err := url.Parse(value)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") {
+		return ""
+	}
+	return value
+}
+
+// hostname returns the host of a URL for display
+func hostname(value string) string {
+	parsed, err := url.Parse(value)
+	if err != nil || parsed.Host == "" {
+		return value
+	}
+	return strings.TrimPrefix(parsed.Host, "www.")
+}
+
+// formatBytes formats a size like "12.3 MB"
+func formatBytes(size int64) string {
+	const unit = 1000
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(size)/float64(div), "kMGTPE"[exp])
+}
@@ -0,0 +1,45 @@
+package server
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/user/rss/src/auth"
+)
+
+func TestVerifyCSRF(t *testing.T) {
+	s := newTestServer(t)
+	router := authRouter(s)
+
+	id, err := s.sessions.Create("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	session, _ := s.sessions.Get(id)
+	cookie := http.Header{"Cookie": {sessionCookie + "=" + id}}
+	withHeader := func(token string) http.Header {
+		return http.Header{"Cookie": cookie["Cookie"], csrfHeader: {token}}
+	}
+
+	tests := []struct {
+		name   string
+		method string
+		body   string
+		header http.Header
+		want   int
+	}{
+		{"session without token", http.MethodPost, "", cookie, http.StatusForbidden},
+		{"session with wrong token", http.MethodPost, "", withHeader("wrong"), http.StatusForbidden},
+		{"session with wrong form token", http.MethodPost, url.Values{csrfField: {"wrong"}}.Encode(), cookie, http.StatusForbidden},
+		{"session with token in header", http.MethodPost, "", withHeader(session.CSRFToken), http.StatusNoContent},
+		{"session with token in form", http.MethodPost, url.Values{csrfField: {session.CSRFToken}}.Encode(), cookie, http.StatusNoContent},
+		{"session reading without token", http.MethodGet, "", cookie, http.StatusNoContent},
+		{"API token without CSRF token", http.MethodPost, "", bearer(t, s, auth.ScopeWrite), http.StatusNoContent},
+	}
+	for _, test := range tests {
+		if w := request(router, test.method, "/feeds", test.body, test.header); w.Code != test.want {
+			t.Errorf("%s: status = %d, want %d", test.name, w.Code, test.want)
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/user/rss/src/digest"
+)
+
+// previewDigest renders the current digest without sending it
+func (s *Server) previewDigest(c *gin.Context) {
+	if s.digest == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "digest is not configured"})
+		return
+	}
+
+	summary, err := s.digest.Preview()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	htmlBody, textBody, err := digest.Render(summary)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Use ?format=text to see the plain text alternative
+	if c.Query("format") == "text" {
+		c.Header("Content-Type", "text/plain; charset=utf-8")
+		c.String(http.StatusOK, textBody)
+		return
+	}
+
+	c.Header("Content-Type", "text/html; charset=utf-8")
+	c.String(http.StatusOK, htmlBody)
+}
+
+// sendDigest sends the digest immediately
+func (s *Server) sendDigest(c *gin.Context) {
+	if s.digest == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "digest is not configured"})
+		return
+	}
+
+	if err := s.digest.Send(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "sent"})
+}
@@ -0,0 +1,131 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/user/rss/src/parser"
+)
+
+// listEpisodes returns the user's podcast episodes as JSON, newest first
+func (s *Server) listEpisodes(c *gin.Context) {
+	episodes := s.storage.Episodes(currentUser(c))
+
+	list := make([]gin.H, 0, len(episodes))
+	for _, episode := range episodes {
+		entry := episodeJSON(episode)
+		if archived := s.archivedURL(episode.Item.ID); archived != "" {
+			entry["archived_url"] = archived
+		}
+		list = append(list, entry)
+	}
+	c.JSON(http.StatusOK, list)
+}
+
+// episodesView returns an HTML fragment with the user's episodes and their players
+func (s *Server) episodesView(c *gin.Context) {
+	episodes := s.storage.Episodes(currentUser(c))
+
+	var view strings.Builder
+	if len(episodes) == 0 {
+		view.WriteString(`
+		<div class="flex flex-col items-center justify-center h-96 text-center text-dark-text-secondary">
+			<i class="bi bi-mic text-6xl mb-4 text-purple-400 opacity-50"></i>
+			<p class="text-lg mb-2">No episodes yet</p>
+			<p class="text-sm opacity-75">Subscribe to a podcast feed to listen here</p>
+		</div>`)
+	} else {
+		view.WriteString(`<div class="max-w-4xl mx-auto space-y-4">`)
+		for _, episode := range episodes {
+			item := episode.Item
+			status := ""
+			if !episode.Read {
+				status = `<span class="w-2 h-2 rounded-full bg-blue-400 flex-shrink-0" title="New"></span>`
+			}
+			view.WriteString(fmt.Sprintf(`
+			<article class="bg-dark-card border border-dark-border rounded-lg p-5">
+				<div class="flex items-center gap-2 mb-1">
+					%s
+					<h3 class="text-lg font-semibold text-dark-text leading-tight flex-1 min-w-0">
+						<a href="%s" target="_blank" rel="noopener noreferrer" class="hover:text-blue-400">%s</a>
+					</h3>
+				</div>
+				<p class="flex items-center gap-2 text-sm text-dark-text-secondary mb-3">%s<span class="truncate">%s</span></p>
+				%s
+				%s
+			</article>`,
+				status,
+				template.HTMLEscaper(item.Link),
+				template.HTMLEscaper(item.Title),
+				feedIconHTML(s.iconURL(episode.FeedURL, episode.FeedID), "w-4 h-4"),
+				template.HTMLEscaper(episode.FeedTitle),
+				itemMetaHTML(item),
+				mediaPlayerHTML(item, episode.Position, s.archivedURL(item.ID)),
+			))
+		}
+		view.WriteString(`</div>`)
+	}
+
+	c.Header("Content-Type", "text/html")
+	c.String(http.StatusOK, s.proxyImages(view.String()))
+}
+
+// setPosition remembers how far into an episode the user has listened
+func (s *Server) setPosition(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid episode ID"})
+		return
+	}
+	position, err := strconv.ParseFloat(c.PostForm("position"), 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "position must be a number of seconds"})
+		return
+	}
+
+	position, err = s.storage.SetPosition(currentUser(c), id, position)
+	if errors.Is(err, parser.ErrInvalidPosition) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"id": id, "position": position})
+}
+
+// episodeJSON converts an episode to its API representation
+func episodeJSON(episode parser.Episode) gin.H {
+	item := episode.Item
+	result := gin.H{
+		"id":           item.ID,
+		"feed_id":      episode.FeedID,
+		"feed_url":     episode.FeedURL,
+		"feed_title":   episode.FeedTitle,
+		"title":        item.Title,
+		"link":         item.Link,
+		"published_at": item.PublishedAt,
+		"media": gin.H{
+			"url":    episode.Media.URL,
+			"type":   episode.Media.Type,
+			"length": episode.Media.Length,
+		},
+		"position": episode.Position,
+		"read":     episode.Read,
+		"saved":    episode.Saved,
+	}
+	if podcast := item.Podcast; podcast != nil {
+		result["duration"] = podcast.Duration
+		result["episode"] = podcast.Episode
+		result["season"] = podcast.Season
+		result["image"] = podcast.ImageURL
+		result["explicit"] = podcast.Explicit
+	}
+	return result
+}
@@ -0,0 +1,162 @@
+package server
+
+import (
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/feeds"
+	"github.com/user/rss/src/parser"
+)
+
+// exportContentTypes maps the supported export formats to their content types
+var exportContentTypes = map[string]string{
+	"rss":  "application/rss+xml",
+	"atom": "application/atom+xml",
+	"json": "application/feed+json",
+}
+
+// ExportContentType returns the content type of an export format, and false
+// if the format is not supported
+func ExportContentType(format string) (string, bool) {
+	contentType, ok := exportContentTypes[format]
+	return contentType, ok
+}
+
+// RenderFeed renders a feed as RSS, Atom or JSON Feed
+func RenderFeed(feed *parser.Feed, format string) (string, error) {
+	link := feed.Link
+	if link == "" {
+		link = feed.URL
+	}
+	exported := &feeds.Feed{
+		Title:       feed.Title,
+		Link:        &feeds.Link{Href: link},
+		Description: feed.Description,
+		Created:     feed.UpdatedAt,
+	}
+	if feed.ImageURL != "" {
+		exported.Image = &feeds.Image{Url: feed.ImageURL, Title: feed.Title, Link: link}
+	}
+
+	exported.Items = make([]*feeds.Item, 0, len(feed.Items))
+	for _, item := range feed.Items {
+		exportedItem := &feeds.Item{
+			Title:       item.Title,
+			Link:        &feeds.Link{Href: item.Link},
+			Description: item.Description,
+			Content:     item.Content,
+			Created:     item.PublishedAt,
+			Updated:     item.UpdatedAt,
+			Id:          item.GUID,
+		}
+		if len(item.Authors) > 0 {
+			exportedItem.Author = &feeds.Author{Name: item.Authors[0].Name, Email: item.Authors[0].Email}
+		}
+		if len(item.Enclosures) > 0 {
+			enclosure := item.Enclosures[0]
+			exportedItem.Enclosure = &feeds.Enclosure{
+				Url:    enclosure.URL,
+				Type:   enclosure.Type,
+				Length: strconv.FormatInt(enclosure.Length, 10),
+			}
+		}
+		exported.Items = append(exported.Items, exportedItem)
+	}
+
+	switch format {
+	case "atom":
+		return renderAtom(feed, exported)
+	case "json":
+		return renderJSON(feed, exported)
+	}
+	return renderRSS(feed, exported)
+}
+
+// renderRSS adds what the generic feed can't hold to the RSS document
+func renderRSS(feed *parser.Feed, exported *feeds.Feed) (string, error) {
+	rss := (&feeds.Rss{Feed: exported}).RssFeed()
+	rss.Language = feed.Language
+	for i, item := range feed.Items {
+		// gorilla/feeds holds a single category per RSS item
+		if len(item.Categories) > 0 {
+			rss.Items[i].Category = item.Categories[0]
+		}
+		// and drops enclosures without a known length
+		if rss.Items[i].Enclosure == nil && len(item.Enclosures) > 0 {
+			enclosure := item.Enclosures[0]
+			rss.Items[i].Enclosure = &feeds.RssEnclosure{
+				Url:    enclosure.URL,
+				Type:   enclosure.Type,
+				Length: strconv.FormatInt(enclosure.Length, 10),
+			}
+		}
+	}
+	return feeds.ToXML(rss)
+}
+
+// renderAtom adds what the generic feed can't hold to the Atom document
+func renderAtom(feed *parser.Feed, exported *feeds.Feed) (string, error) {
+	atom := (&feeds.Atom{Feed: exported}).AtomFeed()
+	atom.Icon = feed.IconURL
+	atom.Logo = feed.ImageURL
+	for i, item := range feed.Items {
+		entry := atom.Entries[i]
+		if !item.PublishedAt.IsZero() {
+			entry.Published = item.PublishedAt.Format(time.RFC3339)
+		}
+		if len(item.Authors) > 0 {
+			entry.Author = &feeds.AtomAuthor{AtomPerson: feeds.AtomPerson{Name: item.AuthorNames()}}
+		}
+		// Atom supports any number of enclosures
+		entry.Links = entry.Links[:1]
+		for _, enclosure := range item.Enclosures {
+			link := feeds.AtomLink{Href: enclosure.URL, Rel: "enclosure", Type: enclosure.Type}
+			if enclosure.Length > 0 {
+				link.Length = strconv.FormatInt(enclosure.Length, 10)
+			}
+			entry.Links = append(entry.Links, link)
+		}
+	}
+	return feeds.ToXML(atom)
+}
+
+// renderJSON adds what the generic feed can't hold to the JSON Feed document
+func renderJSON(feed *parser.Feed, exported *feeds.Feed) (string, error) {
+	jsonFeed := (&feeds.JSON{Feed: exported}).JSONFeed()
+	jsonFeed.Language = feed.Language
+	jsonFeed.FeedUrl = feed.URL
+	jsonFeed.Icon = feed.ImageURL
+	jsonFeed.Favicon = feed.IconURL
+	for i, item := range feed.Items {
+		jsonItem := jsonFeed.Items[i]
+		jsonItem.Image = item.ImageURL
+		jsonItem.Tags = item.Categories
+		jsonItem.Author, jsonItem.Authors = nil, nil
+		for _, author := range item.Authors {
+			jsonItem.Authors = append(jsonItem.Authors, &feeds.JSONAuthor{Name: author.Name})
+		}
+		jsonItem.Attachments = nil
+		for _, enclosure := range item.Enclosures {
+			attachment := feeds.JSONAttachment{Url: enclosure.URL, MIMEType: enclosure.Type}
+			if enclosure.Length <= math.MaxInt32 {
+				attachment.Size = int32(enclosure.Length)
+			}
+			jsonItem.Attachments = append(jsonItem.Attachments, attachment)
+		}
+	}
+	return jsonFeed.ToJSON()
+}
+
+// renderFeed renders a feed for export, advertising our hub if there is one
+func (s *Server) renderFeed(feed *parser.Feed, format string) (string, error) {
+	rendered, err := RenderFeed(feed, format)
+	if err != nil {
+		return "", err
+	}
+
+	if s.hubEnabled() {
+		rendered = addHubLinks(rendered, format, s.hub.URL(), s.topicURL(feed.URL))
+	}
+	return rendered, nil
+}
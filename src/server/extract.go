@@ -0,0 +1,44 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// setFullContent turns fetching the full article of a feed's items on or off.
+// Articles are downloaded the next time the feed is fetched.
+func (s *Server) setFullContent(c *gin.Context) {
+	feedURL := c.Query("url")
+	if feedURL == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "URL parameter is required"})
+		return
+	}
+	if !s.storage.IsSubscribed(currentUser(c), feedURL) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "feed not found"})
+		return
+	}
+
+	enabled, err := strconv.ParseBool(c.PostForm("enabled"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "enabled must be true or false"})
+		return
+	}
+	if err := s.storage.SetFullContent(feedURL, enabled); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	feed, err := s.storage.CachedFeed(feedURL)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if c.GetHeader("HX-Request") == "true" {
+		c.Header("Content-Type", "text/html")
+		c.String(http.StatusOK, fullContentButtonHTML(feed))
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"url": feed.URL, "full_content": feed.FullContent})
+}
@@ -0,0 +1,336 @@
+package server
+
+import (
+	"encoding/base64"
+	"hash/fnv"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/user/rss/src/parser"
+)
+
+// feverAPIVersion is the Fever API version we implement
+const feverAPIVersion = 3
+
+// feverMaxItems is the maximum number of items returned per request, as the API specifies
+const feverMaxItems = 50
+
+// fever handles all Fever API requests
+func (s *Server) fever(c *gin.Context) {
+	response := gin.H{
+		"api_version": feverAPIVersion,
+		"auth":        0,
+	}
+
+	username, ok := s.feverUser(c)
+	if !ok {
+		c.JSON(http.StatusOK, response)
+		return
+	}
+	response["auth"] = 1
+	response["last_refreshed_on_time"] = s.storage.LastRefresh().Unix()
+
+	// Write operations come first so the response reflects them
+	if param(c, "mark") != "" {
+		s.feverMark(c, username)
+	}
+
+	feeds := s.storage.Subscriptions(username)
+
+	if hasParam(c, "groups") {
+		response["groups"] = feverGroups(feeds)
+		response["feeds_groups"] = feverFeedsGroups(feeds)
+	}
+	if hasParam(c, "feeds") {
+		response["feeds"] = s.feverFeeds(feeds)
+		response["feeds_groups"] = feverFeedsGroups(feeds)
+	}
+	if hasParam(c, "favicons") {
+		response["favicons"] = s.feverFavicons(feeds)
+	}
+	if hasParam(c, "links") {
+		response["links"] = []gin.H{}
+	}
+	if hasParam(c, "items") {
+		items, total := s.feverItems(c, username)
+		response["items"] = items
+		response["total_items"] = total
+	}
+	if hasParam(c, "unread_item_ids") || hasParam(c, "saved_item_ids") {
+		var unread, saved []string
+		for _, stored := range s.storage.Items(username) {
+			id := strconv.FormatInt(stored.Item.ID, 10)
+			if !stored.Read {
+				unread = append(unread, id)
+			}
+			if stored.Saved {
+				saved = append(saved, id)
+			}
+		}
+		if hasParam(c, "unread_item_ids") {
+			response["unread_item_ids"] = strings.Join(unread, ",")
+		}
+		if hasParam(c, "saved_item_ids") {
+			response["saved_item_ids"] = strings.Join(saved, ",")
+		}
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// feverUser finds the user the api_key sent by the client belongs to
+func (s *Server) feverUser(c *gin.Context) (string, bool) {
+	apiKey := c.PostForm("api_key")
+	if apiKey == "" {
+		apiKey = c.Query("api_key")
+	}
+	user, err := s.storage.UserByFeverKey(apiKey)
+	if err != nil {
+		return "", false
+	}
+	return user.Username, true
+}
+
+// feverMark handles mark=item|feed|group requests
+func (s *Server) feverMark(c *gin.Context, username string) {
+	id, err := strconv.ParseInt(param(c, "id"), 10, 64)
+	if err != nil {
+		return
+	}
+	as := param(c, "as")
+
+	var before time.Time
+	if value, err := strconv.ParseInt(param(c, "before"), 10, 64); err == nil && value > 0 {
+		before = time.Unix(value, 0)
+	}
+
+	switch param(c, "mark") {
+	case "item":
+		ids := []int64{id}
+		switch as {
+		case "read":
+			s.storage.SetRead(username, ids, true)
+		case "unread":
+			s.storage.SetRead(username, ids, false)
+		case "saved":
+			s.storage.SetSaved(username, ids, true)
+		case "unsaved":
+			s.storage.SetSaved(username, ids, false)
+		}
+	case "feed":
+		if as != "read" {
+			return
+		}
+		if feed, ok := s.storage.FeedByID(username, id); ok {
+			s.storage.MarkFeedRead(username, feed.URL, before)
+		}
+	case "group":
+		if as != "read" {
+			return
+		}
+		// Group 0 is the "Kindling" super group containing all feeds
+		if id == 0 {
+			s.storage.MarkFolderRead(username, "", before)
+			return
+		}
+		for _, feed := range s.storage.Subscriptions(username) {
+			if feed.Folder != "" && feverGroupID(feed.Folder) == id {
+				s.storage.MarkFolderRead(username, feed.Folder, before)
+				return
+			}
+		}
+	}
+}
+
+// feverItems returns the items selected by since_id, max_id or with_ids
+func (s *Server) feverItems(c *gin.Context, username string) ([]gin.H, int) {
+	all := s.storage.Items(username)
+
+	selected := make([]parser.StoredItem, 0, feverMaxItems)
+	switch {
+	case param(c, "with_ids") != "":
+		wanted := make(map[int64]bool)
+		for _, value := range strings.Split(param(c, "with_ids"), ",") {
+			if id, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64); err == nil {
+				wanted[id] = true
+			}
+		}
+		for _, stored := range all {
+			if wanted[stored.Item.ID] && len(selected) < feverMaxItems {
+				selected = append(selected, stored)
+			}
+		}
+	case param(c, "max_id") != "":
+		maxID, _ := strconv.ParseInt(param(c, "max_id"), 10, 64)
+		// Walk backwards so the items closest to max_id are returned
+		for i := len(all) - 1; i >= 0 && len(selected) < feverMaxItems; i-- {
+			if all[i].Item.ID < maxID {
+				selected = append(selected, all[i])
+			}
+		}
+	default:
+		sinceID, _ := strconv.ParseInt(param(c, "since_id"), 10, 64)
+		for _, stored := range all {
+			if stored.Item.ID > sinceID && len(selected) < feverMaxItems {
+				selected = append(selected, stored)
+			}
+		}
+	}
+
+	items := make([]gin.H, 0, len(selected))
+	for _, stored := range selected {
+		item := stored.Item
+		content := item.Content
+		if content == "" {
+			content = item.Description
+		}
+		created := item.PublishedAt
+		if created.IsZero() {
+			created = time.Now()
+		}
+
+		items = append(items, gin.H{
+			"id":              item.ID,
+			"feed_id":         stored.FeedID,
+			"title":           item.Title,
+			"author":          item.AuthorNames(),
+			"html":            content,
+			"url":             item.Link,
+			"is_saved":        boolInt(stored.Saved),
+			"is_read":         boolInt(stored.Read),
+			"created_on_time": created.Unix(),
+		})
+	}
+	return items, len(all)
+}
+
+// feverFeeds converts feeds to the Fever representation
+func (s *Server) feverFeeds(feeds []*parser.Feed) []gin.H {
+	result := make([]gin.H, 0, len(feeds))
+	for _, feed := range feeds {
+		result = append(result, gin.H{
+			"id":                   feed.ID,
+			"favicon_id":           s.feverFaviconID(feed),
+			"title":                feed.Title,
+			"url":                  feed.URL,
+			"site_url":             feed.Link,
+			"is_spark":             0,
+			"last_updated_on_time": feed.UpdatedAt.Unix(),
+		})
+	}
+	return result
+}
+
+// feverFaviconID returns the ID of a feed's icon, which is the feed's ID, or 0 if
+// it has none
+func (s *Server) feverFaviconID(feed *parser.Feed) int64 {
+	if s.favicons == nil {
+		return 0
+	}
+	if _, ok := s.favicons.Icon(feed.URL); !ok {
+		return 0
+	}
+	return feed.ID
+}
+
+// feverFavicons returns the stored icons of feeds, with their data base64 encoded
+// behind its type like the Fever API specifies ("image/png;base64,...")
+func (s *Server) feverFavicons(feeds []*parser.Feed) []gin.H {
+	result := make([]gin.H, 0, len(feeds))
+	if s.favicons == nil {
+		return result
+	}
+	for _, feed := range feeds {
+		icon, ok := s.favicons.Icon(feed.URL)
+		if !ok {
+			continue
+		}
+		data, err := os.ReadFile(s.favicons.Path(icon))
+		if err != nil {
+			continue
+		}
+		result = append(result, gin.H{
+			"id":   feed.ID,
+			"data": icon.Type + ";base64," + base64.StdEncoding.EncodeToString(data),
+		})
+	}
+	return result
+}
+
+// feverGroups converts folders to Fever groups
+func feverGroups(feeds []*parser.Feed) []gin.H {
+	seen := make(map[string]bool)
+	groups := make([]gin.H, 0)
+	for _, feed := range feeds {
+		if feed.Folder == "" || seen[feed.Folder] {
+			continue
+		}
+		seen[feed.Folder] = true
+		groups = append(groups, gin.H{
+			"id":    feverGroupID(feed.Folder),
+			"title": feed.Folder,
+		})
+	}
+	return groups
+}
+
+// feverFeedsGroups lists the feed IDs of every group
+func feverFeedsGroups(feeds []*parser.Feed) []gin.H {
+	order := make([]string, 0)
+	members := make(map[string][]string)
+	for _, feed := range feeds {
+		if feed.Folder == "" {
+			continue
+		}
+		if _, ok := members[feed.Folder]; !ok {
+			order = append(order, feed.Folder)
+		}
+		members[feed.Folder] = append(members[feed.Folder], strconv.FormatInt(feed.ID, 10))
+	}
+
+	result := make([]gin.H, 0, len(order))
+	for _, folder := range order {
+		result = append(result, gin.H{
+			"group_id": feverGroupID(folder),
+			"feed_ids": strings.Join(members[folder], ","),
+		})
+	}
+	return result
+}
+
+// feverGroupID derives a stable group ID from a folder name
+func feverGroupID(folder string) int64 {
+	h := fnv.New32a()
+	h.Write([]byte(folder))
+	// Keep IDs positive and clear of the reserved group 0
+	return int64(h.Sum32()&0x7fffffff) + 1
+}
+
+// hasParam returns true if a parameter is present in the query string or form
+func hasParam(c *gin.Context, name string) bool {
+	if _, ok := c.GetQuery(name); ok {
+		return true
+	}
+	_, ok := c.GetPostForm(name)
+	return ok
+}
+
+// param returns a parameter from the query string or form
+func param(c *gin.Context, name string) string {
+	if value, ok := c.GetQuery(name); ok {
+		return value
+	}
+	return c.PostForm(name)
+}
+
+// boolInt converts a boolean to the 0/1 integers Fever uses
+func boolInt(value bool) int {
+	if value {
+		return 1
+	}
+	return 0
+}
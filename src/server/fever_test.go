@@ -0,0 +1,88 @@
+package server
+
+import (
+	"crypto/md5"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/user/rss/src/favicon"
+)
+
+// feverResponse holds the parts of a Fever API response the tests look at
+type feverResponse struct {
+	Auth  int `json:"auth"`
+	Feeds []struct {
+		ID        int64  `json:"id"`
+		FaviconID int64  `json:"favicon_id"`
+		SiteURL   string `json:"site_url"`
+	} `json:"feeds"`
+	Favicons []struct {
+		ID   int64  `json:"id"`
+		Data string `json:"data"`
+	} `json:"favicons"`
+}
+
+// feverRouter serves the Fever API of s
+func feverRouter(s *Server) *gin.Engine {
+	router := gin.New()
+	router.Any("/fever/", s.fever)
+	return router
+}
+
+func TestFeverRejectsBadKey(t *testing.T) {
+	router := feverRouter(newTestServer(t))
+
+	for _, body := range []string{"", "api_key=", "api_key=0123456789abcdef0123456789abcdef"} {
+		w := request(router, http.MethodPost, "/fever/?api&feeds", body, nil)
+		var response feverResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+			t.Fatal(err)
+		}
+		if response.Auth != 0 || response.Feeds != nil {
+			t.Errorf("%q: response = %s, want auth 0 without feeds", body, w.Body)
+		}
+	}
+}
+
+func TestFeverFeedsAndFavicons(t *testing.T) {
+	s := newTestServer(t)
+	feedURL := "https://example.com/feed.xml"
+
+	// An icon stored by an earlier run
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "example.png"), []byte("\x89PNG"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	state := `[{"feed_url": "` + feedURL + `", "path": "example.png", "type": "image/png"}]`
+	if err := os.WriteFile(filepath.Join(dir, "icons.json"), []byte(state), 0644); err != nil {
+		t.Fatal(err)
+	}
+	config := favicon.DefaultConfig()
+	config.Dir = dir
+	config.StateFile = filepath.Join(dir, "icons.json")
+	s.favicons = favicon.NewFavicons(s.storage, config)
+
+	apiKey := fmt.Sprintf("%x", md5.Sum([]byte("alice:secret123")))
+	w := request(feverRouter(s), http.MethodPost, "/fever/?api&feeds&favicons", "api_key="+apiKey, nil)
+	var response feverResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	if response.Auth != 1 {
+		t.Fatalf("response = %s, want auth 1", w.Body)
+	}
+	if len(response.Feeds) != 1 || response.Feeds[0].SiteURL != "https://example.com/" {
+		t.Fatalf("feeds = %+v, want the site of the feed", response.Feeds)
+	}
+	if len(response.Favicons) != 1 || response.Favicons[0].Data != "image/png;base64,iVBORw==" {
+		t.Fatalf("favicons = %+v, want the stored icon", response.Favicons)
+	}
+	if response.Feeds[0].FaviconID != response.Favicons[0].ID {
+		t.Errorf("feed has favicon %d, want %d", response.Feeds[0].FaviconID, response.Favicons[0].ID)
+	}
+}
@@ -0,0 +1,541 @@
+package server
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/user/rss/src/parser"
+)
+
+// Google Reader stream and tag identifiers
+const (
+	greaderReadingList = "user/-/state/com.google/reading-list"
+	greaderRead        = "user/-/state/com.google/read"
+	greaderStarred     = "user/-/state/com.google/starred"
+	greaderLabelPrefix = "user/-/label/"
+	greaderFeedPrefix  = "feed/"
+	greaderItemPrefix  = "tag:google.com,2005:reader/item/"
+)
+
+// greaderMaxItems caps the number of items returned per request
+const greaderMaxItems = 1000
+
+// greaderLogin handles ClientLogin with Email and Passwd
+func (s *Server) greaderLogin(c *gin.Context) {
+	user, err := s.storage.Authenticate(param(c, "Email"), param(c, "Passwd"))
+	if err != nil {
+		c.String(http.StatusUnauthorized, "Error=BadAuthentication")
+		return
+	}
+
+	token := user.GReaderToken
+	if c.Query("output") == "json" {
+		c.JSON(http.StatusOK, gin.H{"SID": token, "LSID": token, "Auth": token})
+		return
+	}
+	c.String(http.StatusOK, "SID=%s\nLSID=%s\nAuth=%s\n", token, token, token)
+}
+
+// greaderAuth rejects API requests without a valid GoogleLogin token
+func (s *Server) greaderAuth(c *gin.Context) {
+	header := c.GetHeader("Authorization")
+	token := strings.TrimPrefix(header, "GoogleLogin auth=")
+	user, err := s.storage.UserByGReaderToken(token)
+	if header == token || err != nil {
+		c.Header("Google-Bad-Token", "true")
+		c.String(http.StatusUnauthorized, "Unauthorized")
+		c.Abort()
+		return
+	}
+	c.Set(userKey, user.Username)
+	c.Next()
+}
+
+// greaderWriteToken returns the token clients send as T with write requests
+func (s *Server) greaderWriteToken(c *gin.Context) {
+	user, err := s.storage.GetUser(currentUser(c))
+	if err != nil {
+		c.String(http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	c.String(http.StatusOK, writeToken(user.GReaderToken))
+}
+
+// greaderWriteAuth rejects write requests without the user's write token in T
+func (s *Server) greaderWriteAuth(c *gin.Context) {
+	user, err := s.storage.GetUser(currentUser(c))
+	if err != nil || subtle.ConstantTimeCompare([]byte(param(c, "T")), []byte(writeToken(user.GReaderToken))) != 1 {
+		c.Header("X-Reader-Google-Bad-Token", "true")
+		c.String(http.StatusUnauthorized, "Unauthorized")
+		c.Abort()
+		return
+	}
+	c.Next()
+}
+
+// writeToken derives the write token from a user's GoogleLogin token
+func writeToken(token string) string {
+	return token[strings.Index(token, "/")+1:]
+}
+
+// greaderUserInfo describes the logged in user
+func (s *Server) greaderUserInfo(c *gin.Context) {
+	username := currentUser(c)
+	c.JSON(http.StatusOK, gin.H{
+		"userId":        username,
+		"userName":      username,
+		"userProfileId": username,
+		"userEmail":     username,
+	})
+}
+
+// greaderSubscriptions lists subscriptions
+func (s *Server) greaderSubscriptions(c *gin.Context) {
+	feeds := s.storage.Subscriptions(currentUser(c))
+
+	subscriptions := make([]gin.H, 0, len(feeds))
+	for _, feed := range feeds {
+		categories := make([]gin.H, 0, 1)
+		if feed.Folder != "" {
+			categories = append(categories, gin.H{
+				"id":    greaderLabelPrefix + feed.Folder,
+				"label": feed.Folder,
+			})
+		}
+		subscriptions = append(subscriptions, gin.H{
+			"id":         greaderFeedPrefix + feed.URL,
+			"title":      feed.Title,
+			"categories": categories,
+			"url":        feed.URL,
+			"htmlUrl":    feed.Link,
+			"iconUrl":    "",
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{"subscriptions": subscriptions})
+}
+
+// greaderEditSubscription subscribes, unsubscribes or moves feeds between folders
+func (s *Server) greaderEditSubscription(c *gin.Context) {
+	c.Request.ParseForm()
+	action := param(c, "ac")
+	addLabel := strings.TrimPrefix(param(c, "a"), greaderLabelPrefix)
+	removeLabel := param(c, "r")
+
+	for _, streamID := range c.Request.Form["s"] {
+		feedURL := strings.TrimPrefix(streamID, greaderFeedPrefix)
+		if feedURL == streamID {
+			c.String(http.StatusBadRequest, "invalid stream id: %s", streamID)
+			return
+		}
+
+		var err error
+		switch action {
+		case "subscribe":
+			err = s.greaderSubscribe(currentUser(c), feedURL, addLabel)
+		case "unsubscribe":
+			var orphaned bool
+			orphaned, err = s.storage.Unsubscribe(currentUser(c), feedURL)
+			if orphaned && s.subscriber != nil {
+				s.runBackground(func() { s.subscriber.Unsubscribe(feedURL) })
+			}
+		case "edit":
+			if addLabel != "" {
+				err = s.storage.SetFolder(currentUser(c), feedURL, addLabel)
+			} else if removeLabel != "" {
+				err = s.storage.SetFolder(currentUser(c), feedURL, "")
+			}
+		default:
+			c.String(http.StatusBadRequest, "unsupported action: %s", action)
+			return
+		}
+		if err != nil {
+			c.String(http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+
+	c.String(http.StatusOK, "OK")
+}
+
+// greaderQuickAdd subscribes to a feed by URL
+func (s *Server) greaderQuickAdd(c *gin.Context) {
+	feedURL := strings.TrimPrefix(param(c, "quickadd"), greaderFeedPrefix)
+	if err := s.greaderSubscribe(currentUser(c), feedURL, ""); err != nil {
+		c.JSON(http.StatusOK, gin.H{"numResults": 0, "error": err.Error()})
+		return
+	}
+
+	feed, err := s.storage.CachedFeed(feedURL)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"numResults": 0, "error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"numResults": 1,
+		"query":      feedURL,
+		"streamId":   greaderFeedPrefix + feedURL,
+		"streamName": feed.Title,
+	})
+}
+
+// greaderSubscribe fetches a feed and subscribes the user to it
+func (s *Server) greaderSubscribe(username, feedURL, folder string) error {
+	feed, err := parser.FetchFeed(feedURL)
+	if err != nil {
+		return err
+	}
+	feed.Folder = folder
+	if err := s.storage.Subscribe(username, feed); err != nil {
+		return err
+	}
+
+	if s.subscriber != nil && feed.HubURL != "" {
+		s.runBackground(func() { s.subscriber.Subscribe(feed) })
+	}
+	return nil
+}
+
+// greaderTags lists states and folders
+func (s *Server) greaderTags(c *gin.Context) {
+	tags := []gin.H{{"id": greaderStarred}}
+
+	seen := make(map[string]bool)
+	folders := make([]string, 0)
+	for _, feed := range s.storage.Subscriptions(currentUser(c)) {
+		if feed.Folder != "" && !seen[feed.Folder] {
+			seen[feed.Folder] = true
+			folders = append(folders, feed.Folder)
+		}
+	}
+	sort.Strings(folders)
+	for _, folder := range folders {
+		tags = append(tags, gin.H{"id": greaderLabelPrefix + folder, "type": "folder"})
+	}
+
+	c.JSON(http.StatusOK, gin.H{"tags": tags})
+}
+
+// greaderStreamContents returns the items of a stream
+func (s *Server) greaderStreamContents(c *gin.Context) {
+	streamID := strings.TrimPrefix(c.Param("stream"), "/")
+	if streamID == "" {
+		streamID = param(c, "s")
+	}
+	if streamID == "" {
+		streamID = greaderReadingList
+	}
+
+	items, continuation := s.greaderSelect(c, streamID)
+	feeds := s.subscribedFeeds(currentUser(c))
+
+	result := make([]gin.H, 0, len(items))
+	for _, stored := range items {
+		result = append(result, greaderItem(stored, feeds[stored.FeedURL]))
+	}
+
+	response := gin.H{
+		"id":      streamID,
+		"updated": time.Now().Unix(),
+		"items":   result,
+	}
+	if continuation != "" {
+		response["continuation"] = continuation
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// greaderStreamItemIDs returns the IDs of the items in a stream
+func (s *Server) greaderStreamItemIDs(c *gin.Context) {
+	streamID := param(c, "s")
+	if streamID == "" {
+		streamID = greaderReadingList
+	}
+
+	items, continuation := s.greaderSelect(c, streamID)
+
+	refs := make([]gin.H, 0, len(items))
+	for _, stored := range items {
+		refs = append(refs, gin.H{
+			"id":              strconv.FormatInt(stored.Item.ID, 10),
+			"directStreamIds": []string{},
+			"timestampUsec":   strconv.FormatInt(itemTime(stored.Item).UnixNano()/1000, 10),
+		})
+	}
+
+	response := gin.H{"itemRefs": refs}
+	if continuation != "" {
+		response["continuation"] = continuation
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// greaderStreamItemContents returns the items with the given IDs
+func (s *Server) greaderStreamItemContents(c *gin.Context) {
+	c.Request.ParseForm()
+	wanted := make(map[int64]bool)
+	for _, value := range c.Request.Form["i"] {
+		if id, ok := parseGReaderItemID(value); ok {
+			wanted[id] = true
+		}
+	}
+
+	feeds := s.subscribedFeeds(currentUser(c))
+	result := make([]gin.H, 0, len(wanted))
+	for _, stored := range s.storage.Items(currentUser(c)) {
+		if wanted[stored.Item.ID] {
+			result = append(result, greaderItem(stored, feeds[stored.FeedURL]))
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"id":      greaderReadingList,
+		"updated": time.Now().Unix(),
+		"items":   result,
+	})
+}
+
+// greaderEditTag adds or removes the read and starred states of items
+func (s *Server) greaderEditTag(c *gin.Context) {
+	c.Request.ParseForm()
+	ids := make([]int64, 0)
+	for _, value := range c.Request.Form["i"] {
+		if id, ok := parseGReaderItemID(value); ok {
+			ids = append(ids, id)
+		}
+	}
+
+	username := currentUser(c)
+	for _, tag := range c.Request.Form["a"] {
+		switch tag {
+		case greaderRead:
+			s.storage.SetRead(username, ids, true)
+		case greaderStarred:
+			s.storage.SetSaved(username, ids, true)
+		}
+	}
+	for _, tag := range c.Request.Form["r"] {
+		switch tag {
+		case greaderRead:
+			s.storage.SetRead(username, ids, false)
+		case greaderStarred:
+			s.storage.SetSaved(username, ids, false)
+		}
+	}
+
+	c.String(http.StatusOK, "OK")
+}
+
+// greaderMarkAllAsRead marks all items of a stream as read
+func (s *Server) greaderMarkAllAsRead(c *gin.Context) {
+	streamID := param(c, "s")
+
+	var before time.Time
+	if value, err := strconv.ParseInt(param(c, "ts"), 10, 64); err == nil && value > 0 {
+		// ts is in microseconds
+		before = time.Unix(0, value*1000)
+	}
+
+	username := currentUser(c)
+	switch {
+	case streamID == greaderReadingList:
+		s.storage.MarkFolderRead(username, "", before)
+	case strings.HasPrefix(streamID, greaderLabelPrefix):
+		s.storage.MarkFolderRead(username, strings.TrimPrefix(streamID, greaderLabelPrefix), before)
+	case strings.HasPrefix(streamID, greaderFeedPrefix):
+		if err := s.storage.MarkFeedRead(username, strings.TrimPrefix(streamID, greaderFeedPrefix), before); err != nil {
+			c.String(http.StatusBadRequest, err.Error())
+			return
+		}
+	default:
+		c.String(http.StatusBadRequest, "unsupported stream: %s", streamID)
+		return
+	}
+
+	c.String(http.StatusOK, "OK")
+}
+
+// greaderSelect filters, orders and pages the items of a stream
+func (s *Server) greaderSelect(c *gin.Context, streamID string) ([]parser.StoredItem, string) {
+	feeds := s.subscribedFeeds(currentUser(c))
+
+	c.Request.ParseForm()
+	excludes := c.Request.Form["xt"]
+	includes := c.Request.Form["it"]
+
+	var oldest, newest time.Time
+	if value, err := strconv.ParseInt(param(c, "ot"), 10, 64); err == nil && value > 0 {
+		oldest = time.Unix(value, 0)
+	}
+	if value, err := strconv.ParseInt(param(c, "nt"), 10, 64); err == nil && value > 0 {
+		newest = time.Unix(value, 0)
+	}
+
+	selected := make([]parser.StoredItem, 0)
+	for _, stored := range s.storage.Items(currentUser(c)) {
+		if !inGReaderStream(stored, feeds[stored.FeedURL].Folder, streamID) {
+			continue
+		}
+
+		excluded := false
+		for _, tag := range excludes {
+			if inGReaderStream(stored, feeds[stored.FeedURL].Folder, tag) {
+				excluded = true
+			}
+		}
+		for _, tag := range includes {
+			if !inGReaderStream(stored, feeds[stored.FeedURL].Folder, tag) {
+				excluded = true
+			}
+		}
+
+		published := itemTime(stored.Item)
+		if (!oldest.IsZero() && published.Before(oldest)) || (!newest.IsZero() && published.After(newest)) {
+			excluded = true
+		}
+
+		if !excluded {
+			selected = append(selected, stored)
+		}
+	}
+
+	// Newest first unless r=o asks for oldest first
+	oldestFirst := param(c, "r") == "o"
+	sort.SliceStable(selected, func(i, j int) bool {
+		if oldestFirst {
+			return itemTime(selected[i].Item).Before(itemTime(selected[j].Item))
+		}
+		return itemTime(selected[i].Item).After(itemTime(selected[j].Item))
+	})
+
+	count, err := strconv.Atoi(param(c, "n"))
+	if err != nil || count <= 0 {
+		count = 20
+	}
+	if count > greaderMaxItems {
+		count = greaderMaxItems
+	}
+
+	offset, _ := strconv.Atoi(param(c, "c"))
+	if offset < 0 || offset > len(selected) {
+		offset = len(selected)
+	}
+
+	end := offset + count
+	continuation := ""
+	if end < len(selected) {
+		continuation = strconv.Itoa(end)
+	} else {
+		end = len(selected)
+	}
+	return selected[offset:end], continuation
+}
+
+// subscribedFeeds maps feed URLs to the user's subscriptions, with their folders
+func (s *Server) subscribedFeeds(username string) map[string]parser.Feed {
+	feeds := make(map[string]parser.Feed)
+	for _, feed := range s.storage.Subscriptions(username) {
+		feeds[feed.URL] = *feed
+	}
+	return feeds
+}
+
+// inGReaderStream returns true if an item belongs to a stream
+func inGReaderStream(stored parser.StoredItem, folder, streamID string) bool {
+	switch {
+	case streamID == greaderReadingList:
+		return true
+	case streamID == greaderRead:
+		return stored.Read
+	case streamID == greaderStarred:
+		return stored.Saved
+	case strings.HasPrefix(streamID, greaderLabelPrefix):
+		return folder != "" && folder == strings.TrimPrefix(streamID, greaderLabelPrefix)
+	case strings.HasPrefix(streamID, greaderFeedPrefix):
+		return stored.FeedURL == strings.TrimPrefix(streamID, greaderFeedPrefix)
+	}
+	return false
+}
+
+// greaderItem converts an item of a feed to the Google Reader representation
+func greaderItem(stored parser.StoredItem, feed parser.Feed) gin.H {
+	item := stored.Item
+	folder := feed.Folder
+	content := item.Content
+	if content == "" {
+		content = item.Description
+	}
+
+	categories := []string{greaderReadingList}
+	if folder != "" {
+		categories = append(categories, greaderLabelPrefix+folder)
+	}
+	if stored.Read {
+		categories = append(categories, greaderRead)
+	}
+	if stored.Saved {
+		categories = append(categories, greaderStarred)
+	}
+
+	enclosures := make([]gin.H, 0, len(item.Enclosures))
+	for _, enclosure := range item.Enclosures {
+		enclosures = append(enclosures, gin.H{
+			"href":   enclosure.URL,
+			"type":   enclosure.Type,
+			"length": strconv.FormatInt(enclosure.Length, 10),
+		})
+	}
+
+	published := itemTime(item)
+	updated := published
+	if item.UpdatedAt.After(published) {
+		updated = item.UpdatedAt
+	}
+	return gin.H{
+		"id":            fmt.Sprintf("%s%016x", greaderItemPrefix, item.ID),
+		"crawlTimeMsec": strconv.FormatInt(published.UnixNano()/int64(time.Millisecond), 10),
+		"timestampUsec": strconv.FormatInt(published.UnixNano()/int64(time.Microsecond), 10),
+		"published":     published.Unix(),
+		"updated":       updated.Unix(),
+		"title":         item.Title,
+		"canonical":     []gin.H{{"href": item.Link}},
+		"alternate":     []gin.H{{"href": item.Link, "type": "text/html"}},
+		"summary":       gin.H{"direction": "ltr", "content": content},
+		"author":        item.AuthorNames(),
+		"categories":    categories,
+		"enclosure":     enclosures,
+		"origin": gin.H{
+			"streamId": greaderFeedPrefix + stored.FeedURL,
+			"title":    feed.Title,
+			"htmlUrl":  feed.Link,
+		},
+	}
+}
+
+// parseGReaderItemID accepts both the long tag form and the decimal short form of item IDs
+func parseGReaderItemID(value string) (int64, bool) {
+	if strings.HasPrefix(value, greaderItemPrefix) {
+		id, err := strconv.ParseUint(strings.TrimPrefix(value, greaderItemPrefix), 16, 64)
+		return int64(id), err == nil
+	}
+	if unescaped, err := url.QueryUnescape(value); err == nil {
+		value = unescaped
+	}
+	id, err := strconv.ParseInt(value, 10, 64)
+	return id, err == nil
+}
+
+// itemTime returns the time an item is sorted and filtered by
+func itemTime(item parser.FeedItem) time.Time {
+	if item.PublishedAt.IsZero() {
+		return time.Unix(0, 0)
+	}
+	return item.PublishedAt
+}
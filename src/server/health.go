@@ -0,0 +1,80 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Saves fail readiness once this many in a row failed, or once the data on
+// disk is this old and the latest save failed. A single failure may be a
+// transient disk hiccup that the next save recovers from.
+const (
+	saveFailureLimit = 3
+	saveFailureAge   = 10 * time.Minute
+)
+
+// healthCheck is a single readiness condition
+type healthCheck struct {
+	name  string
+	check func() error
+}
+
+// healthz reports that the process is alive
+func (s *Server) healthz(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+// readyz reports whether the server can serve requests, with the status of every check.
+// The server is created after storage has loaded, so while loading nothing listens
+// and probes fail to connect, which orchestrators treat as not ready.
+func (s *Server) readyz(c *gin.Context) {
+	checks := []healthCheck{
+		{"storage_loaded", func() error {
+			if !s.storage.Loaded() {
+				return errors.New("stored data could not be loaded")
+			}
+			return nil
+		}},
+		{"storage_writable", s.storage.CheckWritable},
+		{"last_save", s.checkSaves},
+		{"scheduler", func() error {
+			if s.scheduler == nil || !s.scheduler.Running() {
+				return errors.New("scheduler is not running")
+			}
+			return nil
+		}},
+	}
+
+	status := http.StatusOK
+	results := gin.H{}
+	for _, check := range checks {
+		if err := check.check(); err != nil {
+			status = http.StatusServiceUnavailable
+			results[check.name] = gin.H{"status": "fail", "error": err.Error()}
+		} else {
+			results[check.name] = gin.H{"status": "ok"}
+		}
+	}
+
+	overall := "ok"
+	if status != http.StatusOK {
+		overall = "unavailable"
+	}
+	c.JSON(status, gin.H{"status": overall, "checks": results})
+}
+
+// checkSaves fails once saving has kept failing rather than on a single failure
+func (s *Server) checkSaves() error {
+	status := s.storage.SaveStatus()
+	if status.Err == nil {
+		return nil
+	}
+	if status.Failures >= saveFailureLimit || time.Since(status.LastSuccess) > saveFailureAge {
+		return fmt.Errorf("%d saves failed since %s: %v", status.Failures, status.LastSuccess.Format(time.RFC3339), status.Err)
+	}
+	return nil
+}
@@ -0,0 +1,50 @@
+package server
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/user/rss/src/parser"
+)
+
+func TestCheckSaves(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "data")
+	storage := parser.NewStorage(parser.StorageConfig{FilePath: filepath.Join(dir, "feeds.json")})
+	defer storage.Close(context.Background())
+	s := &Server{storage: storage}
+
+	// A file in place of the data directory makes every save fail
+	if err := os.Remove(dir); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(dir, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i <= saveFailureLimit; i++ {
+		if storage.SaveToFile() == nil {
+			t.Fatal("save succeeded without a data directory")
+		}
+		err := s.checkSaves()
+		if i < saveFailureLimit && err != nil {
+			t.Errorf("ready check failed after %d failed saves: %v", i, err)
+		}
+		if i == saveFailureLimit && err == nil {
+			t.Errorf("ready check passed after %d failed saves", i)
+		}
+	}
+
+	if err := os.Remove(dir); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.SaveToFile(); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.checkSaves(); err != nil {
+		t.Errorf("ready check failed after a successful save: %v", err)
+	}
+}
@@ -0,0 +1,117 @@
+package server
+
+import (
+	"fmt"
+	"html"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/user/rss/src/parser"
+	"github.com/user/rss/src/websub"
+)
+
+// hubRequest handles subscription requests to our WebSub hub
+func (s *Server) hubRequest(c *gin.Context) {
+	if !s.hubEnabled() {
+		c.String(http.StatusNotFound, "WebSub hub is not enabled")
+		return
+	}
+
+	leaseSeconds, _ := strconv.Atoi(c.PostForm("hub.lease_seconds"))
+	err := s.hub.HandleRequest(
+		c.PostForm("hub.mode"),
+		c.PostForm("hub.topic"),
+		c.PostForm("hub.callback"),
+		c.PostForm("hub.secret"),
+		leaseSeconds,
+	)
+	if err == websub.ErrUnsupportedTopic {
+		c.String(http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// Intent is verified asynchronously
+	c.Status(http.StatusAccepted)
+}
+
+// topicURL returns the public export URL of a feed, which is its WebSub topic
+func (s *Server) topicURL(feedURL string) string {
+	return s.baseURL + "/export?url=" + url.QueryEscape(feedURL)
+}
+
+// hubEnabled reports whether the hub serves our exports. Hub subscribers get the
+// full feed without logging in, so only publicly exported feeds are topics.
+func (s *Server) hubEnabled() bool {
+	return s.hub != nil && s.publicExport
+}
+
+// resolveTopic maps an export URL back to the subscribed feed it exports
+func (s *Server) resolveTopic(topic string) (string, bool) {
+	if !s.publicExport {
+		return "", false
+	}
+
+	topicURL, err := url.Parse(topic)
+	if err != nil {
+		return "", false
+	}
+
+	base, err := url.Parse(s.baseURL)
+	if err != nil || topicURL.Host != base.Host || topicURL.Path != strings.TrimSuffix(base.Path, "/")+"/export" {
+		return "", false
+	}
+
+	feedURL := topicURL.Query().Get("url")
+	if _, err := s.storage.CachedFeed(feedURL); err != nil {
+		return "", false
+	}
+	return feedURL, true
+}
+
+// publishToHub pushes the exported feed to hub subscribers when it gains items
+func (s *Server) publishToHub(feedURL string, items []parser.FeedItem) {
+	if !s.hub.HasSubscribers(feedURL) {
+		return
+	}
+
+	feed, err := s.storage.CachedFeed(feedURL)
+	if err != nil {
+		return
+	}
+
+	rss, err := s.renderFeed(feed, "rss")
+	if err != nil {
+		gin.DefaultWriter.Write([]byte(fmt.Sprintf("Error rendering %s for hub: %v\n", feedURL, err)))
+		return
+	}
+
+	s.hub.Publish(feedURL, "application/rss+xml", []byte(rss))
+}
+
+// addHubLinks adds WebSub hub and self links to an RSS or Atom document
+func addHubLinks(rendered, format, hubURL, selfURL string) string {
+	hubURL, selfURL = html.EscapeString(hubURL), html.EscapeString(selfURL)
+
+	switch format {
+	case "rss":
+		links := fmt.Sprintf(`<channel>
+    <atom:link rel="hub" href="%s"></atom:link>
+    <atom:link rel="self" href="%s"></atom:link>`, hubURL, selfURL)
+		rendered = strings.Replace(rendered, `<rss version="2.0"`, `<rss version="2.0" xmlns:atom="http://www.w3.org/2005/Atom"`, 1)
+		return strings.Replace(rendered, "<channel>", links, 1)
+	case "atom":
+		root := `<feed xmlns="http://www.w3.org/2005/Atom">`
+		links := fmt.Sprintf(`%s
+  <link href="%s" rel="hub"></link>
+  <link href="%s" rel="self"></link>`, root, hubURL, selfURL)
+		return strings.Replace(rendered, root, links, 1)
+	}
+	return rendered
+}
@@ -0,0 +1,66 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/user/rss/src/parser"
+	"github.com/user/rss/src/websub"
+)
+
+func TestHubOnlyServesPublicExports(t *testing.T) {
+	dir := t.TempDir()
+	storage := parser.NewStorage(parser.StorageConfig{FilePath: filepath.Join(dir, "feeds.json")})
+	defer storage.Close(context.Background())
+	if _, err := storage.CreateUser("alice", "secret123"); err != nil {
+		t.Fatal(err)
+	}
+	feedURL := "https://example.com/private.xml"
+	if err := storage.Subscribe("alice", &parser.Feed{URL: feedURL, Title: "Private"}); err != nil {
+		t.Fatal(err)
+	}
+
+	hubConfig := websub.DefaultHubConfig()
+	hubConfig.HubURL = "https://rss.example.com/websub/hub"
+	hubConfig.StateFile = filepath.Join(dir, "websub_hub.json")
+	s := &Server{storage: storage, baseURL: "https://rss.example.com", hub: websub.NewHub(hubConfig)}
+	topic := s.topicURL(feedURL)
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/websub/hub", s.hubRequest)
+	subscribe := func() int {
+		form := url.Values{
+			"hub.mode":     {"subscribe"},
+			"hub.topic":    {topic},
+			"hub.callback": {"https://subscriber.example.com/callback"},
+		}
+		req := httptest.NewRequest(http.MethodPost, "/websub/hub", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	// Exports need a login, so the hub must not hand them out
+	if _, ok := s.resolveTopic(topic); ok {
+		t.Error("topic resolved without public exports")
+	}
+	if code := subscribe(); code != http.StatusNotFound {
+		t.Errorf("subscription without public exports: status = %d, want 404", code)
+	}
+
+	s.publicExport = true
+	if got, ok := s.resolveTopic(topic); !ok || got != feedURL {
+		t.Errorf("resolveTopic = %q, %v, want %q", got, ok, feedURL)
+	}
+	if _, ok := s.resolveTopic(s.topicURL("https://example.com/unknown.xml")); ok {
+		t.Error("topic of an unknown feed resolved")
+	}
+}
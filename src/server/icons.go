@@ -0,0 +1,77 @@
+package server
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"os"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/user/rss/src/parser"
+)
+
+// feedIcon serves the stored icon of one of the user's subscriptions
+func (s *Server) feedIcon(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid feed ID"})
+		return
+	}
+	feed, ok := s.storage.FeedByID(currentUser(c), id)
+	if !ok || s.favicons == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "icon not found"})
+		return
+	}
+	icon, ok := s.favicons.Icon(feed.URL)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "icon not found"})
+		return
+	}
+
+	f, err := os.Open(s.favicons.Path(icon))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "icon not found"})
+		return
+	}
+	defer f.Close()
+
+	c.Header("Content-Type", icon.Type)
+	// Icon URLs change when the icon does, see iconURL
+	c.Header("Cache-Control", "private, max-age=604800")
+	c.Header("X-Content-Type-Options", "nosniff")
+	http.ServeContent(c.Writer, c.Request, "", icon.FetchedAt, f)
+}
+
+// iconURL returns where the icon of a feed is served, or "" if it has none
+func (s *Server) iconURL(feedURL string, feedID int64) string {
+	if s.favicons == nil {
+		return ""
+	}
+	icon, ok := s.favicons.Icon(feedURL)
+	if !ok {
+		return ""
+	}
+	return fmt.Sprintf("/icons/%d?v=%d", feedID, icon.FetchedAt.Unix())
+}
+
+// feedIconURLs returns the icon URLs of feeds by feed URL, for the sidebar template
+func (s *Server) feedIconURLs(feeds []*parser.Feed) map[string]string {
+	icons := make(map[string]string, len(feeds))
+	for _, feed := range feeds {
+		if src := s.iconURL(feed.URL, feed.ID); src != "" {
+			icons[feed.URL] = src
+		}
+	}
+	return icons
+}
+
+// feedIconHTML renders a feed's icon at the given size, falling back to the
+// generic feed icon when it has none
+func feedIconHTML(src, class string) string {
+	if src == "" {
+		return fmt.Sprintf(`<i class="bi bi-rss text-blue-400 flex-shrink-0 %s"></i>`, class)
+	}
+	return fmt.Sprintf(`<img src="%s" alt="" loading="lazy" class="rounded-sm object-contain flex-shrink-0 %s">`,
+		template.HTMLEscaper(src), class)
+}
@@ -0,0 +1,66 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/user/rss/src/imageproxy"
+	"github.com/user/rss/src/netguard"
+)
+
+// proxyImage serves an image from the proxy's cache, fetching it on first use
+func (s *Server) proxyImage(c *gin.Context) {
+	if s.images == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "image proxy is disabled"})
+		return
+	}
+	src := c.Query("url")
+	if src == "" || !s.images.Verify(src, c.Query("sig")) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "invalid signature"})
+		return
+	}
+
+	image, err := s.images.Get(src)
+	switch {
+	case errors.Is(err, imageproxy.ErrTooLarge):
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, imageproxy.ErrNotImage):
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, netguard.ErrNotPublic):
+		c.JSON(http.StatusForbidden, gin.H{"error": "image is not on a public address"})
+		return
+	case err != nil:
+		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+		return
+	}
+
+	f, err := os.Open(image.Path)
+	if err != nil {
+		// Evicted between the lookup and now
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "image is not available"})
+		return
+	}
+	defer f.Close()
+
+	c.Header("Content-Type", image.Type)
+	c.Header("Cache-Control", fmt.Sprintf("public, max-age=%d, immutable", int(s.images.MaxAge().Seconds())))
+	c.Header("ETag", image.ETag)
+	c.Header("X-Content-Type-Options", "nosniff")
+	c.Header("Content-Security-Policy", "default-src 'none'")
+	// The ETag never changes for a URL, so it is what revalidation relies on
+	http.ServeContent(c.Writer, c.Request, "", time.Time{}, f)
+}
+
+// proxyImages points the images in rendered HTML at the image proxy, if there is one
+func (s *Server) proxyImages(html string) string {
+	if s.images == nil {
+		return html
+	}
+	return s.images.RewriteHTML(html)
+}
@@ -0,0 +1,47 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/user/rss/src/parser"
+)
+
+// previewJSONSource reads a JSON API with the given field mappings without
+// subscribing to it, so mappings can be tried out
+func (s *Server) previewJSONSource(c *gin.Context) {
+	apiURL := c.Query("url")
+	if apiURL == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "URL parameter is required"})
+		return
+	}
+	feed, err := parser.FetchJSONFeed(apiURL, jsonSourceFields(c))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	renderPreview(c, feed)
+}
+
+// jsonSourceConfig reads the field mappings of a JSON API feed from the request, or
+// returns nil when the source isn't "json"
+func jsonSourceConfig(c *gin.Context) *parser.JSONSource {
+	if formValue(c, "source") != "json" {
+		return nil
+	}
+	source := jsonSourceFields(c)
+	return &source
+}
+
+// jsonSourceFields reads the field mappings of a JSON API feed from the request
+func jsonSourceFields(c *gin.Context) parser.JSONSource {
+	return parser.JSONSource{
+		Name:    formValue(c, "json_name"),
+		Items:   formValue(c, "json_items"),
+		Title:   formValue(c, "json_title"),
+		Link:    formValue(c, "json_link"),
+		Date:    formValue(c, "json_date"),
+		ID:      formValue(c, "json_id"),
+		Content: formValue(c, "json_content"),
+	}
+}
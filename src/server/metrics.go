@@ -0,0 +1,39 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/user/rss/src/metrics"
+)
+
+// HTTP metrics exposed at /metrics
+var (
+	requestsTotal = metrics.Default.NewCounterVec("rss_http_requests_total",
+		"HTTP requests by method, route and status code.", "method", "route", "status")
+	requestDuration = metrics.Default.NewHistogramVec("rss_http_request_duration_seconds",
+		"Time taken to handle HTTP requests by method and route.", metrics.DefaultBuckets, "method", "route")
+)
+
+// observeRequests records metrics for every request. Routes are labelled by
+// their pattern so path parameters don't create a series per value.
+func observeRequests(c *gin.Context) {
+	started := time.Now()
+	c.Next()
+
+	route := c.FullPath()
+	if route == "" {
+		route = "unmatched"
+	}
+	requestsTotal.Inc(c.Request.Method, route, strconv.Itoa(c.Writer.Status()))
+	requestDuration.Observe(time.Since(started).Seconds(), c.Request.Method, route)
+}
+
+// serveMetrics writes all metrics in the Prometheus text format
+func (s *Server) serveMetrics(c *gin.Context) {
+	c.Header("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	c.Status(http.StatusOK)
+	metrics.Default.WriteTo(c.Writer)
+}
@@ -0,0 +1,36 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/user/rss/src/metrics"
+)
+
+func TestObserveRequests(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(observeRequests)
+	router.GET("/metrics-test/:id", func(c *gin.Context) { c.Status(http.StatusTeapot) })
+
+	for _, path := range []string{"/metrics-test/1", "/metrics-test/2", "/metrics-test-missing"} {
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	var out strings.Builder
+	if _, err := metrics.Default.WriteTo(&out); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`rss_http_requests_total{method="GET",route="/metrics-test/:id",status="418"} 2`,
+		`rss_http_requests_total{method="GET",route="unmatched",status="404"} 1`,
+		`rss_http_request_duration_seconds_count{method="GET",route="/metrics-test/:id"} 2`,
+	} {
+		if !strings.Contains(out.String(), want+"\n") {
+			t.Errorf("metrics lack %q:\n%s", want, out.String())
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package server
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/user/rss/src/parser"
+)
+
+// maxPreviewItems is how many items the scrape and JSON source previews show
+const maxPreviewItems = 20
+
+// previewScrape scrapes a page with the given selectors without subscribing to it,
+// so selectors can be tried out
+func (s *Server) previewScrape(c *gin.Context) {
+	pageURL := c.Query("url")
+	if pageURL == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "URL parameter is required"})
+		return
+	}
+	scrape := scrapeConfig(c)
+	if scrape == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "item selector is required"})
+		return
+	}
+
+	feed, err := parser.ScrapeFeed(pageURL, *scrape)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	renderPreview(c, feed)
+}
+
+// renderPreview shows the first items of a feed that isn't subscribed to yet, as
+// JSON or as an HTML fragment for HTMX
+func renderPreview(c *gin.Context, feed *parser.Feed) {
+	items := feed.Items
+	if len(items) > maxPreviewItems {
+		items = items[:maxPreviewItems]
+	}
+
+	if c.GetHeader("HX-Request") != "true" {
+		list := make([]gin.H, 0, len(items))
+		for _, item := range items {
+			list = append(list, gin.H{
+				"title":        item.Title,
+				"link":         item.Link,
+				"published_at": item.PublishedAt,
+				"summary":      item.Description,
+			})
+		}
+		c.JSON(http.StatusOK, gin.H{"title": feed.Title, "count": len(feed.Items), "items": list})
+		return
+	}
+
+	var preview strings.Builder
+	preview.WriteString(fmt.Sprintf(`<div class="text-xs text-dark-text-secondary mb-2">%d items found in <span class="text-dark-text">%s</span></div><ul class="space-y-2">`,
+		len(feed.Items), template.HTMLEscaper(feed.Title)))
+	for _, item := range items {
+		date := "no date"
+		if !item.PublishedAt.IsZero() {
+			date = item.PublishedAt.Format("Jan 2, 2006")
+		}
+		preview.WriteString(fmt.Sprintf(`<li class="text-sm border-l-2 border-blue-500 pl-2">
+			<div class="text-dark-text font-medium">%s</div>
+			<div class="text-xs text-dark-text-secondary truncate">%s · %s</div>
+			<div class="text-xs text-dark-text-secondary line-clamp-2">%s</div>
+		</li>`,
+			template.HTMLEscaper(item.Title),
+			template.HTMLEscaper(date),
+			template.HTMLEscaper(item.Link),
+			template.HTMLEscaper(parser.PlainText(item.Description))))
+	}
+	preview.WriteString(`</ul>`)
+
+	c.Header("Content-Type", "text/html")
+	c.String(http.StatusOK, preview.String())
+}
+
+// scrapeConfig reads the selectors of a scraped feed from the request, or returns
+// nil when no item selector was given
+func scrapeConfig(c *gin.Context) *parser.ScrapeConfig {
+	scrape := &parser.ScrapeConfig{
+		Item:    formValue(c, "item_selector"),
+		Title:   formValue(c, "title_selector"),
+		Link:    formValue(c, "link_selector"),
+		Date:    formValue(c, "date_selector"),
+		Summary: formValue(c, "summary_selector"),
+	}
+	if scrape.Item == "" {
+		return nil
+	}
+	return scrape
+}
+
+// formValue reads a field from the posted form, falling back to the query string
+func formValue(c *gin.Context, name string) string {
+	if v, ok := c.GetPostForm(name); ok {
+		return strings.TrimSpace(v)
+	}
+	return strings.TrimSpace(c.Query(name))
+}
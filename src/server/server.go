@@ -1,37 +1,181 @@
 package server
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"html/template"
+	"net"
 	"net/http"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/gorilla/feeds"
+	"github.com/user/rss/src/archive"
+	"github.com/user/rss/src/auth"
+	"github.com/user/rss/src/digest"
+	"github.com/user/rss/src/favicon"
+	"github.com/user/rss/src/imageproxy"
 	"github.com/user/rss/src/parser"
+	"github.com/user/rss/src/scheduler"
+	"github.com/user/rss/src/websub"
 )
 
 // Server represents the RSS server
 type Server struct {
-	router  *gin.Engine
-	storage *parser.Storage
+	router        *gin.Engine
+	httpServer    *http.Server
+	storage       *parser.Storage
+	sessions      *auth.SessionStore
+	tokens        *auth.TokenStore
+	baseURL       string
+	allowSignup   bool
+	publicExport  bool
+	publicMetrics bool
+	scheduler     *scheduler.Scheduler
+	digest        *digest.Digest
+	subscriber    *websub.Subscriber
+	hub           *websub.Hub
+	archive       *archive.Archive
+	images        *imageproxy.Proxy
+	favicons      *favicon.Favicons
+	// background tracks work started by handlers that outlives the request
+	background sync.WaitGroup
+}
+
+// Config holds the optional components the server exposes
+type Config struct {
+	// BaseURL is the public URL of the server, used for links handed to other services
+	BaseURL  string
+	Sessions *auth.SessionStore
+	Tokens   *auth.TokenStore
+	// AllowSignup lets anyone create an account; otherwise only the first account can be created
+	AllowSignup bool
+	// PublicExport serves /export without authentication, read-only
+	PublicExport bool
+	// PublicMetrics serves /metrics without authentication; otherwise it requires an admin account
+	PublicMetrics bool
+	// Scheduler runs the background jobs; readiness reports whether it is running
+	Scheduler  *scheduler.Scheduler
+	Digest     *digest.Digest
+	Subscriber *websub.Subscriber
+	Hub        *websub.Hub
+	// Archive downloads episodes of feeds that opted in and serves them
+	Archive *archive.Archive
+	// ImageProxy serves the images in item content so readers don't load them from their hosts
+	ImageProxy *imageproxy.Proxy
+	// Favicons fetches the icons shown next to each feed
+	Favicons *favicon.Favicons
 }
 
 // NewServer creates a new server instance
-func NewServer(storage *parser.Storage) *Server {
+func NewServer(storage *parser.Storage, config Config) *Server {
 	router := gin.Default()
+	router.Use(observeRequests)
 	server := &Server{
-		router:  router,
-		storage: storage,
+		router: router,
+		httpServer: &http.Server{
+			Handler:           router,
+			ReadHeaderTimeout: 10 * time.Second,
+		},
+		storage:       storage,
+		sessions:      config.Sessions,
+		tokens:        config.Tokens,
+		baseURL:       strings.TrimSuffix(config.BaseURL, "/"),
+		allowSignup:   config.AllowSignup,
+		publicExport:  config.PublicExport,
+		publicMetrics: config.PublicMetrics,
+		scheduler:     config.Scheduler,
+		digest:        config.Digest,
+		subscriber:    config.Subscriber,
+		hub:           config.Hub,
+		archive:       config.Archive,
+		images:        config.ImageProxy,
+		favicons:      config.Favicons,
+	}
+	if server.sessions == nil {
+		server.sessions = auth.NewSessionStore(auth.SessionConfig{MaxAge: auth.DefaultSessionConfig().MaxAge})
+	}
+	if server.tokens == nil {
+		server.tokens = auth.NewTokenStore(auth.TokenConfig{})
 	}
 
+	router.GET("/healthz", server.healthz)
+	router.GET("/readyz", server.readyz)
+	router.GET("/login", server.loginPage)
+	router.POST("/login", server.login)
+	router.GET("/register", server.registerPage)
+	router.POST("/register", server.register)
+	// Proxy URLs are signed, so images load without a session, as in feed readers and email
+	router.GET("/img", server.proxyImage)
+
 	// Set up routes - using query parameters instead of path parameters for URLs
-	router.GET("/", server.homePage)
-	router.GET("/feeds", server.listFeeds)
-	router.POST("/feeds", server.addFeed)
-	router.GET("/feed", server.getFeed)       // Changed to /feed?url=...
-	router.DELETE("/feed", server.removeFeed) // Changed to /feed?url=...
-	router.GET("/export", server.exportFeed)  // Changed to /export?url=...
+	authenticated := router.Group("/", server.authenticate, server.verifyCSRF)
+	authenticated.POST("/logout", server.logout)
+	read := authenticated.Group("/", server.requireScope(auth.ScopeRead))
+	read.GET("/", server.homePage)
+	read.GET("/feeds", server.listFeeds)
+	read.GET("/feed", server.getFeed) // Changed to /feed?url=...
+	read.GET("/episodes", server.listEpisodes)
+	read.GET("/episodes/view", server.episodesView)
+	read.GET("/scrape/preview", server.previewScrape)
+	read.GET("/json-source/preview", server.previewJSONSource)
+	read.GET("/archive/:id", server.archivedEpisode)
+	read.GET("/icons/:id", server.feedIcon)
+	if server.publicExport {
+		router.GET("/export", server.exportFeed)
+	} else {
+		read.GET("/export", server.exportFeed) // Changed to /export?url=...
+	}
+
+	write := authenticated.Group("/", server.requireScope(auth.ScopeWrite))
+	write.POST("/feeds", server.addFeed)
+	write.DELETE("/feed", server.removeFeed) // Changed to /feed?url=...
+	write.PUT("/episodes/:id/position", server.setPosition)
+	write.PUT("/feed/archive", server.setArchive)          // /feed/archive?url=...
+	write.PUT("/feed/full-content", server.setFullContent) // /feed/full-content?url=...
+
+	admin := authenticated.Group("/", server.requireScope(auth.ScopeAdmin))
+	admin.GET("/tokens", server.listTokens)
+	admin.POST("/tokens", server.createToken)
+	admin.DELETE("/tokens/:id", server.revokeToken)
+
+	if server.publicMetrics {
+		router.GET("/metrics", server.serveMetrics)
+	} else {
+		read.GET("/metrics", server.requireAdmin, server.serveMetrics)
+	}
+
+	digestGroup := authenticated.Group("/digest", server.requireScope(auth.ScopeAdmin), server.requireAdmin)
+	digestGroup.GET("/preview", server.previewDigest)
+	digestGroup.POST("/send", server.sendDigest)
+	router.GET("/websub/callback/:id", server.verifyWebSub)
+	router.POST("/websub/callback/:id", server.receiveWebSub)
+	router.POST("/websub/hub", server.hubRequest)
+	router.Any("/fever", server.fever)
+	router.Any("/fever/", server.fever)
+
+	// Google Reader API, as implemented by FreshRSS and Miniflux
+	router.POST("/accounts/ClientLogin", server.greaderLogin)
+	greader := router.Group("/reader/api/0", server.greaderAuth)
+	greader.GET("/token", server.greaderWriteToken)
+	greader.GET("/user-info", server.greaderUserInfo)
+	greader.GET("/subscription/list", server.greaderSubscriptions)
+	greader.POST("/subscription/edit", server.greaderWriteAuth, server.greaderEditSubscription)
+	greader.POST("/subscription/quickadd", server.greaderWriteAuth, server.greaderQuickAdd)
+	greader.GET("/tag/list", server.greaderTags)
+	greader.GET("/stream/contents/*stream", server.greaderStreamContents)
+	greader.GET("/stream/items/ids", server.greaderStreamItemIDs)
+	greader.POST("/stream/items/contents", server.greaderStreamItemContents)
+	greader.POST("/edit-tag", server.greaderWriteAuth, server.greaderEditTag)
+	greader.POST("/mark-all-as-read", server.greaderWriteAuth, server.greaderMarkAllAsRead)
+
+	// Publish new items to subscribers of our exported feeds
+	if server.hub != nil {
+		server.hub.SetTopicResolver(server.resolveTopic)
+		storage.OnNewItems(server.publishToHub)
+	}
 
 	// Serve static files
 	router.Static("/static", "./web/static")
@@ -40,23 +184,67 @@ func NewServer(storage *parser.Storage) *Server {
 	return server
 }
 
-// Start starts the server
+// Start starts the server and blocks until it stops. It returns nil after Shutdown.
 func (s *Server) Start(addr string) error {
-	return s.router.Run(addr)
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	if err := s.httpServer.Serve(listener); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// Shutdown stops accepting requests, lets in-flight requests finish and waits for
+// background work started by handlers, giving up when ctx is done
+func (s *Server) Shutdown(ctx context.Context) error {
+	if err := s.httpServer.Shutdown(ctx); err != nil {
+		return err
+	}
+
+	done := make(chan struct{})
+	go func() {
+		s.background.Wait()
+		if s.hub != nil {
+			s.hub.Wait()
+		}
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// runBackground runs work that outlives the request, so Shutdown can wait for it
+func (s *Server) runBackground(work func()) {
+	s.background.Add(1)
+	go func() {
+		defer s.background.Done()
+		work()
+	}()
 }
 
 // homePage handles the home page
 func (s *Server) homePage(c *gin.Context) {
-	feeds := s.storage.GetAllFeeds()
+	feeds := s.storage.Subscriptions(currentUser(c))
 	c.HTML(http.StatusOK, "index.html", gin.H{
-		"title": "RSS Reader",
-		"feeds": feeds,
+		"title":     "RSS Reader",
+		"feeds":     feeds,
+		"icons":     s.feedIconURLs(feeds),
+		"username":  currentUser(c),
+		"csrfToken": csrfToken(c),
 	})
 }
 
 // listFeeds handles listing all feeds
 func (s *Server) listFeeds(c *gin.Context) {
-	feeds := s.storage.GetAllFeeds()
+	feeds := s.storage.Subscriptions(currentUser(c))
 	c.JSON(http.StatusOK, feeds)
 }
 
@@ -67,25 +255,61 @@ func (s *Server) addFeed(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "URL parameter is required"})
 		return
 	}
+	folder := strings.TrimSpace(c.PostForm("folder"))
 
 	// Log the URL we're trying to add
 	gin.DefaultWriter.Write([]byte("Adding feed URL: " + url + "\n"))
 
-	feed, err := parser.FetchFeed(url)
+	// Pages without a feed are scraped with the selectors given in the form, and
+	// JSON APIs are read with its field mappings
+	var feed *parser.Feed
+	var err error
+	if scrape := scrapeConfig(c); scrape != nil {
+		feed, err = parser.ScrapeFeed(url, *scrape)
+	} else if source := jsonSourceConfig(c); source != nil {
+		feed, err = parser.FetchJSONFeed(url, *source)
+	} else {
+		feed, err = parser.FetchFeed(url)
+	}
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	err = s.storage.AddFeed(feed)
+	feed.Folder = folder
+	err = s.storage.Subscribe(currentUser(c), feed)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
+	// Subscribe for push updates if the feed advertises a hub
+	if s.subscriber != nil && feed.HubURL != "" {
+		s.runBackground(func() {
+			if err := s.subscriber.Subscribe(feed); err != nil {
+				gin.DefaultWriter.Write([]byte(fmt.Sprintf("Error subscribing to hub for %s: %v\n", feed.URL, err)))
+			}
+		})
+	}
+
+	// Look for the feed's icon now rather than at the next scheduled run. The
+	// stored feed holds the ID the icon is served under.
+	if stored, err := s.storage.CachedFeed(feed.URL); err == nil {
+		feed.ID = stored.ID
+	}
+	if s.favicons != nil {
+		if _, ok := s.favicons.Icon(feed.URL); !ok {
+			s.runBackground(func() {
+				if err := s.favicons.Update(feed); err != nil {
+					gin.DefaultWriter.Write([]byte(fmt.Sprintf("Error saving icon of %s: %v\n", feed.URL, err)))
+				}
+			})
+		}
+	}
+
 	// Log storage contents after adding
-	feeds := s.storage.GetAllFeeds()
-	gin.DefaultWriter.Write([]byte(fmt.Sprintf("Storage now contains %d feeds\n", len(feeds))))
+	feeds := s.storage.Subscriptions(currentUser(c))
+	gin.DefaultWriter.Write([]byte(fmt.Sprintf("%s now subscribes to %d feeds\n", currentUser(c), len(feeds))))
 	for _, f := range feeds {
 		gin.DefaultWriter.Write([]byte(fmt.Sprintf("  - %s\n", f.URL)))
 	}
@@ -100,7 +324,7 @@ func (s *Server) addFeed(c *gin.Context) {
 		<div class="px-4 lg:px-6 py-3 lg:py-4">
 			<div class="flex items-start justify-between">
 				<div class="flex items-start space-x-3 flex-1 min-w-0">
-					<i class="bi bi-rss text-blue-400 flex-shrink-0 mt-0.5"></i>
+					%s
 					<div class="flex-1 min-w-0">
 						<h3 class="text-dark-text font-medium text-sm leading-tight mb-1 line-clamp-2">%s</h3>
 						<p class="text-dark-text-secondary text-xs truncate">%s</p>
@@ -126,7 +350,7 @@ func (s *Server) addFeed(c *gin.Context) {
 				</div>
 			</div>
 		</div>
-	</li>`, template.URLQueryEscaper(feed.URL), template.HTMLEscaper(feed.Title), template.URLQueryEscaper(feed.URL), template.URLQueryEscaper(feed.URL), template.URLQueryEscaper(feed.URL))
+	</li>`, template.URLQueryEscaper(feed.URL), feedIconHTML(s.iconURL(feed.URL, feed.ID), "w-4 h-4 mt-0.5"), template.HTMLEscaper(feed.Title), template.URLQueryEscaper(feed.URL), template.URLQueryEscaper(feed.URL), template.URLQueryEscaper(feed.URL))
 
 	c.Header("Content-Type", "text/html")
 	c.String(http.StatusOK, feedItemHTML)
@@ -144,6 +368,11 @@ func (s *Server) getFeed(c *gin.Context) {
 	// Log the URL we're looking for
 	gin.DefaultWriter.Write([]byte("Getting feed URL: " + feedURL + "\n"))
 
+	if !s.storage.IsSubscribed(currentUser(c), feedURL) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "feed not found"})
+		return
+	}
+
 	feed, err := s.storage.GetFeed(feedURL)
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
@@ -155,11 +384,13 @@ func (s *Server) getFeed(c *gin.Context) {
 
 	if len(feed.Items) > 0 {
 		feedContentHTML.WriteString(`<div class="max-w-4xl mx-auto space-y-6">`)
+		icon := s.iconURL(feed.URL, feed.ID)
+		feedContentHTML.WriteString(feedHeaderHTML(feed, icon, s.archive != nil))
 
+		positions := s.storage.Positions(currentUser(c))
 		for _, item := range feed.Items {
-			date := item.PublishedAt.Format("January 2, 2006 at 3:04 PM")
 			content := item.Description
-			if content == "" {
+			if content == "" || item.FullContent {
 				content = item.Content
 			}
 
@@ -171,22 +402,27 @@ func (s *Server) getFeed(c *gin.Context) {
 				<h3 class="text-xl font-semibold text-dark-text mb-3 leading-tight">
 					<a href="%s" target="_blank" rel="noopener noreferrer" 
 					   class="hover:text-blue-400 transition-colors group flex items-start p-1 -m-1 rounded">
+						%s
 						<span class="flex-1">%s</span>
 						<i class="bi bi-box-arrow-up-right ml-2 text-base opacity-60 group-hover:opacity-100 group-hover:text-blue-400 flex-shrink-0 mt-1 transition-all"></i>
 					</a>
 				</h3>
-				<div class="flex items-center text-dark-text-secondary text-sm mb-4">
-					<i class="bi bi-calendar mr-2 text-blue-400"></i>
-					<span>%s</span>
-				</div>
+				%s
+				%s
+				%s
 				<div class="feed-content text-dark-text prose-sm">
 					%s
 				</div>
+				%s
 			</article>`,
 				template.HTMLEscaper(item.Link),
+				feedIconHTML(icon, "w-5 h-5 mr-2 mt-1"),
 				template.HTMLEscaper(item.Title),
-				date,
+				itemMetaHTML(item),
+				itemImageHTML(item, content),
+				mediaPlayerHTML(item, positions[item.ID], s.archivedURL(item.ID)),
 				content,
+				enclosuresHTML(item),
 			))
 		}
 
@@ -201,7 +437,7 @@ func (s *Server) getFeed(c *gin.Context) {
 	}
 
 	c.Header("Content-Type", "text/html")
-	c.String(http.StatusOK, feedContentHTML.String())
+	c.String(http.StatusOK, s.proxyImages(feedContentHTML.String()))
 }
 
 // processContentForDarkMode processes HTML content to ensure visibility in dark mode
@@ -230,12 +466,21 @@ func (s *Server) removeFeed(c *gin.Context) {
 		return
 	}
 
-	err := s.storage.RemoveFeed(feedURL)
+	orphaned, err := s.storage.Unsubscribe(currentUser(c), feedURL)
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
 		return
 	}
 
+	// Stop push updates once nobody reads the feed anymore
+	if orphaned && s.subscriber != nil {
+		s.runBackground(func() {
+			if err := s.subscriber.Unsubscribe(feedURL); err != nil {
+				gin.DefaultWriter.Write([]byte(fmt.Sprintf("Error unsubscribing from hub for %s: %v\n", feedURL, err)))
+			}
+		})
+	}
+
 	// Return empty content for HTMX to remove the element
 	c.Header("Content-Type", "text/html")
 	c.String(http.StatusOK, "")
@@ -250,38 +495,44 @@ func (s *Server) exportFeed(c *gin.Context) {
 		return
 	}
 
-	feed, err := s.storage.GetFeed(feedURL)
-	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	// Public exports serve any stored feed; otherwise only the user's own
+	if currentUser(c) != "" && !s.storage.IsSubscribed(currentUser(c), feedURL) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "feed not found"})
 		return
 	}
 
-	// Create a new RSS feed
-	rssFeed := &feeds.Feed{
-		Title:       feed.Title,
-		Link:        &feeds.Link{Href: feed.URL},
-		Description: feed.Description,
-		Created:     feed.UpdatedAt,
+	format := c.DefaultQuery("format", "rss")
+	contentType, ok := ExportContentType(format)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format must be rss, atom or json"})
+		return
 	}
 
-	rssFeed.Items = make([]*feeds.Item, 0, len(feed.Items))
-	for _, item := range feed.Items {
-		rssFeed.Items = append(rssFeed.Items, &feeds.Item{
-			Title:       item.Title,
-			Link:        &feeds.Link{Href: item.Link},
-			Description: item.Description,
-			Content:     item.Content,
-			Created:     item.PublishedAt,
-			Id:          item.GUID,
-		})
+	// Anonymous requests get the cached items so they can't trigger upstream fetches
+	var feed *parser.Feed
+	var err error
+	if currentUser(c) == "" {
+		feed, err = s.storage.CachedFeed(feedURL)
+	} else {
+		feed, err = s.storage.GetFeed(feedURL)
+	}
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
 	}
 
-	rss, err := rssFeed.ToRss()
+	rendered, err := s.renderFeed(feed, format)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
-	c.Header("Content-Type", "application/rss+xml")
-	c.String(http.StatusOK, rss)
+	// Advertise the hub so subscribers don't have to poll
+	if s.hubEnabled() {
+		c.Writer.Header().Add("Link", fmt.Sprintf(`<%s>; rel="hub"`, s.hub.URL()))
+		c.Writer.Header().Add("Link", fmt.Sprintf(`<%s>; rel="self"`, s.topicURL(feed.URL)))
+	}
+
+	c.Header("Content-Type", contentType)
+	c.String(http.StatusOK, rendered)
 }
@@ -0,0 +1,57 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/user/rss/src/auth"
+	"github.com/user/rss/src/parser"
+)
+
+// newTestServer returns a server on empty storage in a temporary directory, with
+// the account alice (password secret123) subscribed to one feed
+func newTestServer(t *testing.T) *Server {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	dir := t.TempDir()
+	storage := parser.NewStorage(parser.StorageConfig{
+		FilePath:      filepath.Join(dir, "feeds.json"),
+		ItemsFilePath: filepath.Join(dir, "items.json"),
+		UsersFilePath: filepath.Join(dir, "users.json"),
+	})
+	t.Cleanup(func() { storage.Close(context.Background()) })
+
+	if _, err := storage.CreateUser("alice", "secret123"); err != nil {
+		t.Fatal(err)
+	}
+	feed := &parser.Feed{URL: "https://example.com/feed.xml", Title: "Example", Link: "https://example.com/"}
+	if err := storage.Subscribe("alice", feed); err != nil {
+		t.Fatal(err)
+	}
+
+	return &Server{
+		storage:  storage,
+		sessions: auth.NewSessionStore(auth.SessionConfig{StateFile: filepath.Join(dir, "sessions.json"), MaxAge: auth.DefaultSessionConfig().MaxAge}),
+		tokens:   auth.NewTokenStore(auth.TokenConfig{StateFile: filepath.Join(dir, "tokens.json")}),
+	}
+}
+
+// request sends a request to a router and returns the response. A body is sent
+// as a form.
+func request(router http.Handler, method, target, body string, header http.Header) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	if body != "" {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	for name, values := range header {
+		req.Header[http.CanonicalHeaderKey(name)] = values
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
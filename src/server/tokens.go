@@ -0,0 +1,63 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/user/rss/src/auth"
+)
+
+// listTokens lists the API tokens of the current user
+func (s *Server) listTokens(c *gin.Context) {
+	tokens := s.tokens.List(currentUser(c))
+
+	result := make([]gin.H, 0, len(tokens))
+	for _, token := range tokens {
+		result = append(result, tokenJSON(token))
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+// createToken issues a new API token. The secret is only shown in this response.
+func (s *Server) createToken(c *gin.Context) {
+	scope := c.DefaultPostForm("scope", auth.ScopeRead)
+
+	// Tokens can't be given more rights than the credentials creating them
+	if !auth.Allows(c.GetString(scopeKey), scope) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "cannot grant a scope you don't have"})
+		return
+	}
+
+	secret, token, err := s.tokens.Create(currentUser(c), c.PostForm("name"), scope)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	result := tokenJSON(token)
+	result["token"] = secret
+	c.JSON(http.StatusCreated, result)
+}
+
+// revokeToken deletes one of the current user's API tokens
+func (s *Server) revokeToken(c *gin.Context) {
+	if err := s.tokens.Revoke(currentUser(c), c.Param("id")); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// tokenJSON describes a token without its secret
+func tokenJSON(token *auth.Token) gin.H {
+	result := gin.H{
+		"id":         token.ID,
+		"name":       token.Name,
+		"scope":      token.Scope,
+		"created_at": token.CreatedAt,
+	}
+	if !token.LastUsedAt.IsZero() {
+		result["last_used_at"] = token.LastUsedAt
+	}
+	return result
+}
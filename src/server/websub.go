@@ -0,0 +1,73 @@
+package server
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/user/rss/src/websub"
+)
+
+// maxPushSize limits the size of content pushed by hubs
+const maxPushSize = 10 << 20
+
+// verifyWebSub answers a hub's intent verification for a subscription
+func (s *Server) verifyWebSub(c *gin.Context) {
+	if s.subscriber == nil {
+		c.String(http.StatusNotFound, "WebSub is not enabled")
+		return
+	}
+
+	id := c.Param("id")
+	mode := c.Query("hub.mode")
+	topic := c.Query("hub.topic")
+
+	if mode == "denied" {
+		if err := s.subscriber.Deny(id, topic, c.Query("hub.reason")); err != nil {
+			c.String(http.StatusNotFound, err.Error())
+			return
+		}
+		c.String(http.StatusOK, "")
+		return
+	}
+
+	leaseSeconds, _ := strconv.Atoi(c.Query("hub.lease_seconds"))
+	challenge, err := s.subscriber.VerifyIntent(id, mode, topic, c.Query("hub.challenge"), leaseSeconds)
+	if err != nil {
+		c.String(http.StatusNotFound, err.Error())
+		return
+	}
+
+	c.Header("Content-Type", "text/plain")
+	c.String(http.StatusOK, challenge)
+}
+
+// receiveWebSub accepts content distribution requests from hubs
+func (s *Server) receiveWebSub(c *gin.Context) {
+	if s.subscriber == nil {
+		c.String(http.StatusNotFound, "WebSub is not enabled")
+		return
+	}
+
+	body, err := ioutil.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, maxPushSize))
+	if err != nil {
+		c.String(http.StatusRequestEntityTooLarge, err.Error())
+		return
+	}
+
+	_, err = s.subscriber.Deliver(c.Param("id"), c.GetHeader("X-Hub-Signature"), body)
+	switch err {
+	case nil:
+	case websub.ErrUnknownSubscription:
+		// Tell the hub to stop sending content for this callback
+		c.String(http.StatusGone, err.Error())
+		return
+	default:
+		// Invalid content is ignored but still acknowledged, as the spec requires
+		gin.DefaultWriter.Write([]byte(fmt.Sprintf("Ignoring WebSub delivery for %s: %v\n", c.Param("id"), err)))
+	}
+
+	c.Status(http.StatusAccepted)
+}
@@ -0,0 +1,69 @@
+package tui
+
+import (
+	"io"
+)
+
+// Key names returned by readKey for keys that are not a single character
+const (
+	keyUp       = "up"
+	keyDown     = "down"
+	keyLeft     = "left"
+	keyRight    = "right"
+	keyPageUp   = "pgup"
+	keyPageDown = "pgdn"
+	keyHome     = "home"
+	keyEnd      = "end"
+	keyEnter    = "enter"
+	keyEscape   = "esc"
+	keyBack     = "backspace"
+	keyCtrlC    = "ctrl-c"
+)
+
+// escapeKeys maps terminal escape sequences to key names
+var escapeKeys = map[string]string{
+	"\x1b[A":  keyUp,
+	"\x1b[B":  keyDown,
+	"\x1b[C":  keyRight,
+	"\x1b[D":  keyLeft,
+	"\x1bOA":  keyUp,
+	"\x1bOB":  keyDown,
+	"\x1bOC":  keyRight,
+	"\x1bOD":  keyLeft,
+	"\x1b[5~": keyPageUp,
+	"\x1b[6~": keyPageDown,
+	"\x1b[H":  keyHome,
+	"\x1b[F":  keyEnd,
+	"\x1b[1~": keyHome,
+	"\x1b[4~": keyEnd,
+}
+
+// readKey reads one key press from a terminal in raw mode. Terminals send an
+// escape sequence in a single write, so one read returns all of it.
+func readKey(in io.Reader) (string, error) {
+	buf := make([]byte, 16)
+	n, err := in.Read(buf)
+	if err != nil {
+		return "", err
+	}
+	input := string(buf[:n])
+
+	switch input {
+	case "\r", "\n":
+		return keyEnter, nil
+	case "\x1b":
+		return keyEscape, nil
+	case "\x7f", "\x08":
+		return keyBack, nil
+	case "\x03":
+		return keyCtrlC, nil
+	}
+	if key, ok := escapeKeys[input]; ok {
+		return key, nil
+	}
+	if input[0] == '\x1b' {
+		// Unknown sequence
+		return "", nil
+	}
+	return input[:1], nil
+}
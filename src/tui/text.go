@@ -0,0 +1,263 @@
+package tui
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/net/html"
+)
+
+// blockTags start and end a paragraph
+var blockTags = map[string]bool{
+	"p": true, "div": true, "section": true, "article": true, "header": true, "footer": true,
+	"h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true,
+	"blockquote": true, "ul": true, "ol": true, "table": true, "tr": true,
+	"figure": true, "figcaption": true, "pre": true, "hr": true, "dl": true, "dt": true, "dd": true,
+}
+
+// htmlToText converts HTML content into plain text paragraphs. Links are numbered
+// in the text like "[1]" and their targets returned in that order.
+func htmlToText(content string) (string, []string) {
+	tokenizer := html.NewTokenizer(strings.NewReader(content))
+	text := &textBuilder{}
+	links := make([]string, 0)
+	hrefs := make([]string, 0)
+	skip, pre := 0, 0
+
+	for {
+		switch tokenizer.Next() {
+		case html.ErrorToken:
+			for i, link := range links {
+				links[i] = clean(link)
+			}
+			return clean(text.String()), links
+		case html.TextToken:
+			if skip > 0 {
+				continue
+			}
+			if pre > 0 {
+				text.raw(string(tokenizer.Text()))
+			} else {
+				text.words(string(tokenizer.Text()))
+			}
+		case html.StartTagToken, html.SelfClosingTagToken:
+			name, hasAttr := tokenizer.TagName()
+			attrs := attributes(tokenizer, hasAttr)
+			tag := string(name)
+			switch {
+			case tag == "script" || tag == "style" || tag == "noscript":
+				skip++
+			case tag == "br":
+				text.newline()
+			case tag == "li":
+				if text.breaks == 0 {
+					text.newline()
+				}
+				text.raw("  • ")
+			case tag == "img":
+				if alt := strings.TrimSpace(attrs["alt"]); alt != "" {
+					text.words("[image: " + alt + "]")
+				}
+			case tag == "a":
+				hrefs = append(hrefs, attrs["href"])
+			case blockTags[tag]:
+				text.paragraph()
+				if tag == "pre" {
+					pre++
+				}
+			}
+		case html.EndTagToken:
+			name, _ := tokenizer.TagName()
+			tag := string(name)
+			switch {
+			case tag == "script" || tag == "style" || tag == "noscript":
+				if skip > 0 {
+					skip--
+				}
+			case tag == "a":
+				if len(hrefs) == 0 {
+					continue
+				}
+				href := hrefs[len(hrefs)-1]
+				hrefs = hrefs[:len(hrefs)-1]
+				if href != "" && !strings.HasPrefix(href, "#") && skip == 0 {
+					links = append(links, href)
+					text.space = true
+					text.words(fmt.Sprintf("[%d]", len(links)))
+				}
+			case blockTags[tag]:
+				if tag == "pre" && pre > 0 {
+					pre--
+				}
+				text.paragraph()
+			}
+		}
+	}
+}
+
+// attributes returns the attributes of the current tag
+func attributes(tokenizer *html.Tokenizer, hasAttr bool) map[string]string {
+	attrs := make(map[string]string)
+	for hasAttr {
+		var key, value []byte
+		key, value, hasAttr = tokenizer.TagAttr()
+		attrs[string(key)] = string(value)
+	}
+	return attrs
+}
+
+// textBuilder collects text, collapsing whitespace outside of preformatted blocks
+type textBuilder struct {
+	builder strings.Builder
+	// breaks is the number of newlines at the end of the text
+	breaks int
+	space  bool
+}
+
+// words appends text with whitespace collapsed into single spaces
+func (t *textBuilder) words(text string) {
+	fields := strings.Fields(text)
+	if len(fields) == 0 {
+		if text != "" && t.builder.Len() > 0 && t.breaks == 0 {
+			t.space = true
+		}
+		return
+	}
+
+	leading := strings.TrimLeft(text, " \t\r\n") != text
+	if (leading || t.space) && t.builder.Len() > 0 && t.breaks == 0 {
+		t.builder.WriteString(" ")
+	}
+	t.builder.WriteString(strings.Join(fields, " "))
+	t.breaks = 0
+	t.space = strings.TrimRight(text, " \t\r\n") != text
+}
+
+// raw appends text as it is
+func (t *textBuilder) raw(text string) {
+	if text == "" {
+		return
+	}
+	if t.space && t.breaks == 0 {
+		t.builder.WriteString(" ")
+	}
+	t.builder.WriteString(text)
+	t.space = false
+	t.breaks = len(text) - len(strings.TrimRight(text, "\n"))
+}
+
+// newline ends the current line
+func (t *textBuilder) newline() {
+	if t.builder.Len() == 0 {
+		return
+	}
+	t.builder.WriteString("\n")
+	t.breaks++
+	t.space = false
+}
+
+// paragraph ends the current paragraph with a blank line
+func (t *textBuilder) paragraph() {
+	for t.builder.Len() > 0 && t.breaks < 2 {
+		t.newline()
+	}
+}
+
+// String returns the text without trailing blank lines
+func (t *textBuilder) String() string {
+	return strings.TrimRight(t.builder.String(), "\n ")
+}
+
+// wrap breaks text into lines of at most width characters. Continuation lines
+// keep the indentation of the line they belong to, list items hang under their bullet.
+func wrap(text string, width int) []string {
+	if width < 10 {
+		width = 10
+	}
+
+	lines := make([]string, 0)
+	for _, line := range strings.Split(text, "\n") {
+		words := strings.Fields(line)
+		if len(words) == 0 {
+			lines = append(lines, "")
+			continue
+		}
+
+		indent := len(line) - len(strings.TrimLeft(line, " "))
+		if indent > width/2 {
+			indent = width / 2
+		}
+		hanging := indent
+		if words[0] == "•" {
+			hanging += 2
+		}
+
+		current := strings.Repeat(" ", indent)
+		length, empty := indent, true
+		flush := func() {
+			lines = append(lines, current)
+			current = strings.Repeat(" ", hanging)
+			length, empty = hanging, true
+		}
+
+		for _, word := range words {
+			// Split words that don't fit on a line of their own
+			for utf8.RuneCountInString(word) > width-hanging {
+				if !empty {
+					flush()
+				}
+				runes := []rune(word)
+				current += string(runes[:width-length])
+				word = string(runes[width-length:])
+				flush()
+			}
+
+			n := utf8.RuneCountInString(word)
+			if !empty && length+1+n > width {
+				flush()
+			}
+			if !empty {
+				current += " "
+				length++
+			}
+			current += word
+			length += n
+			empty = false
+		}
+		lines = append(lines, current)
+	}
+	return lines
+}
+
+// clean removes control characters, so feed content cannot send escape
+// sequences to the terminal
+func clean(text string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '\n' {
+			return r
+		}
+		if r == '\t' {
+			return ' '
+		}
+		if r < 0x20 || (r >= 0x7f && r < 0xa0) {
+			return -1
+		}
+		return r
+	}, text)
+}
+
+// truncate shortens text to at most width characters
+func truncate(text string, width int) string {
+	if width <= 0 {
+		return ""
+	}
+	if utf8.RuneCountInString(text) <= width {
+		return text
+	}
+	runes := []rune(text)
+	if width == 1 {
+		return string(runes[:1])
+	}
+	return string(runes[:width-1]) + "…"
+}
@@ -0,0 +1,559 @@
+package tui
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/user/rss/src/parser"
+	"golang.org/x/term"
+)
+
+// view is one of the screens of the reader
+type view int
+
+const (
+	feedsView view = iota
+	itemsView
+	readerView
+)
+
+// Terminal control sequences
+const (
+	enterScreen = "\x1b[?1049h\x1b[?25l"
+	leaveScreen = "\x1b[?25h\x1b[?1049l"
+	clearScreen = "\x1b[H\x1b[2J"
+	reverse     = "\x1b[7m"
+	bold        = "\x1b[1m"
+	dim         = "\x1b[2m"
+	reset       = "\x1b[0m"
+)
+
+// feedEntry is a line of the subscription list. A nil feed stands for all items.
+type feedEntry struct {
+	feed   *parser.Feed
+	title  string
+	unread int
+}
+
+// App is a terminal reader for a user's subscriptions
+type App struct {
+	storage *parser.Storage
+	user    string
+	in      *os.File
+	out     *bufio.Writer
+	// cooked is the terminal state from before raw mode
+	cooked *term.State
+	width  int
+	height int
+
+	view       view
+	feeds      []feedEntry
+	feedCursor int
+	items      []parser.StoredItem
+	itemCursor int
+	lines      []string
+	links      []string
+	scroll     int
+	status     string
+}
+
+// NewApp creates a terminal reader for a user's subscriptions
+func NewApp(storage *parser.Storage, user string) *App {
+	return &App{
+		storage: storage,
+		user:    user,
+		width:   80,
+		height:  24,
+	}
+}
+
+// Run shows the reader on the terminal until the user quits
+func (a *App) Run(in *os.File, out io.Writer) error {
+	fd := int(in.Fd())
+	if !term.IsTerminal(fd) {
+		return errors.New("the terminal reader needs an interactive terminal")
+	}
+
+	state, err := term.MakeRaw(fd)
+	if err != nil {
+		return err
+	}
+	a.in = in
+	a.cooked = state
+	a.out = bufio.NewWriter(out)
+	a.out.WriteString(enterScreen)
+	defer func() {
+		a.out.WriteString(leaveScreen)
+		a.out.Flush()
+		term.Restore(fd, state)
+	}()
+
+	a.loadFeeds()
+	for {
+		if width, height, err := term.GetSize(fd); err == nil {
+			a.width, a.height = width, height
+		}
+		a.render()
+
+		key, err := readKey(in)
+		if err != nil {
+			return err
+		}
+		if quit := a.handleKey(key); quit {
+			return nil
+		}
+	}
+}
+
+// handleKey reacts to a key press and returns true when the reader should exit
+func (a *App) handleKey(key string) bool {
+	a.status = ""
+	if key == keyCtrlC {
+		return true
+	}
+
+	switch a.view {
+	case feedsView:
+		switch key {
+		case "q", keyEscape:
+			return true
+		case "j", keyDown:
+			a.feedCursor = clamp(a.feedCursor+1, len(a.feeds))
+		case "k", keyUp:
+			a.feedCursor = clamp(a.feedCursor-1, len(a.feeds))
+		case keyPageDown, " ":
+			a.feedCursor = clamp(a.feedCursor+a.listHeight(), len(a.feeds))
+		case keyPageUp, "b":
+			a.feedCursor = clamp(a.feedCursor-a.listHeight(), len(a.feeds))
+		case "g", keyHome:
+			a.feedCursor = 0
+		case "G", keyEnd:
+			a.feedCursor = clamp(len(a.feeds)-1, len(a.feeds))
+		case keyEnter, "l", keyRight:
+			if len(a.feeds) > 0 {
+				a.loadItems()
+				a.view = itemsView
+			}
+		case "A":
+			a.markAllRead()
+			a.loadFeeds()
+		}
+
+	case itemsView:
+		switch key {
+		case "q", keyEscape, "h", keyLeft, keyBack:
+			a.loadFeeds()
+			a.view = feedsView
+		case "j", keyDown:
+			a.itemCursor = clamp(a.itemCursor+1, len(a.items))
+		case "k", keyUp:
+			a.itemCursor = clamp(a.itemCursor-1, len(a.items))
+		case keyPageDown, " ":
+			a.itemCursor = clamp(a.itemCursor+a.listHeight(), len(a.items))
+		case keyPageUp, "b":
+			a.itemCursor = clamp(a.itemCursor-a.listHeight(), len(a.items))
+		case "g", keyHome:
+			a.itemCursor = 0
+		case "G", keyEnd:
+			a.itemCursor = clamp(len(a.items)-1, len(a.items))
+		case keyEnter, "l", keyRight:
+			if len(a.items) > 0 {
+				a.openItem()
+			}
+		case "r":
+			a.toggleRead()
+		case "o":
+			a.openLink()
+		case "A":
+			a.markAllRead()
+			a.loadItems()
+		}
+
+	case readerView:
+		page := a.height - 4
+		switch key {
+		case "q", keyEscape, "h", keyLeft, keyBack:
+			a.view = itemsView
+		case "j", keyDown:
+			a.scrollBy(1)
+		case "k", keyUp:
+			a.scrollBy(-1)
+		case keyPageDown, " ":
+			a.scrollBy(page)
+		case keyPageUp, "b":
+			a.scrollBy(-page)
+		case "g", keyHome:
+			a.scroll = 0
+		case "G", keyEnd:
+			a.scrollBy(len(a.lines))
+		case "n":
+			if a.itemCursor < len(a.items)-1 {
+				a.itemCursor++
+				a.openItem()
+			}
+		case "p":
+			if a.itemCursor > 0 {
+				a.itemCursor--
+				a.openItem()
+			}
+		case "r":
+			a.toggleRead()
+		case "o":
+			a.openLink()
+		}
+	}
+	return false
+}
+
+// loadFeeds reads the user's subscriptions and unread counts
+func (a *App) loadFeeds() {
+	unread := make(map[string]int)
+	total := 0
+	for _, item := range a.storage.Items(a.user) {
+		if !item.Read {
+			unread[item.FeedURL]++
+			total++
+		}
+	}
+
+	subscriptions := a.storage.Subscriptions(a.user)
+	sort.Slice(subscriptions, func(i, j int) bool {
+		if subscriptions[i].Folder != subscriptions[j].Folder {
+			return subscriptions[i].Folder < subscriptions[j].Folder
+		}
+		return strings.ToLower(subscriptions[i].Title) < strings.ToLower(subscriptions[j].Title)
+	})
+
+	a.feeds = []feedEntry{{title: "All items", unread: total}}
+	for _, feed := range subscriptions {
+		title := clean(feed.Title)
+		if title == "" {
+			title = feed.URL
+		}
+		if feed.Folder != "" {
+			title = feed.Folder + " / " + title
+		}
+		a.feeds = append(a.feeds, feedEntry{feed: feed, title: title, unread: unread[feed.URL]})
+	}
+	a.feedCursor = clamp(a.feedCursor, len(a.feeds))
+}
+
+// loadItems reads the items of the selected feed, newest first
+func (a *App) loadItems() {
+	selected := a.feeds[a.feedCursor].feed
+	a.items = make([]parser.StoredItem, 0)
+	for _, item := range a.storage.Items(a.user) {
+		if selected == nil || item.FeedURL == selected.URL {
+			a.items = append(a.items, item)
+		}
+	}
+	sort.SliceStable(a.items, func(i, j int) bool {
+		return a.items[i].Item.PublishedAt.After(a.items[j].Item.PublishedAt)
+	})
+	a.itemCursor = clamp(a.itemCursor, len(a.items))
+	if len(a.items) == 0 {
+		a.status = "No items yet, they are cached when the server refreshes the feed"
+	}
+}
+
+// openItem shows the selected item and marks it read
+func (a *App) openItem() {
+	item := &a.items[a.itemCursor]
+	content := item.Item.Content
+	if content == "" {
+		content = item.Item.Description
+	}
+	text, links := htmlToText(content)
+	if text == "" {
+		text = "(no content)"
+	}
+	a.links = links
+	a.lines = wrap(text, a.width-4)
+	a.scroll = 0
+	a.view = readerView
+
+	if !item.Read {
+		a.setRead(item, true)
+	}
+}
+
+// toggleRead flips the read state of the selected item
+func (a *App) toggleRead() {
+	if len(a.items) == 0 {
+		return
+	}
+	item := &a.items[a.itemCursor]
+	a.setRead(item, !item.Read)
+	if item.Read {
+		a.status = "Marked as read"
+	} else {
+		a.status = "Marked as unread"
+	}
+}
+
+// setRead stores the read state of an item
+func (a *App) setRead(item *parser.StoredItem, read bool) {
+	if err := a.storage.SetRead(a.user, []int64{item.Item.ID}, read); err != nil {
+		a.status = "Error: " + err.Error()
+		return
+	}
+	item.Read = read
+}
+
+// markAllRead marks all items of the selected feed as read
+func (a *App) markAllRead() {
+	selected := a.feeds[a.feedCursor].feed
+	var err error
+	if selected == nil {
+		err = a.storage.MarkFolderRead(a.user, "", time.Time{})
+	} else {
+		err = a.storage.MarkFeedRead(a.user, selected.URL, time.Time{})
+	}
+	if err != nil {
+		a.status = "Error: " + err.Error()
+		return
+	}
+	a.status = "Marked all items as read"
+}
+
+// openLink opens the selected item's link in a browser. Without one, as over
+// SSH, the link is shown so it can be copied.
+func (a *App) openLink() {
+	if len(a.items) == 0 {
+		return
+	}
+	link := a.items[a.itemCursor].Item.Link
+	if link == "" {
+		a.status = "This item has no link"
+		return
+	}
+
+	if browser := os.Getenv("BROWSER"); browser != "" {
+		// Text browsers need the terminal, so hand it over until they exit
+		a.out.WriteString(leaveScreen)
+		a.out.Flush()
+		fd := int(a.in.Fd())
+		term.Restore(fd, a.cooked)
+		cmd := exec.Command(browser, link)
+		cmd.Stdin, cmd.Stdout, cmd.Stderr = a.in, os.Stdout, os.Stderr
+		err := cmd.Run()
+		if _, rawErr := term.MakeRaw(fd); rawErr != nil && err == nil {
+			err = rawErr
+		}
+		a.out.WriteString(enterScreen)
+		if err != nil {
+			a.status = fmt.Sprintf("Could not run %s: %v", browser, err)
+		}
+		return
+	}
+
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("open", link)
+	case "windows":
+		cmd = exec.Command("rundll32", "url.dll,FileProtocolHandler", link)
+	default:
+		if os.Getenv("DISPLAY") == "" && os.Getenv("WAYLAND_DISPLAY") == "" {
+			a.status = "Link: " + clean(link)
+			return
+		}
+		cmd = exec.Command("xdg-open", link)
+	}
+	if err := cmd.Start(); err != nil {
+		a.status = "Link: " + clean(link)
+		return
+	}
+	go cmd.Wait()
+	a.status = "Opened " + clean(link)
+}
+
+// scrollBy scrolls the reader, keeping the last page in view
+func (a *App) scrollBy(lines int) {
+	max := len(a.readerLines()) - (a.height - 4)
+	a.scroll += lines
+	if a.scroll > max {
+		a.scroll = max
+	}
+	if a.scroll < 0 {
+		a.scroll = 0
+	}
+}
+
+// listHeight is the number of list lines that fit on the screen
+func (a *App) listHeight() int {
+	if a.height < 5 {
+		return 1
+	}
+	return a.height - 4
+}
+
+// render draws the current view
+func (a *App) render() {
+	a.out.WriteString(clearScreen)
+
+	switch a.view {
+	case feedsView:
+		a.header("Subscriptions")
+		lines := make([]string, len(a.feeds))
+		for i, entry := range a.feeds {
+			count := ""
+			if entry.unread > 0 {
+				count = fmt.Sprintf("%d", entry.unread)
+			}
+			lines[i] = fmt.Sprintf("%5s  %s", count, entry.title)
+		}
+		a.list(lines, a.feedCursor, func(i int) bool { return a.feeds[i].unread > 0 })
+		a.footer("j/k move  enter open  A mark all read  q quit")
+
+	case itemsView:
+		a.header(a.feeds[a.feedCursor].title)
+		lines := make([]string, len(a.items))
+		for i, item := range a.items {
+			marker := " "
+			if !item.Read {
+				marker = "●"
+			}
+			if item.Saved {
+				marker += "★"
+			} else {
+				marker += " "
+			}
+			date := "      "
+			if !item.Item.PublishedAt.IsZero() {
+				date = item.Item.PublishedAt.Format("Jan 02")
+			}
+			lines[i] = fmt.Sprintf(" %s %s  %s", marker, date, clean(item.Item.Title))
+		}
+		a.list(lines, a.itemCursor, func(i int) bool { return !a.items[i].Read })
+		a.footer("j/k move  enter read  r toggle read  o open  A all read  q back")
+
+	case readerView:
+		item := a.items[a.itemCursor]
+		a.header(clean(item.Item.Title))
+		lines := a.readerLines()
+		end := a.scroll + a.height - 4
+		if end > len(lines) {
+			end = len(lines)
+		}
+		for _, line := range lines[a.scroll:end] {
+			a.line("  " + line)
+		}
+		for i := end - a.scroll; i < a.height-4; i++ {
+			a.line("")
+		}
+		position := 100
+		if len(lines) > a.height-4 {
+			position = end * 100 / len(lines)
+		}
+		a.footer(fmt.Sprintf("%d%%  j/k scroll  n/p next/prev  r toggle read  o open  q back", position))
+	}
+
+	a.out.Flush()
+}
+
+// readerLines returns the lines of the reader: item details, the text and its links
+func (a *App) readerLines() []string {
+	item := a.items[a.itemCursor]
+	lines := make([]string, 0, len(a.lines)+len(a.links)+6)
+	details := clean(item.FeedURL)
+	for _, entry := range a.feeds {
+		if entry.feed != nil && entry.feed.URL == item.FeedURL {
+			details = entry.title
+		}
+	}
+	if !item.Item.PublishedAt.IsZero() {
+		details += " · " + item.Item.PublishedAt.Local().Format("Mon, 02 Jan 2006 15:04")
+	}
+	width := a.width - 4
+	lines = append(lines, dim+truncate(details, width)+reset)
+	if item.Item.Link != "" {
+		lines = append(lines, dim+truncate(clean(item.Item.Link), width)+reset)
+	}
+	lines = append(lines, "")
+	lines = append(lines, a.lines...)
+
+	if len(a.links) > 0 {
+		lines = append(lines, "", bold+"Links"+reset)
+		for i, link := range a.links {
+			lines = append(lines, wrap(fmt.Sprintf("[%d] %s", i+1, link), width)...)
+		}
+	}
+	return lines
+}
+
+// header draws the title bar and a blank line
+func (a *App) header(title string) {
+	a.line(reverse + bold + pad(" "+title, a.width) + reset)
+	a.line("")
+}
+
+// footer draws the status or key help at the bottom
+func (a *App) footer(help string) {
+	text := help
+	if a.status != "" {
+		text = a.status
+	}
+	a.out.WriteString("\r\n" + reverse + pad(" "+text, a.width) + reset)
+}
+
+// list draws lines with the cursor line highlighted, scrolled so it stays visible
+func (a *App) list(lines []string, cursor int, highlight func(int) bool) {
+	height := a.listHeight()
+	offset := 0
+	if cursor >= height {
+		offset = cursor - height + 1
+	}
+
+	for i := offset; i < offset+height; i++ {
+		if i >= len(lines) {
+			a.line("")
+			continue
+		}
+		line := pad(lines[i], a.width)
+		switch {
+		case i == cursor:
+			a.line(reverse + line + reset)
+		case highlight(i):
+			a.line(bold + line + reset)
+		default:
+			a.line(line)
+		}
+	}
+}
+
+// line writes a line, cut to the terminal width
+func (a *App) line(text string) {
+	if !strings.Contains(text, "\x1b") {
+		text = truncate(text, a.width)
+	}
+	a.out.WriteString(text + "\r\n")
+}
+
+// pad cuts or pads text to exactly width characters
+func pad(text string, width int) string {
+	text = truncate(text, width)
+	if n := width - len([]rune(text)); n > 0 {
+		text += strings.Repeat(" ", n)
+	}
+	return text
+}
+
+// clamp keeps a cursor within a list of n entries
+func clamp(cursor, n int) int {
+	if cursor >= n {
+		cursor = n - 1
+	}
+	if cursor < 0 {
+		cursor = 0
+	}
+	return cursor
+}
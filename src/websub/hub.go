@@ -0,0 +1,371 @@
+package websub
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/user/rss/src/netguard"
+	"github.com/user/rss/src/parser"
+)
+
+// ErrUnsupportedTopic is returned for subscription requests to topics this hub doesn't serve
+var ErrUnsupportedTopic = errors.New("topic is not served by this hub")
+
+// HubSubscription represents a subscriber registered with our hub
+type HubSubscription struct {
+	Topic     string    `json:"topic"`
+	FeedURL   string    `json:"feed_url"`
+	Callback  string    `json:"callback"`
+	Secret    string    `json:"secret,omitempty"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// HubConfig holds configuration for the built-in hub
+type HubConfig struct {
+	// HubURL is the public URL of the hub endpoint
+	HubURL       string
+	StateFile    string
+	DefaultLease time.Duration
+	MaxLease     time.Duration
+}
+
+// DefaultHubConfig returns a default configuration
+func DefaultHubConfig() HubConfig {
+	return HubConfig{
+		StateFile:    "websub_hub.json",
+		DefaultLease: 24 * time.Hour,
+		MaxLease:     30 * 24 * time.Hour,
+	}
+}
+
+// TopicResolver maps a topic URL to the feed it publishes, reporting false if it isn't ours
+type TopicResolver func(topic string) (string, bool)
+
+// Hub is a minimal WebSub hub for the feeds this server exports
+type Hub struct {
+	config        HubConfig
+	subscriptions map[string]*HubSubscription
+	resolve       TopicResolver
+	mutex         sync.RWMutex
+	saveMutex     sync.Mutex
+	client        *http.Client
+	// pending tracks verifications and deliveries running in the background
+	pending sync.WaitGroup
+}
+
+// NewHub creates a new hub instance
+func NewHub(config HubConfig) *Hub {
+	h := &Hub{
+		config:        config,
+		subscriptions: make(map[string]*HubSubscription),
+		client:        netguard.Client(30 * time.Second),
+	}
+
+	if err := h.loadState(); err != nil {
+		log.Printf("Warning: Failed to load WebSub hub subscriptions: %v", err)
+	}
+	return h
+}
+
+// URL returns the public URL of the hub
+func (h *Hub) URL() string {
+	return h.config.HubURL
+}
+
+// SetTopicResolver sets the function deciding which topics the hub accepts
+func (h *Hub) SetTopicResolver(resolve TopicResolver) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.resolve = resolve
+}
+
+// HandleRequest validates a subscription request and verifies the subscriber's intent in the background
+func (h *Hub) HandleRequest(mode, topic, callback, secret string, leaseSeconds int) error {
+	if mode != "subscribe" && mode != "unsubscribe" {
+		return fmt.Errorf("unsupported mode: %s", mode)
+	}
+
+	callbackURL, err := url.Parse(callback)
+	if err != nil || (callbackURL.Scheme != "http" && callbackURL.Scheme != "https") || callbackURL.Host == "" {
+		return errors.New("hub.callback must be an absolute http(s) URL")
+	}
+	// Anyone can subscribe, so the hub must not be usable to reach the internal network.
+	// The client checks the address again when connecting, which covers redirects.
+	if err := netguard.CheckHost(context.Background(), callbackURL.Hostname()); err != nil {
+		return errors.New("hub.callback must be a public address")
+	}
+	if len(secret) >= 200 {
+		return errors.New("hub.secret must be less than 200 bytes")
+	}
+
+	h.mutex.RLock()
+	resolve := h.resolve
+	h.mutex.RUnlock()
+
+	feedURL, ok := "", false
+	if resolve != nil {
+		feedURL, ok = resolve(topic)
+	}
+	if !ok {
+		return ErrUnsupportedTopic
+	}
+
+	lease := h.config.DefaultLease
+	if leaseSeconds > 0 {
+		lease = time.Duration(leaseSeconds) * time.Second
+	}
+	if h.config.MaxLease > 0 && lease > h.config.MaxLease {
+		lease = h.config.MaxLease
+	}
+
+	sub := HubSubscription{
+		Topic:    topic,
+		FeedURL:  feedURL,
+		Callback: callback,
+		Secret:   secret,
+	}
+	h.pending.Add(1)
+	go func() {
+		defer h.pending.Done()
+		h.verify(mode, sub, lease)
+	}()
+	return nil
+}
+
+// Publish pushes content of a feed to all of its subscribers
+func (h *Hub) Publish(feedURL, contentType string, body []byte) {
+	now := time.Now()
+
+	h.mutex.RLock()
+	targets := make([]HubSubscription, 0)
+	for _, sub := range h.subscriptions {
+		if sub.FeedURL == feedURL && sub.ExpiresAt.After(now) {
+			targets = append(targets, *sub)
+		}
+	}
+	h.mutex.RUnlock()
+
+	for _, sub := range targets {
+		h.pending.Add(1)
+		go func(sub HubSubscription) {
+			defer h.pending.Done()
+			h.deliver(sub, contentType, body)
+		}(sub)
+	}
+}
+
+// Wait blocks until background verifications and deliveries have finished
+func (h *Hub) Wait() {
+	h.pending.Wait()
+}
+
+// Feeds returns the URLs of feeds that currently have subscribers
+func (h *Hub) Feeds() []string {
+	now := time.Now()
+
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+
+	seen := make(map[string]bool)
+	feeds := make([]string, 0)
+	for _, sub := range h.subscriptions {
+		if sub.ExpiresAt.After(now) && !seen[sub.FeedURL] {
+			seen[sub.FeedURL] = true
+			feeds = append(feeds, sub.FeedURL)
+		}
+	}
+	return feeds
+}
+
+// HasSubscribers returns true if a feed has at least one active subscriber
+func (h *Hub) HasSubscribers(feedURL string) bool {
+	now := time.Now()
+
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+
+	for _, sub := range h.subscriptions {
+		if sub.FeedURL == feedURL && sub.ExpiresAt.After(now) {
+			return true
+		}
+	}
+	return false
+}
+
+// PollFeeds refreshes subscribed feeds so new items are detected and published
+func (h *Hub) PollFeeds(storage *parser.Storage) error {
+	var lastErr error
+	for _, feedURL := range h.Feeds() {
+		if _, err := storage.GetFeed(feedURL); err != nil {
+			log.Printf("Hub: error refreshing %s: %v", feedURL, err)
+			lastErr = err
+		}
+	}
+	return lastErr
+}
+
+// ExpireSubscriptions removes subscriptions whose lease has run out
+func (h *Hub) ExpireSubscriptions() error {
+	now := time.Now()
+
+	h.mutex.Lock()
+	removed := 0
+	for key, sub := range h.subscriptions {
+		if !sub.ExpiresAt.After(now) {
+			delete(h.subscriptions, key)
+			removed++
+		}
+	}
+	h.mutex.Unlock()
+
+	if removed == 0 {
+		return nil
+	}
+	log.Printf("Hub: expired %d subscriptions", removed)
+	return h.saveState()
+}
+
+// verify confirms the subscriber's intent and applies the request if confirmed
+func (h *Hub) verify(mode string, sub HubSubscription, lease time.Duration) {
+	challenge, err := randomToken(16)
+	if err != nil {
+		log.Printf("Hub: error creating challenge: %v", err)
+		return
+	}
+
+	callbackURL, _ := url.Parse(sub.Callback)
+	query := callbackURL.Query()
+	query.Set("hub.mode", mode)
+	query.Set("hub.topic", sub.Topic)
+	query.Set("hub.challenge", challenge)
+	if mode == "subscribe" {
+		query.Set("hub.lease_seconds", fmt.Sprint(int(lease.Seconds())))
+	}
+	callbackURL.RawQuery = query.Encode()
+
+	resp, err := h.client.Get(callbackURL.String())
+	if err != nil {
+		log.Printf("Hub: verification of %s failed: %v", sub.Callback, err)
+		return
+	}
+	defer resp.Body.Close()
+
+	body, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 || strings.TrimSpace(string(body)) != challenge {
+		log.Printf("Hub: %s not confirmed by %s", mode, sub.Callback)
+		return
+	}
+
+	key := sub.FeedURL + "\n" + sub.Callback
+	h.mutex.Lock()
+	if mode == "subscribe" {
+		sub.ExpiresAt = time.Now().Add(lease)
+		h.subscriptions[key] = &sub
+	} else {
+		delete(h.subscriptions, key)
+	}
+	h.mutex.Unlock()
+
+	log.Printf("Hub: %s confirmed for %s on %s", mode, sub.Callback, sub.Topic)
+	if err := h.saveState(); err != nil {
+		log.Printf("Hub: error saving subscriptions: %v", err)
+	}
+}
+
+// deliver sends content to a single subscriber
+func (h *Hub) deliver(sub HubSubscription, contentType string, body []byte) {
+	req, err := http.NewRequest("POST", sub.Callback, bytes.NewReader(body))
+	if err != nil {
+		log.Printf("Hub: error creating delivery to %s: %v", sub.Callback, err)
+		return
+	}
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Add("Link", fmt.Sprintf(`<%s>; rel="hub"`, h.config.HubURL))
+	req.Header.Add("Link", fmt.Sprintf(`<%s>; rel="self"`, sub.Topic))
+	if sub.Secret != "" {
+		mac := hmac.New(sha256.New, []byte(sub.Secret))
+		mac.Write(body)
+		req.Header.Set("X-Hub-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	resp, err := h.client.Do(req)
+	if err != nil {
+		log.Printf("Hub: delivery to %s failed: %v", sub.Callback, err)
+		return
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusGone:
+		// The subscriber no longer wants this content
+		h.mutex.Lock()
+		delete(h.subscriptions, sub.FeedURL+"\n"+sub.Callback)
+		h.mutex.Unlock()
+		if err := h.saveState(); err != nil {
+			log.Printf("Hub: error saving subscriptions: %v", err)
+		}
+	case resp.StatusCode < 200 || resp.StatusCode >= 300:
+		log.Printf("Hub: delivery to %s returned %s", sub.Callback, resp.Status)
+	}
+}
+
+// loadState loads hub subscriptions from the state file
+func (h *Hub) loadState() error {
+	data, err := ioutil.ReadFile(h.config.StateFile)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var subs []*HubSubscription
+	if err := json.Unmarshal(data, &subs); err != nil {
+		return err
+	}
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	for _, sub := range subs {
+		h.subscriptions[sub.FeedURL+"\n"+sub.Callback] = sub
+	}
+	return nil
+}
+
+// saveState writes hub subscriptions to the state file
+func (h *Hub) saveState() error {
+	h.saveMutex.Lock()
+	defer h.saveMutex.Unlock()
+
+	h.mutex.RLock()
+	subs := make([]HubSubscription, 0, len(h.subscriptions))
+	for _, sub := range h.subscriptions {
+		subs = append(subs, *sub)
+	}
+	h.mutex.RUnlock()
+
+	data, err := json.MarshalIndent(subs, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tempFile := h.config.StateFile + ".tmp"
+	if err := ioutil.WriteFile(tempFile, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tempFile, h.config.StateFile)
+}
@@ -0,0 +1,30 @@
+package websub
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestHubRefusesInternalCallbacks(t *testing.T) {
+	config := DefaultHubConfig()
+	config.StateFile = filepath.Join(t.TempDir(), "websub_hub.json")
+	hub := NewHub(config)
+	hub.SetTopicResolver(func(topic string) (string, bool) {
+		return "http://example.com/feed.xml", true
+	})
+
+	for _, callback := range []string{
+		"http://127.0.0.1:8080/callback",
+		"http://localhost/callback",
+		"http://[::1]/callback",
+		"http://10.0.0.5/callback",
+		"http://192.168.1.1/callback",
+		"http://169.254.169.254/latest/meta-data/",
+	} {
+		err := hub.HandleRequest("subscribe", "http://rss.example.com/export?url=x", callback, "", 0)
+		if err == nil {
+			t.Errorf("callback %s was accepted", callback)
+		}
+	}
+	hub.Wait()
+}
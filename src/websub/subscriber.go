@@ -0,0 +1,448 @@
+package websub
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/user/rss/src/netguard"
+	"github.com/user/rss/src/parser"
+)
+
+// Subscription states
+const (
+	StatePending       = "pending"
+	StateActive        = "active"
+	StateUnsubscribing = "unsubscribing"
+	StateDenied        = "denied"
+)
+
+// ErrUnknownSubscription is returned for callbacks that don't match a subscription
+var ErrUnknownSubscription = errors.New("unknown subscription")
+
+// ErrInvalidSignature is returned when pushed content fails signature validation
+var ErrInvalidSignature = errors.New("invalid content signature")
+
+// Subscription represents a subscription to a feed at a WebSub hub
+type Subscription struct {
+	ID           string    `json:"id"`
+	FeedURL      string    `json:"feed_url"`
+	Topic        string    `json:"topic"`
+	Hub          string    `json:"hub"`
+	Secret       string    `json:"secret"`
+	State        string    `json:"state"`
+	LeaseSeconds int       `json:"lease_seconds"`
+	RequestedAt  time.Time `json:"requested_at"`
+	ExpiresAt    time.Time `json:"expires_at"`
+}
+
+// SubscriberConfig holds configuration for the WebSub subscriber
+type SubscriberConfig struct {
+	// CallbackBase is the public base URL hubs use to reach this server
+	CallbackBase string
+	StateFile    string
+	LeaseSeconds int
+	RenewBefore  time.Duration
+}
+
+// DefaultSubscriberConfig returns a default configuration
+func DefaultSubscriberConfig() SubscriberConfig {
+	return SubscriberConfig{
+		StateFile:    "websub.json",
+		LeaseSeconds: 7 * 24 * 60 * 60,
+		RenewBefore:  time.Hour,
+	}
+}
+
+// Subscriber subscribes to WebSub hubs and merges pushed content into storage
+type Subscriber struct {
+	storage       *parser.Storage
+	config        SubscriberConfig
+	subscriptions map[string]*Subscription
+	mutex         sync.RWMutex
+	saveMutex     sync.Mutex
+	client        *http.Client
+}
+
+// NewSubscriber creates a new subscriber instance
+func NewSubscriber(storage *parser.Storage, config SubscriberConfig) *Subscriber {
+	s := &Subscriber{
+		storage:       storage,
+		config:        config,
+		subscriptions: make(map[string]*Subscription),
+		client:        netguard.Client(30 * time.Second),
+	}
+
+	if err := s.loadState(); err != nil {
+		log.Printf("Warning: Failed to load WebSub subscriptions: %v", err)
+	}
+	return s
+}
+
+// Subscribe subscribes to the hub advertised by a feed, if any
+func (s *Subscriber) Subscribe(feed *parser.Feed) error {
+	if feed == nil || feed.HubURL == "" {
+		return nil
+	}
+
+	topic := feed.SelfURL
+	if topic == "" {
+		topic = feed.URL
+	}
+
+	s.mutex.Lock()
+	sub := s.findByFeed(feed.URL)
+	if sub == nil {
+		id, err := randomToken(16)
+		if err != nil {
+			s.mutex.Unlock()
+			return err
+		}
+		secret, err := randomToken(32)
+		if err != nil {
+			s.mutex.Unlock()
+			return err
+		}
+		sub = &Subscription{
+			ID:      id,
+			FeedURL: feed.URL,
+			Secret:  secret,
+		}
+		s.subscriptions[id] = sub
+	}
+	sub.Topic = topic
+	sub.Hub = feed.HubURL
+	sub.State = StatePending
+	sub.RequestedAt = time.Now()
+	request := *sub
+	s.mutex.Unlock()
+
+	if err := s.request(request, "subscribe"); err != nil {
+		return err
+	}
+	log.Printf("Requested WebSub subscription for %s at %s", request.Topic, request.Hub)
+	return s.saveState()
+}
+
+// SubscribeAll subscribes to hubs of all feeds that don't have an active subscription yet
+func (s *Subscriber) SubscribeAll() {
+	for _, feed := range s.storage.GetAllFeeds() {
+		s.mutex.RLock()
+		sub := s.findByFeed(feed.URL)
+		active := sub != nil && sub.State == StateActive && sub.Hub == feed.HubURL
+		s.mutex.RUnlock()
+
+		if active {
+			continue
+		}
+		if err := s.Subscribe(feed); err != nil {
+			log.Printf("Error subscribing to hub for %s: %v", feed.URL, err)
+		}
+	}
+}
+
+// Unsubscribe cancels the hub subscription of a feed, if any
+func (s *Subscriber) Unsubscribe(feedURL string) error {
+	s.mutex.Lock()
+	sub := s.findByFeed(feedURL)
+	if sub == nil {
+		s.mutex.Unlock()
+		return nil
+	}
+	sub.State = StateUnsubscribing
+	request := *sub
+	s.mutex.Unlock()
+
+	if err := s.request(request, "unsubscribe"); err != nil {
+		return err
+	}
+	return s.saveState()
+}
+
+// VerifyIntent handles a hub's verification request and returns the challenge to echo
+func (s *Subscriber) VerifyIntent(id, mode, topic, challenge string, leaseSeconds int) (string, error) {
+	if err := s.applyIntent(id, mode, topic, leaseSeconds); err != nil {
+		return "", err
+	}
+	if err := s.saveState(); err != nil {
+		log.Printf("Error saving WebSub subscriptions: %v", err)
+	}
+	return challenge, nil
+}
+
+// applyIntent activates or removes the subscription a hub verified
+func (s *Subscriber) applyIntent(id, mode, topic string, leaseSeconds int) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	sub, ok := s.subscriptions[id]
+	if !ok {
+		return ErrUnknownSubscription
+	}
+	if topic != sub.Topic {
+		return fmt.Errorf("topic mismatch: expected %s", sub.Topic)
+	}
+
+	switch mode {
+	case "subscribe":
+		if sub.State != StatePending && sub.State != StateActive {
+			return errors.New("subscription was not requested")
+		}
+		sub.State = StateActive
+		sub.LeaseSeconds = leaseSeconds
+		if leaseSeconds > 0 {
+			sub.ExpiresAt = time.Now().Add(time.Duration(leaseSeconds) * time.Second)
+		} else {
+			sub.ExpiresAt = time.Time{}
+		}
+		log.Printf("WebSub subscription for %s is active for %ds", sub.Topic, leaseSeconds)
+	case "unsubscribe":
+		if sub.State != StateUnsubscribing {
+			return errors.New("unsubscription was not requested")
+		}
+		delete(s.subscriptions, id)
+		log.Printf("WebSub subscription for %s was removed", sub.Topic)
+	default:
+		return fmt.Errorf("unsupported mode: %s", mode)
+	}
+	return nil
+}
+
+// Deny records a hub's refusal of a subscription
+func (s *Subscriber) Deny(id, topic, reason string) error {
+	s.mutex.Lock()
+	sub, ok := s.subscriptions[id]
+	if !ok || sub.Topic != topic {
+		s.mutex.Unlock()
+		return ErrUnknownSubscription
+	}
+	sub.State = StateDenied
+	s.mutex.Unlock()
+
+	log.Printf("WebSub subscription for %s was denied: %s", topic, reason)
+	return s.saveState()
+}
+
+// Deliver validates pushed content and merges its items into the feed
+func (s *Subscriber) Deliver(id, signature string, body []byte) (int, error) {
+	s.mutex.RLock()
+	sub, ok := s.subscriptions[id]
+	var feedURL, secret string
+	if ok {
+		feedURL = sub.FeedURL
+		secret = sub.Secret
+	}
+	s.mutex.RUnlock()
+
+	if !ok {
+		return 0, ErrUnknownSubscription
+	}
+	if !validSignature(secret, signature, body) {
+		return 0, ErrInvalidSignature
+	}
+
+	feed, err := parser.ParseFeed(feedURL, body)
+	if err != nil {
+		return 0, err
+	}
+
+	added, err := s.storage.MergeItems(feedURL, feed.Items)
+	if err != nil {
+		return 0, err
+	}
+	log.Printf("WebSub delivered %d items (%d new) for %s", len(feed.Items), added, feedURL)
+	return added, nil
+}
+
+// RenewExpiring renews subscriptions whose lease is about to expire
+func (s *Subscriber) RenewExpiring() error {
+	now := time.Now()
+
+	s.mutex.RLock()
+	due := make([]string, 0)
+	for _, sub := range s.subscriptions {
+		if sub.State != StateActive || sub.ExpiresAt.IsZero() {
+			continue
+		}
+		if sub.ExpiresAt.Sub(now) <= s.config.RenewBefore {
+			due = append(due, sub.FeedURL)
+		}
+	}
+	s.mutex.RUnlock()
+
+	var lastErr error
+	for _, feedURL := range due {
+		feed, err := parser.FetchFeed(feedURL)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if feed.HubURL == "" {
+			// The publisher stopped advertising a hub; rely on polling again
+			s.mutex.Lock()
+			if sub := s.findByFeed(feedURL); sub != nil {
+				delete(s.subscriptions, sub.ID)
+			}
+			s.mutex.Unlock()
+			continue
+		}
+		if err := s.Subscribe(feed); err != nil {
+			lastErr = err
+		}
+	}
+	return lastErr
+}
+
+// Subscriptions returns a copy of all known subscriptions
+func (s *Subscriber) Subscriptions() []Subscription {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	subs := make([]Subscription, 0, len(s.subscriptions))
+	for _, sub := range s.subscriptions {
+		subs = append(subs, *sub)
+	}
+	return subs
+}
+
+// request sends a subscribe or unsubscribe request to the hub
+func (s *Subscriber) request(sub Subscription, mode string) error {
+	if s.config.CallbackBase == "" {
+		return errors.New("WebSub callback base URL is not configured")
+	}
+
+	form := url.Values{}
+	form.Set("hub.mode", mode)
+	form.Set("hub.topic", sub.Topic)
+	form.Set("hub.callback", s.callbackURL(sub.ID))
+	if mode == "subscribe" {
+		form.Set("hub.secret", sub.Secret)
+		if s.config.LeaseSeconds > 0 {
+			form.Set("hub.lease_seconds", fmt.Sprint(s.config.LeaseSeconds))
+		}
+	}
+
+	resp, err := s.client.PostForm(sub.Hub, form)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("hub rejected %s request: %s %s", mode, resp.Status, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
+// callbackURL returns the callback URL for a subscription
+func (s *Subscriber) callbackURL(id string) string {
+	return strings.TrimSuffix(s.config.CallbackBase, "/") + "/websub/callback/" + id
+}
+
+// findByFeed finds the subscription of a feed. The caller must hold the mutex.
+func (s *Subscriber) findByFeed(feedURL string) *Subscription {
+	for _, sub := range s.subscriptions {
+		if sub.FeedURL == feedURL {
+			return sub
+		}
+	}
+	return nil
+}
+
+// loadState loads subscriptions from the state file
+func (s *Subscriber) loadState() error {
+	data, err := ioutil.ReadFile(s.config.StateFile)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var subs []*Subscription
+	if err := json.Unmarshal(data, &subs); err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, sub := range subs {
+		s.subscriptions[sub.ID] = sub
+	}
+	return nil
+}
+
+// saveState writes subscriptions to the state file
+func (s *Subscriber) saveState() error {
+	s.saveMutex.Lock()
+	defer s.saveMutex.Unlock()
+
+	subs := s.Subscriptions()
+
+	data, err := json.MarshalIndent(subs, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tempFile := s.config.StateFile + ".tmp"
+	if err := ioutil.WriteFile(tempFile, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tempFile, s.config.StateFile)
+}
+
+// validSignature checks an X-Hub-Signature header of the form "method=hexdigest"
+func validSignature(secret, signature string, body []byte) bool {
+	parts := strings.SplitN(signature, "=", 2)
+	if len(parts) != 2 {
+		return false
+	}
+
+	var newHash func() hash.Hash
+	switch strings.ToLower(parts[0]) {
+	case "sha1":
+		newHash = sha1.New
+	case "sha256":
+		newHash = sha256.New
+	case "sha384":
+		newHash = sha512.New384
+	case "sha512":
+		newHash = sha512.New
+	default:
+		return false
+	}
+
+	expected, err := hex.DecodeString(parts[1])
+	if err != nil {
+		return false
+	}
+
+	mac := hmac.New(newHash, []byte(secret))
+	mac.Write(body)
+	return hmac.Equal(mac.Sum(nil), expected)
+}
+
+// randomToken returns a random hex string of n bytes
+func randomToken(n int) (string, error) {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
@@ -0,0 +1,73 @@
+package websub
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// newTestSubscriber returns a subscriber whose state file holds subs
+func newTestSubscriber(t *testing.T, subs ...*Subscription) *Subscriber {
+	t.Helper()
+	config := DefaultSubscriberConfig()
+	config.StateFile = filepath.Join(t.TempDir(), "websub.json")
+	data, err := json.Marshal(subs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(config.StateFile, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return NewSubscriber(nil, config)
+}
+
+// verifyIntent calls VerifyIntent, failing the test if it doesn't return
+func verifyIntent(t *testing.T, s *Subscriber, id, mode, topic string) (string, error) {
+	t.Helper()
+	type result struct {
+		challenge string
+		err       error
+	}
+	done := make(chan result, 1)
+	go func() {
+		challenge, err := s.VerifyIntent(id, mode, topic, "challenge", 3600)
+		done <- result{challenge, err}
+	}()
+	select {
+	case r := <-done:
+		return r.challenge, r.err
+	case <-time.After(5 * time.Second):
+		t.Fatal("VerifyIntent didn't return")
+		return "", nil
+	}
+}
+
+func TestVerifyIntent(t *testing.T) {
+	s := newTestSubscriber(t,
+		&Subscription{ID: "pending", FeedURL: "http://example.com/a", Topic: "http://example.com/a", State: StatePending},
+		&Subscription{ID: "leaving", FeedURL: "http://example.com/b", Topic: "http://example.com/b", State: StateUnsubscribing},
+	)
+
+	if _, err := verifyIntent(t, s, "pending", "subscribe", "http://example.com/other"); err == nil {
+		t.Error("a mismatched topic was verified")
+	}
+	challenge, err := verifyIntent(t, s, "pending", "subscribe", "http://example.com/a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if challenge != "challenge" {
+		t.Errorf("challenge = %q, want challenge", challenge)
+	}
+	if _, err := verifyIntent(t, s, "leaving", "unsubscribe", "http://example.com/b"); err != nil {
+		t.Fatal(err)
+	}
+
+	// The new state survives a restart
+	restarted := NewSubscriber(nil, s.config)
+	subs := restarted.Subscriptions()
+	if len(subs) != 1 || subs[0].ID != "pending" || subs[0].State != StateActive || subs[0].ExpiresAt.IsZero() {
+		t.Errorf("saved subscriptions = %+v, want only the active one", subs)
+	}
+}